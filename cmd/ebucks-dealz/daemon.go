@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/daemon"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+// scrapeWaitDelay is how long a scrape has to stop after being interrupted by the daemon stopping
+const scrapeWaitDelay = 30 * time.Second
+
+// runDaemon implements `ebucks-dealz daemon`: it scrapes on a schedule, renders the site after every successful
+// scrape and serves it, along with the history and health of the scrapes
+func runDaemon(g *globals, fs *flag.FlagSet, args []string) {
+	cfg := g.cfg
+	cfg.Var(fs, "schedule", "daemon.schedule", `cron expression of when to scrape, in local time, e.g. "5 0/6 * * *" for 5 past every 6th hour`)
+	cfg.Var(fs, "now", "daemon.runOnStart", "scrape as soon as the daemon starts, as well as on the schedule")
+	cfg.Var(fs, "max-attempts", "daemon.maxAttempts", "how many times a scrape is attempted before it is given up on until its next time")
+	cfg.Var(fs, "backoff", "daemon.backoff", "how long to wait before retrying a failed scrape, doubling for every retry after it")
+	cfg.Var(fs, "max-backoff", "daemon.maxBackoff", "longest wait before retrying a failed scrape")
+	cfg.Var(fs, "history", "daemon.history", "how many of the last scrapes to keep the history of")
+	cfg.Var(fs, "lock-file", "daemon.lockFile", "file locked while the daemon runs, to keep a second one from scraping into the same data dir (default the data dir with .lock appended)")
+	cfg.Var(fs, "addr", "serve.addr", "address on which to serve the site, /runs and /healthz")
+	cfg.Var(fs, "path-prefix", "serve.pathPrefix", "serve the site at this subpath and prefix page link URLs with it; should start with '/'")
+	cfg.Var(fs, "output-dir", "render.outputDir", "directory to write the rendered HTML pages to")
+	addSiteFlags(cfg, fs)
+	logger := g.parse(fs, args)
+	dc := cfg.Daemon
+
+	lockFile := dc.LockFile
+	if lockFile == "" {
+		lockFile = filepath.Clean(cfg.Storage.DataDir) + ".lock"
+	}
+	lock, err := daemon.Lock(lockFile)
+	if err != nil {
+		logging.Fatal(logger, "could not lock, is another daemon running?", logging.KeyError, err)
+	}
+	defer lock.Unlock()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	prefix := strings.TrimSuffix(cfg.Serve.PathPrefix, "/")
+	render := func() error {
+		site, err := latestSite(cfg.Storage.DataDir, g.site(prefix, logger), logger)
+		if err != nil {
+			return err
+		}
+		if err := renderSite(cfg.Render.OutputDir, site); err != nil {
+			return err
+		}
+		logger.Info("rendered site", "dir", cfg.Render.OutputDir, "products", len(site.Products))
+		return nil
+	}
+	// the site is served from the last data there is until the first scrape
+	if err := render(); err != nil {
+		logging.Fatal(logger, "could not render site", logging.KeyError, err)
+	}
+
+	// the schedule has been validated with the rest of the config
+	schedule, _ := daemon.ParseSchedule(dc.Schedule)
+	d, err := daemon.New(schedule, daemon.Config{
+		Job: func(ctx context.Context) error {
+			if err := scrapeProcess(ctx, g, logger); err != nil {
+				return err
+			}
+			if err := render(); err != nil {
+				return fmt.Errorf("render: %w", err)
+			}
+			return nil
+		},
+		MaxAttempts: dc.MaxAttempts,
+		Backoff:     dc.Backoff,
+		MaxBackoff:  dc.MaxBackoff,
+		History:     dc.History,
+		RunOnStart:  dc.RunOnStart,
+		Logger:      logger,
+	})
+	if err != nil {
+		logging.Fatal(logger, "could not create daemon", logging.KeyError, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/runs", d)
+	mux.Handle("/healthz", d)
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, renderedSiteHandler(cfg.Render.OutputDir, logger)))
+	server := &http.Server{Addr: cfg.Serve.Addr, Handler: mux}
+	go func() {
+		logger.Info("serving site", "addr", cfg.Serve.Addr, "pathPrefix", prefix)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal(logger, "server failed", logging.KeyError, err)
+		}
+	}()
+
+	logger.Info("daemon started", "schedule", schedule, "lockFile", lockFile)
+	if err := d.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		logging.Fatal(logger, "daemon failed", logging.KeyError, err)
+	}
+	server.Close()
+	logger.Info("daemon stopped")
+}
+
+// scrapeProcess runs `ebucks-dealz scrape` in a process of its own, with the same settings, so that it starts
+// afresh every time, e.g. with its own metrics. Its exit status 3 is daemon.ErrSiteUnavailable.
+func scrapeProcess(ctx context.Context, g *globals, logger *slog.Logger) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// the rest of the settings are read from the same config file and environment
+	args := []string{
+		"-data-dir", g.cfg.Storage.DataDir,
+		"-base-url", g.cfg.Scraper.BaseURL,
+		"-log-level", g.cfg.Log.Level,
+		"-log-format", g.cfg.Log.Format,
+	}
+	if g.configPath != "" {
+		args = append(args, "-config", g.configPath)
+	}
+	args = append(args, "scrape")
+
+	cmd := exec.CommandContext(ctx, self, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = scrapeWaitDelay
+
+	logger.Debug("starting scrape", "args", args)
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == exitSiteUnavailable {
+		return fmt.Errorf("scrape: %w", daemon.ErrSiteUnavailable)
+	} else if err != nil {
+		return fmt.Errorf("scrape: %w", err)
+	}
+	return nil
+}
+
+// renderedSiteHandler serves the pages rendered to dir, at the paths the templates link to
+func renderedSiteHandler(dir string, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		page, ok := pageAt(r.URL.Path)
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+
+		f, err := os.Open(filepath.Join(dir, page))
+		if err != nil {
+			logger.Error("request failed", logging.KeyURL, r.URL.String(), logging.KeyError, err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			logger.Error("request failed", logging.KeyURL, r.URL.String(), logging.KeyError, err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(rw, r, page, info.ModTime(), f)
+	})
+}
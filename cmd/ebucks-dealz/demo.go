@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// scrapeDemoShop runs the real scraper against a fake shop with n random products.
+func scrapeDemoShop(n int, logger *slog.Logger) ([]scraper.Product, error) {
+	cs, ps := ebuckstest.RandomCatalogue(1, 10, n)
+	ts := ebuckstest.NewServer(ps)
+	defer ts.Close()
+	ts.SetCategories(cs)
+
+	m := sync.Mutex{}
+	scraped := []scraper.Product{}
+	s, err := scraper.New(
+		scraper.WithBaseURL(ts.URL),
+		scraper.WithThreads(8),
+		scraper.WithLogger(logger),
+		scraper.WithCallback(func(p scraper.Product) {
+			m.Lock()
+			defer m.Unlock()
+			scraped = append(scraped, p)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	return scraped, nil
+}
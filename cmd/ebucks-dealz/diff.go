@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/geniass/ebucks-dealz/pkg/catalog"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// runDiff implements `ebucks-dealz diff <old> [<new>]`: it lists the products added, removed and changed between
+// two snapshots
+func runDiff(g *globals, fs *flag.FlagSet, args []string) {
+	jsonArg := fs.Bool("json", false, "write the changes as JSON lines instead of text")
+	logger := g.parse(fs, args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		usageError(fs, "give one or two snapshots")
+	}
+	old, err := products(g.cfg.Storage.DataDir, fs.Arg(0), logger)
+	if err != nil {
+		logging.Fatal(logger, "could not load products", "snapshot", fs.Arg(0), logging.KeyError, err)
+	}
+	// fs.Arg is empty if there's no second snapshot, for the latest
+	new, err := products(g.cfg.Storage.DataDir, fs.Arg(1), logger)
+	if err != nil {
+		logging.Fatal(logger, "could not load products", "dir", g.cfg.Storage.DataDir, "snapshot", fs.Arg(1), logging.KeyError, err)
+	}
+
+	changes := catalog.Diff(old, new)
+	if *jsonArg {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		for _, c := range changes {
+			if err := enc.Encode(c); err != nil {
+				logging.Fatal(logger, "could not write changes", logging.KeyError, err)
+			}
+		}
+		return
+	}
+	if err := printChanges(os.Stdout, changes); err != nil {
+		logging.Fatal(logger, "could not write changes", logging.KeyError, err)
+	}
+}
+
+// printChanges writes a line per change: + for added products, - for removed ones and ~ for changed ones
+func printChanges(w io.Writer, changes []catalog.Change) error {
+	for _, c := range changes {
+		var err error
+		switch c.Kind {
+		case catalog.Added:
+			_, err = fmt.Fprintf(w, "+ %s\n", describe(*c.New))
+		case catalog.Removed:
+			_, err = fmt.Fprintf(w, "- %s\n", describe(*c.Old))
+		case catalog.Changed:
+			fields := []string{}
+			for _, f := range c.Fields {
+				fields = append(fields, fmt.Sprintf("%s %v -> %v", f, field(*c.Old, f), field(*c.New, f)))
+			}
+			_, err = fmt.Fprintf(w, "~ %s: %s\n", describe(*c.New), strings.Join(fields, ", "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describe(p scraper.Product) string {
+	s := fmt.Sprintf("%s (prodId %s, catId %s) R %.2f", p.Name, p.ProdID, p.CatID, p.Price)
+	if p.Percentage > 0 {
+		s += fmt.Sprintf(" %g%% off", p.Percentage)
+	}
+	return s
+}
+
+func field(p scraper.Product, name string) any {
+	switch name {
+	case "Name":
+		return fmt.Sprintf("%q", p.Name)
+	case "URL":
+		return p.URL
+	case "Price":
+		return p.Price
+	case "Savings":
+		return p.Savings
+	case "Percentage":
+		return p.Percentage
+	case "MemberLevel":
+		return p.MemberLevel
+	}
+	return nil
+}
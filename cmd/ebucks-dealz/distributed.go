@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/distributed"
+	dataio "github.com/geniass/ebucks-dealz/pkg/io"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// coordinatorLinger is how long the coordinator keeps serving after the crawl, so that idle workers find out it's over
+const coordinatorLinger = 10 * time.Second
+
+// runCoordinator implements `ebucks-dealz coordinator -addr <addr>`: it splits the crawl into categories, hands
+// them out to `ebucks-dealz worker` processes and writes what they scrape to a run dir, like `ebucks-dealz scrape`
+func runCoordinator(g *globals, fs *flag.FlagSet, args []string) {
+	cfg := g.cfg
+	cfg.Var(fs, "addr", "distributed.addr", "address on which to serve workers")
+	cfg.Var(fs, "overwrite", "storage.overwrite", "when false, a new directory is created within the data dir named as the current date and time; otherwise the data dir is cleaned and replaced.")
+	cfg.Var(fs, "lease", "distributed.lease", "how long a worker can go without being heard from before its category is given to another worker")
+	cfg.Var(fs, "max-attempts", "distributed.maxAttempts", "how many times a category is handed out before it is given up on")
+	logger := g.parse(fs, args)
+
+	client, err := scraper.NewClient(cfg.Scraper.BaseURL, nil)
+	if err != nil {
+		logging.Fatal(logger, "could not create client", logging.KeyError, err)
+	}
+	client.SetLogger(logger)
+	tasks, err := distributed.DiscoverTasks(context.Background(), client)
+	var outage *scraper.OutageError
+	if errors.As(err, &outage) {
+		// nothing has been written yet
+		os.Exit(siteUnavailable(outage, runDir{}, logger))
+	} else if err != nil {
+		logging.Fatal(logger, "could not list categories", logging.KeyError, err)
+	}
+	logger.Info("found categories", "count", len(tasks))
+
+	run := makeRunDir(cfg.Storage.DataDir, cfg.Storage.Overwrite, logger)
+	manifestFile, err := os.Create(filepath.Join(run.path, dataio.ManifestFilename))
+	if err != nil {
+		logging.Fatal(logger, "could not create manifest", logging.KeyError, err)
+	}
+	defer manifestFile.Close()
+	manifest := scraper.NewManifestWriter(manifestFile, run.runID)
+
+	c, err := distributed.NewCoordinator(tasks, distributed.Config{
+		LeaseDuration: cfg.Distributed.Lease,
+		MaxAttempts:   cfg.Distributed.MaxAttempts,
+		Callback: func(p scraper.Product) {
+			if err := writeJSON(p, run.raw); err != nil {
+				logging.Fatal(logger, "could not write product", logging.KeyURL, p.URL, logging.KeyProdID, p.ProdID, logging.KeyCatID, p.CatID, logging.KeyError, err)
+			}
+		},
+		Observers: []scraper.Observer{manifest},
+		RunConfig: scraper.RunConfig{BaseURL: cfg.Scraper.BaseURL},
+		Logger:    logger,
+	})
+	if err != nil {
+		logging.Fatal(logger, "could not create coordinator", logging.KeyError, err)
+	}
+
+	server := &http.Server{Addr: cfg.Distributed.Addr, Handler: c}
+	go func() {
+		logger.Info("serving workers", "addr", cfg.Distributed.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal(logger, "coordinator server failed", logging.KeyError, err)
+		}
+	}()
+
+	crawlErr := c.Run(context.Background())
+	time.Sleep(coordinatorLinger)
+	server.Close()
+
+	if err := manifest.Err(); err != nil {
+		logging.Fatal(logger, "could not write manifest", logging.KeyError, err)
+	}
+	if errors.As(crawlErr, &outage) {
+		manifestFile.Close()
+		os.Exit(siteUnavailable(outage, run, logger))
+	} else if crawlErr != nil {
+		logging.Fatal(logger, "crawl failed", "dir", run.path, logging.KeyError, crawlErr)
+	}
+	checkCompleteness(filepath.Join(run.path, dataio.ManifestFilename), logger)
+	manifestFile.Close()
+	if err := run.commit(); err != nil {
+		logging.Fatal(logger, "could not replace data dir", "dir", run.target, logging.KeyError, err)
+	}
+	logger.Info("done", "dir", run.final())
+}
+
+// runWorker implements `ebucks-dealz worker -coordinator <url>`: it crawls the categories the coordinator hands
+// it until the crawl is over
+func runWorker(g *globals, fs *flag.FlagSet, args []string) {
+	g.cfg.Var(fs, "coordinator", "distributed.coordinator", "URL of the coordinator")
+	nameArg := fs.String("name", "", "name of the worker in the coordinator's logs (default host-pid)")
+	addScraperFlags(g.cfg, fs)
+	logger := g.parse(fs, args)
+
+	w := &distributed.Worker{
+		CoordinatorURL: g.cfg.Distributed.Coordinator,
+		Name:           *nameArg,
+		Options:        scraperOptions(g.cfg, logger),
+		Logger:         logger,
+	}
+	if err := w.Run(context.Background()); err != nil {
+		logging.Fatal(logger, "worker failed", logging.KeyError, err)
+	}
+}
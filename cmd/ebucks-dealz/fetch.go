@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// getProduct implements `ebucks-dealz product -prod <prodId> -cat <catId>`
+func getProduct(g *globals, fs *flag.FlagSet, args []string) {
+	prodIdArg := fs.String("prod", "", "product ID (prodId)")
+	catIdArg := fs.String("cat", "", "category ID (catId)")
+	g.cfg.Var(fs, "login", "scraper.login", loginHelp)
+	g.cfg.Var(fs, "credentials-file", "scraper.credentialsFile", "JSON file with the credentials of a member to get the product as with -login, instead of "+scraper.EnvUsername+" and "+scraper.EnvPassword)
+	logger := g.parse(fs, args)
+
+	if *prodIdArg == "" || *catIdArg == "" {
+		usageError(fs, "-prod and -cat are required")
+	}
+
+	c, err := scraper.NewClient(g.cfg.Scraper.BaseURL, nil)
+	if err != nil {
+		logging.Fatal(logger, "could not create client", logging.KeyError, err)
+	}
+	c.SetLogger(logger)
+	if creds, err := memberCredentials(g.cfg.Scraper, logger); err != nil {
+		logging.Fatal(logger, "could not load credentials", logging.KeyError, err)
+	} else if creds != nil {
+		c.SetCredentials(*creds)
+	}
+	p, err := c.GetProduct(context.Background(), *prodIdArg, *catIdArg)
+	if err != nil {
+		logging.Fatal(logger, "could not get product", logging.KeyProdID, *prodIdArg, logging.KeyCatID, *catIdArg, logging.KeyError, err)
+	}
+	printJSON(p)
+}
+
+// listCategory implements `ebucks-dealz category -cat <catId>`
+func listCategory(g *globals, fs *flag.FlagSet, args []string) {
+	catIdArg := fs.String("cat", "", "category ID (catId)")
+	logger := g.parse(fs, args)
+
+	if *catIdArg == "" {
+		usageError(fs, "-cat is required")
+	}
+
+	c, err := scraper.NewClient(g.cfg.Scraper.BaseURL, nil)
+	if err != nil {
+		logging.Fatal(logger, "could not create client", logging.KeyError, err)
+	}
+	c.SetLogger(logger)
+	ps, err := c.ListCategory(context.Background(), *catIdArg)
+	if err != nil {
+		logging.Fatal(logger, "could not list category", logging.KeyCatID, *catIdArg, logging.KeyError, err)
+	}
+	printJSON(ps)
+}
+
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logging.Fatal(slog.Default(), "could not write JSON", logging.KeyError, err)
+	}
+}
@@ -0,0 +1,216 @@
+// Command ebucks-dealz scrapes the eBucks shop and publishes its deals. Run `ebucks-dealz help` for its commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/geniass/ebucks-dealz/pkg/config"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+// Exit statuses, the same for every command.
+const (
+	exitFailure = 1 // what logging.Fatal exits with
+	exitUsage   = 2 // what flag.ExitOnError exits with
+	// exitSiteUnavailable is the exit status of a crawl that stopped because the site is down. Nothing in the data
+	// dir has been changed, so there is nothing new to render.
+	exitSiteUnavailable = 3
+)
+
+// command is a subcommand of ebucks-dealz
+type command struct {
+	name string
+	// args describes what follows the name, for the usage line
+	args    string
+	summary string
+	// run parses args with fs, which already has the global flags, and does the command's work
+	run func(g *globals, fs *flag.FlagSet, args []string)
+}
+
+var commands = []command{
+	{"scrape", "[flags]", "crawl the shop into a new snapshot in the data dir", runScrape},
+	{"render", "[flags]", "render the static site from the latest snapshot", runRender},
+	{"serve", "[flags]", "serve the site from the latest snapshot over HTTP", runServe},
+	{"daemon", "[flags]", "scrape on a schedule, retrying failed scrapes, and render and serve the site after each one", runDaemon},
+	{"diff", "[flags] <old> [<new>]", "compare the products of two snapshots, <new> being the latest by default", runDiff},
+	{"query", "[flags]", "list the products of the latest snapshot that match some filters", runQuery},
+	{"export", "[flags]", "write the products of the latest snapshot as CSV, JSON or JSON lines", runExport},
+	{"product", "-prod <prodId> -cat <catId> [flags]", "fetch a single product", getProduct},
+	{"category", "-cat <catId> [flags]", "list the products of a category", listCategory},
+	{"watch", "[flags]", "poll the discounts of some products and record every change", runWatch},
+	{"coordinator", "[flags]", "crawl the shop by handing its categories out to workers", runCoordinator},
+	{"worker", "[flags]", "crawl the categories a coordinator hands out", runWorker},
+	{"config", "print [flags]", "show the configuration in effect, as a config file commented with where each setting comes from", runConfig},
+}
+
+// globals are the settings of every command. The flags for the most common ones can be given either before or after
+// the command's name.
+type globals struct {
+	cfg *config.Config
+	// configPath is the config file the settings were loaded from, see configFile
+	configPath string
+}
+
+func (g *globals) addTo(fs *flag.FlagSet) {
+	fs.StringVar(&g.configPath, "config", g.configPath, "YAML config file (default $"+config.EnvFile+", or "+config.DefaultFile+" if it exists)")
+	g.cfg.Var(fs, "data-dir", "storage.dataDir", "directory of scraped data; a snapshot per crawl, or a single one written with -overwrite")
+	g.cfg.Var(fs, "base-url", "scraper.baseURL", "base URL of the shop")
+	g.cfg.Var(fs, "log-level", "log.level", "minimum level to log: debug, info, warn or error")
+	g.cfg.Var(fs, "log-format", "log.format", "log output format: text or json")
+}
+
+// flagSet makes the flag set of c, with the global flags
+func (g *globals) flagSet(c command) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	g.addTo(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ebucks-dealz %s %s\n\n%s.\n\nFlags:\n", c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses a command's flags, checks the settings and makes the logger they ask for
+func (g *globals) parse(fs *flag.FlagSet, args []string) *slog.Logger {
+	fs.Parse(args)
+	g.cfg.Parsed(fs)
+	if err := g.cfg.Validate(); err != nil {
+		invalidConfig(err)
+	}
+
+	logger, err := (&logging.Flags{Level: g.cfg.Log.Level, Format: g.cfg.Log.Format}).Logger()
+	if err != nil {
+		invalidConfig(err)
+	}
+	return logger
+}
+
+func main() {
+	g := &globals{configPath: configFile(os.Args[1:])}
+	cfg, err := config.Load(g.configPath, os.Environ())
+	if err != nil {
+		invalidConfig(err)
+	}
+	g.cfg = cfg
+
+	root := flag.NewFlagSet("ebucks-dealz", flag.ExitOnError)
+	g.addTo(root)
+	root.Usage = func() { usage(root.Output(), root) }
+	root.Parse(os.Args[1:])
+	g.cfg.Parsed(root)
+
+	args := root.Args()
+	if len(args) == 0 {
+		root.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if args[0] == "help" {
+		if len(args) < 2 {
+			usage(os.Stdout, root)
+			return
+		}
+		c, ok := lookup(args[1])
+		if !ok {
+			unknownCommand(args[1], root)
+		}
+		fs := g.flagSet(c)
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return
+	}
+
+	c, ok := lookup(args[0])
+	if !ok {
+		unknownCommand(args[0], root)
+	}
+	c.run(g, g.flagSet(c), args[1:])
+}
+
+// configFile finds the config file to load, before the flags can be parsed: the flags' defaults come from it
+func configFile(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if name != "config" || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(config.EnvFile)
+}
+
+func invalidConfig(err error) {
+	fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+	os.Exit(exitUsage)
+}
+
+func lookup(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func unknownCommand(name string, root *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr, root)
+	os.Exit(exitUsage)
+}
+
+func usage(w io.Writer, root *flag.FlagSet) {
+	fmt.Fprint(w, "Usage: ebucks-dealz [global flags] <command> [flags] [args]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(tw, "  %s\t%s\n", "help", "show the flags of a command: ebucks-dealz help <command>")
+	tw.Flush()
+
+	fmt.Fprint(w, "\nGlobal flags, which can also be given after the command:\n")
+	root.SetOutput(w)
+	root.PrintDefaults()
+
+	fmt.Fprintf(w, "\nEvery setting can also be given in the config file, or in an environment variable like %s for\n"+
+		"scraper.threads. Flags take precedence over the environment, which takes precedence over the file. Run\n"+
+		"`ebucks-dealz config print` for all the settings.\n", config.EnvName("scraper.threads"))
+
+	fmt.Fprintf(w, "\nExit status:\n  0  success\n  %d  failure\n  %d  invalid command line or configuration\n  %d  the shop is unavailable (scrape and coordinator); the data dir is left as it was\n",
+		exitFailure, exitUsage, exitSiteUnavailable)
+}
+
+// usageError reports a command line that doesn't make sense and exits
+func usageError(fs *flag.FlagSet, format string, args ...any) {
+	fmt.Fprintf(fs.Output(), format+"\n\n", args...)
+	fs.Usage()
+	os.Exit(exitUsage)
+}
+
+// runConfig implements `ebucks-dealz config print`
+func runConfig(g *globals, fs *flag.FlagSet, args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		usageError(fs, "expected print")
+	}
+	g.parse(fs, args[1:])
+	if fs.NArg() > 0 {
+		usageError(fs, "unexpected arguments %q", fs.Args())
+	}
+	if err := g.cfg.WriteYAML(os.Stdout); err != nil {
+		logging.Fatal(slog.Default(), "could not write config", logging.KeyError, err)
+	}
+}
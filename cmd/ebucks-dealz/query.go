@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/geniass/ebucks-dealz/pkg/catalog"
+	dataio "github.com/geniass/ebucks-dealz/pkg/io"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// formatTable is the query output format for people rather than programs
+const formatTable = "table"
+
+// runQuery implements `ebucks-dealz query`: it lists the products that match the filters
+func runQuery(g *globals, fs *flag.FlagSet, args []string) {
+	qf := addQueryFlags(fs, "-percentage")
+	formatArg := fs.String("format", formatTable, "output format: "+formatTable+" or one of "+strings.Join(catalog.Formats, ", "))
+	logger := g.parse(fs, args)
+
+	if *formatArg != formatTable {
+		if _, ok := catalog.FormatOf("." + *formatArg); !ok {
+			usageError(fs, "invalid -format %q", *formatArg)
+		}
+	}
+	ps := qf.run(g, fs, logger)
+
+	if *formatArg == formatTable {
+		if err := printTable(os.Stdout, ps); err != nil {
+			logging.Fatal(logger, "could not write products", logging.KeyError, err)
+		}
+		return
+	}
+	if err := catalog.Export(os.Stdout, ps, *formatArg); err != nil {
+		logging.Fatal(logger, "could not write products", logging.KeyError, err)
+	}
+}
+
+// runExport implements `ebucks-dealz export`: it writes the products that match the filters to a file
+func runExport(g *globals, fs *flag.FlagSet, args []string) {
+	qf := addQueryFlags(fs, "name")
+	outArg := fs.String("out", "-", "file to write the products to, - for stdout")
+	formatArg := fs.String("format", "", "one of "+strings.Join(catalog.Formats, ", ")+" (default from the -out extension, or "+catalog.FormatJSON+")")
+	logger := g.parse(fs, args)
+
+	format := *formatArg
+	if format == "" {
+		var ok bool
+		if format, ok = catalog.FormatOf(*outArg); !ok {
+			format = catalog.FormatJSON
+		}
+	} else if _, ok := catalog.FormatOf("." + format); !ok {
+		usageError(fs, "invalid -format %q", format)
+	}
+	ps := qf.run(g, fs, logger)
+
+	out := os.Stdout
+	if *outArg != "-" {
+		f, err := os.Create(*outArg)
+		if err != nil {
+			logging.Fatal(logger, "could not create output file", logging.KeyError, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := catalog.Export(out, ps, format); err != nil {
+		logging.Fatal(logger, "could not write products", logging.KeyError, err)
+	}
+	if err := out.Close(); err != nil {
+		logging.Fatal(logger, "could not write products", logging.KeyError, err)
+	}
+	logger.Info("exported products", "count", len(ps), "format", format, "out", *outArg)
+}
+
+// queryFlags are the flags of the commands that select products with a catalog.Query
+type queryFlags struct {
+	snapshot      *string
+	name          *string
+	categories    *string
+	discounted    *bool
+	minPercentage *float64
+	maxPrice      *float64
+	sort          *string
+	limit         *int
+}
+
+func addQueryFlags(fs *flag.FlagSet, defaultSort string) *queryFlags {
+	return &queryFlags{
+		snapshot:      fs.String("snapshot", "", "snapshot to read, e.g. data/<run> (default the latest in the data dir)"),
+		name:          fs.String("name", "", "only products whose name matches this regular expression (case insensitive)"),
+		categories:    fs.String("categories", "", "only products in these comma separated category IDs (catId)"),
+		discounted:    fs.Bool("discounted", false, "only discounted products"),
+		minPercentage: fs.Float64("min-percentage", 0, "only products discounted by at least this percentage"),
+		maxPrice:      fs.Float64("max-price", 0, "only products that cost at most this much (0 for no limit)"),
+		sort:          fs.String("sort", defaultSort, "sort by "+strings.Join(catalog.SortFields, ", ")+"; prefix with - for descending order"),
+		limit:         fs.Int("limit", 0, "most products to list (0 for no limit)"),
+	}
+}
+
+// run loads the products and selects the ones the flags ask for
+func (f *queryFlags) run(g *globals, fs *flag.FlagSet, logger *slog.Logger) []scraper.Product {
+	q := catalog.Query{MinPercentage: *f.minPercentage, MaxPrice: *f.maxPrice, Sort: *f.sort, Limit: *f.limit}
+	if *f.name != "" {
+		re, err := regexp.Compile("(?i)" + *f.name)
+		if err != nil {
+			usageError(fs, "invalid -name: %s", err)
+		}
+		q.Name = re
+	}
+	if *f.categories != "" {
+		q.Categories = strings.Split(*f.categories, ",")
+	}
+	if *f.discounted && q.MinPercentage == 0 {
+		q.MinPercentage = -1
+	}
+
+	all, err := products(g.cfg.Storage.DataDir, *f.snapshot, logger)
+	if err != nil {
+		logging.Fatal(logger, "could not load products", "dir", g.cfg.Storage.DataDir, "snapshot", *f.snapshot, logging.KeyError, err)
+	}
+	ps, err := q.Run(all)
+	if err != nil {
+		usageError(fs, "invalid -sort: %s", err)
+	}
+	return ps
+}
+
+// products are the products of the snapshot in dir, or of the latest snapshot in the data dir if dir is empty
+func products(dataDir string, dir string, logger *slog.Logger) ([]scraper.Product, error) {
+	if dir == "" {
+		snapshot, err := dataio.LatestSnapshot(dataDir, logger)
+		if err != nil {
+			return nil, err
+		}
+		dir = snapshot.Dir
+	}
+
+	loaded, err := dataio.LoadSnapshot(dir, logger)
+	if err != nil {
+		return nil, err
+	}
+	ps := []scraper.Product{}
+	for _, p := range loaded {
+		ps = append(ps, p.Product)
+	}
+	return ps, nil
+}
+
+func printTable(w io.Writer, ps []scraper.Product) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPRICE\tSAVINGS\tDISCOUNT\tPRODID\tCATID")
+	for _, p := range ps {
+		fmt.Fprintf(tw, "%s\tR %.2f\tR %.2f\t%g%%\t%s\t%s\n", p.Name, p.Price, p.Savings, p.Percentage, p.ProdID, p.CatID)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/config"
+	dataio "github.com/geniass/ebucks-dealz/pkg/io"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/web"
+)
+
+// runRender implements `ebucks-dealz render`: it writes the site's pages, for static hosting
+func runRender(g *globals, fs *flag.FlagSet, args []string) {
+	cfg := g.cfg
+	cfg.Var(fs, "output-dir", "render.outputDir", "directory to write the rendered HTML pages to")
+	cfg.Var(fs, "path-prefix", "render.pathPrefix", "prefix page link URLs (in case pages are hosted at a subpath); should start with '/'")
+	addSiteFlags(cfg, fs)
+	logger := g.parse(fs, args)
+
+	site, err := latestSite(cfg.Storage.DataDir, g.site(cfg.Render.PathPrefix, logger), logger)
+	if err != nil {
+		logging.Fatal(logger, "could not load products", "dir", cfg.Storage.DataDir, logging.KeyError, err)
+	}
+
+	if err := renderSite(cfg.Render.OutputDir, site); err != nil {
+		logging.Fatal(logger, "could not render site", logging.KeyError, err)
+	}
+	logger.Info("rendered site", "dir", cfg.Render.OutputDir, "products", len(site.Products))
+}
+
+// addSiteFlags adds the flags of the settings of how the site looks, by render and serve
+func addSiteFlags(cfg *config.Config, fs *flag.FlagSet) {
+	cfg.Var(fs, "timezone", "render.timezone", "timezone to show times in, e.g. Africa/Johannesburg or UTC")
+	cfg.Var(fs, "discount-title", "render.discountTitle", "title of the page of discounted products")
+	cfg.Var(fs, "other-title", "render.otherTitle", "title of the page of other products")
+}
+
+// site is a site without products, that looks like the settings say, with links prefixed by pathPrefix
+func (g *globals) site(pathPrefix string, logger *slog.Logger) web.Site {
+	// the timezone has been validated with the rest of the config
+	loc, _ := g.cfg.Render.Location()
+	return web.Site{
+		BaseContext:   web.BaseContext{PathPrefix: pathPrefix},
+		LastUpdated:   time.Now(),
+		DiscountTitle: g.cfg.Render.DiscountTitle,
+		OtherTitle:    g.cfg.Render.OtherTitle,
+		Location:      loc,
+		Logger:        logger,
+	}
+}
+
+// latestSite loads site's products from the latest snapshot in dataDir. A data dir without any is a site without
+// deals.
+func latestSite(dataDir string, site web.Site, logger *slog.Logger) (web.Site, error) {
+	snapshot, err := dataio.LatestSnapshot(dataDir, logger)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("no data in data dir, assuming no deals", "dir", dataDir)
+		return site, nil
+	} else if err != nil {
+		return site, err
+	}
+
+	if site.Products, err = products(dataDir, snapshot.Dir, logger); err != nil {
+		return site, err
+	}
+	if !snapshot.Time.IsZero() {
+		site.LastUpdated = snapshot.Time
+	}
+	return site, nil
+}
+
+// renderSite writes the pages of site to dir
+func renderSite(dir string, site web.Site) error {
+	if err := os.MkdirAll(dir, os.ModeDir|0775); err != nil {
+		return err
+	}
+	for _, page := range web.Pages {
+		if err := renderToFile(dir, page, site); err != nil {
+			return fmt.Errorf("%s: %w", page, err)
+		}
+	}
+	return nil
+}
+
+// renderToFile renders a page to a temporary file that then replaces the page, so that the page can be served
+// while it is being rendered
+func renderToFile(dir string, filename string, site web.Site) error {
+	f, err := os.CreateTemp(dir, filename+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := site.RenderPage(f, filename); err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, filename))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// runDir is where a run writes its data. Without -overwrite that's a new directory within the data dir. With
+// -overwrite it's a staging directory next to the data dir, which only replaces the data dir once the run has
+// succeeded, so the last good data survives a run that doesn't.
+type runDir struct {
+	// path is where the run's files are written, and raw the directory of product files within it
+	path  string
+	raw   string
+	runID string
+	// target is the data dir that path replaces, empty without -overwrite
+	target string
+}
+
+// makeRunDir creates the directory for a run's data
+func makeRunDir(dataDir string, overwrite bool, logger *slog.Logger) runDir {
+	runDate := time.Now()
+	runID := runDate.Format("2006-01-02T15-04-05Z-0700")
+
+	d := runDir{path: filepath.Join(dataDir, runID), runID: runID}
+	if overwrite {
+		d.target = dataDir
+		d.path = filepath.Clean(dataDir) + ".partial"
+		// left behind by a run that died
+		if err := os.RemoveAll(d.path); err != nil {
+			logging.Fatal(logger, "could not clean staging dir", logging.KeyError, err)
+		}
+	}
+
+	if err := os.MkdirAll(d.path, os.ModeDir|0755); err != nil {
+		logging.Fatal(logger, "could not create data dir", logging.KeyError, err)
+	}
+
+	d.raw = filepath.Join(d.path, "raw")
+	if err := os.MkdirAll(d.raw, os.ModeDir|0755); err != nil {
+		logging.Fatal(logger, "could not create raw dir", logging.KeyError, err)
+	}
+	return d
+}
+
+// commit makes the run's data the current data
+func (d runDir) commit() error {
+	if d.target == "" {
+		return nil
+	}
+	if err := os.RemoveAll(d.target); err != nil {
+		return err
+	}
+	return os.Rename(d.path, d.target)
+}
+
+// final is where the run's data ends up
+func (d runDir) final() string {
+	if d.target != "" {
+		return d.target
+	}
+	return d.path
+}
+
+// siteUnavailable throws away what the run wrote (nothing for an empty run) and prints why to stdout as JSON. It
+// returns exitSiteUnavailable to exit with once the run's other files are closed; the run's own files must already
+// be.
+func siteUnavailable(outage *scraper.OutageError, run runDir, logger *slog.Logger) int {
+	if err := os.RemoveAll(run.path); err != nil {
+		logger.Warn("could not remove run dir", "dir", run.path, logging.KeyError, err)
+	}
+	logger.Error("site unavailable, leaving the data as it was", "reason", outage.Reason, "requests", outage.Requests,
+		"failures", outage.Failures, logging.KeyError, outage.LastError)
+
+	status := struct {
+		Status string `json:"status"`
+		*scraper.OutageError
+	}{"site-unavailable", outage}
+	if err := json.NewEncoder(os.Stdout).Encode(status); err != nil {
+		logger.Warn("could not write status", logging.KeyError, err)
+	}
+	return exitSiteUnavailable
+}
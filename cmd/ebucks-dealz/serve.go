@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	dataio "github.com/geniass/ebucks-dealz/pkg/io"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/web"
+)
+
+// runServe implements `ebucks-dealz serve`: it serves the site's pages, rendered on every request from the latest
+// snapshot so that new scrapes show up without restarting. The snapshot is only loaded again when a manifest in the
+// data dir changes.
+func runServe(g *globals, fs *flag.FlagSet, args []string) {
+	cfg := g.cfg
+	cfg.Var(fs, "addr", "serve.addr", "address on which to serve the site")
+	cfg.Var(fs, "path-prefix", "serve.pathPrefix", "serve the site at this subpath and prefix page link URLs with it; should start with '/'")
+	addSiteFlags(cfg, fs)
+	demoArg := fs.Int("demo", 0, "instead of reading the data dir, scrape a fake shop with this many random products (works offline)")
+	logger := g.parse(fs, args)
+
+	prefix := strings.TrimSuffix(cfg.Serve.PathPrefix, "/")
+	cache := &siteCache{dataDir: cfg.Storage.DataDir, load: func() (web.Site, error) {
+		return latestSite(cfg.Storage.DataDir, g.site(prefix, logger), logger)
+	}}
+	loadSite := cache.get
+	if *demoArg > 0 {
+		ps, err := scrapeDemoShop(*demoArg, logger)
+		if err != nil {
+			logging.Fatal(logger, "could not scrape demo shop", logging.KeyError, err)
+		}
+		site := g.site(prefix, logger)
+		site.Products = ps
+		loadSite = func() (web.Site, error) {
+			return site, nil
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, siteHandler(loadSite, logger)))
+	logger.Info("serving site", "addr", cfg.Serve.Addr, "pathPrefix", prefix)
+	if err := http.ListenAndServe(cfg.Serve.Addr, mux); err != nil {
+		logging.Fatal(logger, "server failed", logging.KeyError, err)
+	}
+}
+
+// siteHandler serves the pages of the site loadSite loads, at the paths the templates link to
+func siteHandler(loadSite func() (web.Site, error), logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		page, ok := pageAt(r.URL.Path)
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+
+		site, err := loadSite()
+		if err != nil {
+			logger.Error("request failed", logging.KeyURL, r.URL.String(), logging.KeyError, err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// rendered in full first, so a failure can still be reported in the status
+		buf := bytes.Buffer{}
+		if err := site.RenderPage(&buf, page); err != nil {
+			logger.Error("request failed", logging.KeyURL, r.URL.String(), logging.KeyError, err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		buf.WriteTo(rw)
+	})
+}
+
+// siteCache keeps the site load returns until the manifests in dataDir change, which they do when a scrape
+// finishes. Loading means reading every manifest and product file, which gets slower with every scrape kept.
+type siteCache struct {
+	dataDir string
+	load    func() (web.Site, error)
+
+	mutex sync.Mutex
+	key   string
+	site  web.Site
+}
+
+func (c *siteCache) get() (web.Site, error) {
+	key, err := c.manifestsKey()
+	if err != nil {
+		return web.Site{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.key != "" && key == c.key {
+		return c.site, nil
+	}
+	site, err := c.load()
+	if err != nil {
+		return site, err
+	}
+	c.key, c.site = key, site
+	return site, nil
+}
+
+// manifestsKey sums up the names, sizes and modification times of the manifests in the data dir, and of the data
+// dir itself, whose time changes when snapshot dirs are added, removed or renamed
+func (c *siteCache) manifestsKey() (string, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dataDir, "*", dataio.ManifestFilename))
+	if err != nil {
+		return "", err
+	}
+	paths = append(paths, filepath.Join(c.dataDir, dataio.ManifestFilename), c.dataDir)
+
+	key := strings.Builder{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&key, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return key.String(), nil
+}
+
+// pageAt is the page of web.Pages at path, which may leave out the .html like the templates' links do
+func pageAt(path string) (string, bool) {
+	page := strings.TrimPrefix(path, "/")
+	if page == "" {
+		page = "index"
+	}
+	page = strings.TrimSuffix(page, ".html") + ".html"
+
+	for _, p := range web.Pages {
+		if p == page {
+			return page, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	dataio "github.com/geniass/ebucks-dealz/pkg/io"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+	"github.com/geniass/ebucks-dealz/pkg/watch"
+)
+
+// runWatch implements `ebucks-dealz watch`: it polls the discounts of a set of products until interrupted,
+// appending every change to a JSON lines file
+func runWatch(g *globals, fs *flag.FlagSet, args []string) {
+	cfg := g.cfg
+	cfg.Var(fs, "watchlist", "watch.watchlist", `file of products to watch, one per line as a product URL or "<prodId> <catId>"`)
+	recentArg := fs.String("recent", "", "snapshot, e.g. data/<run>, whose discounted products to watch")
+	cfg.Var(fs, "categories", "watch.categories", "comma separated category IDs (catId) whose products to watch")
+	cfg.Var(fs, "relist", "watch.relist", "how often to list the -categories again for new products; listing counts towards the -budget")
+	cfg.Var(fs, "interval", "watch.interval", "how often to poll each product")
+	cfg.Var(fs, "jitter", "watch.jitter", "up to how much earlier or later than the interval to poll, at random")
+	cfg.Var(fs, "budget", "watch.budget", "most requests to make in any -budget-window (0 for no limit)")
+	cfg.Var(fs, "budget-window", "watch.budgetWindow", "window the request budget applies to")
+	cfg.Var(fs, "out", "watch.out", "JSON lines file to append every change of a product's discount to, - for stdout")
+	cfg.Var(fs, "login", "scraper.login", loginHelp)
+	cfg.Var(fs, "credentials-file", "scraper.credentialsFile", "JSON file with the credentials of a member to watch as with -login, instead of "+scraper.EnvUsername+" and "+scraper.EnvPassword)
+	logger := g.parse(fs, args)
+	wc := cfg.Watch
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	targets := []watch.Target{}
+	if wc.Watchlist != "" {
+		ts, err := watch.LoadWatchlist(wc.Watchlist)
+		if err != nil {
+			logging.Fatal(logger, "could not load watchlist", logging.KeyError, err)
+		}
+		targets = append(targets, ts...)
+	}
+	if *recentArg != "" {
+		ps, err := dataio.LoadSnapshot(*recentArg, logger)
+		if err != nil {
+			logging.Fatal(logger, "could not load products", "dir", *recentArg, logging.KeyError, err)
+		}
+		products := []scraper.Product{}
+		for _, p := range ps {
+			products = append(products, p.Product)
+		}
+		targets = append(targets, watch.Discounted(products)...)
+	}
+	if len(targets) == 0 && len(wc.Categories) == 0 {
+		usageError(fs, "nothing to watch: give -watchlist, -recent or -categories")
+	}
+
+	out := os.Stdout
+	if wc.Out != "-" {
+		f, err := os.OpenFile(wc.Out, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logging.Fatal(logger, "could not open output file", logging.KeyError, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+
+	creds, err := memberCredentials(cfg.Scraper, logger)
+	if err != nil {
+		logging.Fatal(logger, "could not load credentials", logging.KeyError, err)
+	}
+	w, err := watch.New(cfg.Scraper.BaseURL, targets, watch.Config{
+		Categories:   wc.Categories,
+		Relist:       wc.Relist,
+		Interval:     wc.Interval,
+		Jitter:       wc.Jitter,
+		Budget:       wc.Budget,
+		BudgetWindow: wc.BudgetWindow,
+		Credentials:  creds,
+		OnTransition: func(t watch.Transition) {
+			if err := enc.Encode(t); err != nil {
+				logging.Fatal(logger, "could not write transition", logging.KeyError, err)
+			}
+		},
+		Logger: logger,
+	})
+	if err != nil {
+		logging.Fatal(logger, "could not start watching", logging.KeyError, err)
+	}
+	if err := w.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		logging.Fatal(logger, "watch failed", logging.KeyError, err)
+	}
+	logger.Info("stopped watching")
+}
@@ -0,0 +1,47 @@
+// Command fake-shop serves a fake eBucks shop with a random catalogue, to run scrapers (or a coordinator and its
+// workers) against locally without touching the real site.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func main() {
+	addrArg := flag.String("addr", "localhost:8081", "address on which to serve the shop")
+	productsArg := flag.Int("products", 200, "number of products in the catalogue")
+	categoriesArg := flag.Int("categories", 10, "number of categories to spread the products over")
+	seedArg := flag.Int64("seed", 1, "seed of the random catalogue")
+	pageSizeArg := flag.Int("page-size", 0, "products per category page (0 to list every product on one page)")
+	latencyArg := flag.Duration("latency", 0, "up to how long to take over each response, at random")
+
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+
+	flag.Parse()
+	if *categoriesArg < 1 {
+		fmt.Fprintln(os.Stderr, "-categories must be at least 1")
+		os.Exit(2)
+	}
+
+	logger, err := logFlags.Logger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	cs, ps := ebuckstest.RandomCatalogue(*seedArg, *categoriesArg, *productsArg)
+	ts := ebuckstest.NewUnstartedServer(ps)
+	ts.SetCategories(cs)
+	ts.SetPageSize(*pageSizeArg)
+	ts.SetLatency(0, *latencyArg)
+
+	logger.Info("serving fake shop", "addr", *addrArg, "products", len(ps), "categories", len(cs), "start", "http://"+*addrArg+ebuckstest.HomePath)
+	if err := http.ListenAndServe(*addrArg, ts.Config.Handler); err != nil {
+		logging.Fatal(logger, "server failed", logging.KeyError, err)
+	}
+}
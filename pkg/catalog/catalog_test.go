@@ -0,0 +1,105 @@
+package catalog
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestDiff(t *testing.T) {
+	old := []scraper.Product{
+		{ProdID: "1", CatID: "1", Name: "Kettle", Price: 100},
+		{ProdID: "2", CatID: "1", Name: "Toaster", Price: 200, Percentage: 10},
+		{ProdID: "3", CatID: "2", Name: "Blender", Price: 300},
+	}
+	new := []scraper.Product{
+		{ProdID: "1", CatID: "1", Name: "Kettle", Price: 100},
+		{ProdID: "2", CatID: "1", Name: "Toaster", Price: 180, Percentage: 40},
+		{ProdID: "4", CatID: "2", Name: "Air Fryer", Price: 900},
+	}
+
+	changes := Diff(old, new)
+	if len(changes) != 3 {
+		t.Fatalf("wrong number of changes: got %d expected 3: %+v", len(changes), changes)
+	}
+	if c := changes[0]; c.Kind != Added || c.ProdID != "4" || c.Old != nil || c.New.Name != "Air Fryer" {
+		t.Errorf("wrong added change: %+v", c)
+	}
+	if c := changes[1]; c.Kind != Removed || c.ProdID != "3" || c.New != nil || c.Old.Name != "Blender" {
+		t.Errorf("wrong removed change: %+v", c)
+	}
+	if c := changes[2]; c.Kind != Changed || c.ProdID != "2" || strings.Join(c.Fields, ",") != "Price,Percentage" {
+		t.Errorf("wrong changed change: %+v", c)
+	}
+	if c := changes[2]; c.Old.Price != 200 || c.New.Price != 180 {
+		t.Errorf("wrong prices: got %v to %v expected 200 to 180", c.Old.Price, c.New.Price)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ps := []scraper.Product{
+		{ProdID: "1", CatID: "1", Name: "Kettle", Price: 100, Percentage: 10},
+		{ProdID: "2", CatID: "1", Name: "Toaster", Price: 200, Percentage: 40},
+		{ProdID: "3", CatID: "2", Name: "Blender", Price: 300},
+		{ProdID: "4", CatID: "2", Name: "Steam Kettle", Price: 400, Percentage: 20},
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected string
+	}{
+		{"all", Query{}, "1,2,3,4"},
+		{"name", Query{Name: regexp.MustCompile("(?i)kettle")}, "1,4"},
+		{"category", Query{Categories: []string{"2"}}, "3,4"},
+		{"discounted", Query{MinPercentage: -1}, "1,2,4"},
+		{"min percentage", Query{MinPercentage: 20}, "2,4"},
+		{"max price", Query{MaxPrice: 200}, "1,2"},
+		{"sort", Query{Sort: "name"}, "3,1,4,2"},
+		{"sort descending", Query{Sort: "-percentage"}, "2,4,1,3"},
+		{"limit", Query{Sort: "-price", Limit: 2}, "4,3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := test.query.Run(ps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, p := range selected {
+				ids = append(ids, p.ProdID)
+			}
+			if got := strings.Join(ids, ","); got != test.expected {
+				t.Errorf("wrong products: got %s expected %s", got, test.expected)
+			}
+		})
+	}
+
+	if _, err := (Query{Sort: "colour"}).Run(ps); err == nil {
+		t.Errorf("sorting by an unknown field should fail")
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	ps := []scraper.Product{{ProdID: "1", CatID: "2", Name: `Kettle, "2l"`, URL: "https://example.com/p", Price: 100, Savings: 25.5, Percentage: 20}}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, ps, FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	expected := "prodId,catId,name,url,price,savings,percentage,memberLevel\n" +
+		`1,2,"Kettle, ""2l""",https://example.com/p,100.00,25.50,20,0` + "\n"
+	if buf.String() != expected {
+		t.Errorf("wrong CSV: got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+
+	if format, ok := FormatOf("deals.JSONL"); !ok || format != FormatJSONL {
+		t.Errorf("wrong format: got %q expected %q", format, FormatJSONL)
+	}
+	if err := Export(&buf, ps, "xml"); err == nil {
+		t.Errorf("exporting in an unknown format should fail")
+	}
+}
@@ -0,0 +1,114 @@
+// Package catalog compares, filters and exports sets of scraped products, e.g. the products of a snapshot.
+package catalog
+
+import (
+	"sort"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// ChangeKind is how a product differs between two sets of products.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// Change is a product that differs between two sets of products. Old is nil for an added product and New for a
+// removed one.
+type Change struct {
+	Kind   ChangeKind       `json:"kind"`
+	ProdID string           `json:"prodId"`
+	CatID  string           `json:"catId"`
+	Old    *scraper.Product `json:"old,omitempty"`
+	New    *scraper.Product `json:"new,omitempty"`
+	// Fields are the names of the fields that changed
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Name is the product's latest name.
+func (c Change) Name() string {
+	if c.New != nil {
+		return c.New.Name
+	}
+	return c.Old.Name
+}
+
+type key struct {
+	prodID string
+	catID  string
+}
+
+func keyOf(p scraper.Product) key {
+	return key{prodID: p.ProdID, catID: p.CatID}
+}
+
+// Diff compares two sets of products, matching them by prodId and catId. The changes are sorted by name.
+func Diff(old []scraper.Product, new []scraper.Product) []Change {
+	olds := map[key]scraper.Product{}
+	for _, p := range old {
+		olds[keyOf(p)] = p
+	}
+
+	changes := []Change{}
+	seen := map[key]bool{}
+	for _, p := range new {
+		p := p
+		k := keyOf(p)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		o, ok := olds[k]
+		if !ok {
+			changes = append(changes, Change{Kind: Added, ProdID: p.ProdID, CatID: p.CatID, New: &p})
+			continue
+		}
+		if fields := changedFields(o, p); len(fields) > 0 {
+			changes = append(changes, Change{Kind: Changed, ProdID: p.ProdID, CatID: p.CatID, Old: &o, New: &p, Fields: fields})
+		}
+	}
+	for _, p := range old {
+		p := p
+		k := keyOf(p)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		changes = append(changes, Change{Kind: Removed, ProdID: p.ProdID, CatID: p.CatID, Old: &p})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Name() != changes[j].Name() {
+			return changes[i].Name() < changes[j].Name()
+		}
+		return changes[i].ProdID+"/"+changes[i].CatID < changes[j].ProdID+"/"+changes[j].CatID
+	})
+	return changes
+}
+
+func changedFields(old scraper.Product, new scraper.Product) []string {
+	fields := []string{}
+	if old.Name != new.Name {
+		fields = append(fields, "Name")
+	}
+	if old.URL != new.URL {
+		fields = append(fields, "URL")
+	}
+	if old.Price != new.Price {
+		fields = append(fields, "Price")
+	}
+	if old.Savings != new.Savings {
+		fields = append(fields, "Savings")
+	}
+	if old.Percentage != new.Percentage {
+		fields = append(fields, "Percentage")
+	}
+	if old.MemberLevel != new.MemberLevel {
+		fields = append(fields, "MemberLevel")
+	}
+	return fields
+}
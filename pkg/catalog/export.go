@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// Export formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+)
+
+// Formats are the formats products can be exported in.
+var Formats = []string{FormatCSV, FormatJSON, FormatJSONL}
+
+// FormatOf guesses the export format from a file name's extension.
+func FormatOf(filename string) (string, bool) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	for _, f := range Formats {
+		if ext == f {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Export writes products to w in one of Formats.
+func Export(w io.Writer, ps []scraper.Product, format string) error {
+	switch format {
+	case FormatCSV:
+		return exportCSV(w, ps)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ps)
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, p := range ps {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q: must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+func exportCSV(w io.Writer, ps []scraper.Product) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"prodId", "catId", "name", "url", "price", "savings", "percentage", "memberLevel"}); err != nil {
+		return err
+	}
+	for _, p := range ps {
+		err := cw.Write([]string{
+			p.ProdID,
+			p.CatID,
+			p.Name,
+			p.URL,
+			strconv.FormatFloat(p.Price, 'f', 2, 64),
+			strconv.FormatFloat(p.Savings, 'f', 2, 64),
+			strconv.FormatFloat(p.Percentage, 'f', -1, 64),
+			strconv.Itoa(p.MemberLevel),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
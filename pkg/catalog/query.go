@@ -0,0 +1,87 @@
+package catalog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// SortFields are the fields products can be sorted by. Prefixed with "-" they sort in descending order.
+var SortFields = []string{"name", "price", "savings", "percentage"}
+
+// Query selects products. The zero value selects all of them, in their original order.
+type Query struct {
+	// Name only selects products whose name matches it
+	Name *regexp.Regexp
+	// Categories only selects products in these categories (catId)
+	Categories []string
+	// MinPercentage only selects products discounted by at least this much; any discount at all if it's negative
+	MinPercentage float64
+	// MaxPrice only selects products that cost at most this much (0 for no limit)
+	MaxPrice float64
+	// Sort is one of SortFields, optionally prefixed with "-" to sort in descending order
+	Sort string
+	// Limit is the most products to select (0 for no limit)
+	Limit int
+}
+
+// Run returns the products q selects.
+func (q Query) Run(ps []scraper.Product) ([]scraper.Product, error) {
+	less, err := lessFunc(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := map[string]bool{}
+	for _, c := range q.Categories {
+		categories[c] = true
+	}
+
+	selected := []scraper.Product{}
+	for _, p := range ps {
+		switch {
+		case q.Name != nil && !q.Name.MatchString(p.Name):
+		case len(categories) > 0 && !categories[p.CatID]:
+		case q.MinPercentage < 0 && p.Percentage == 0:
+		case q.MinPercentage > 0 && p.Percentage < q.MinPercentage:
+		case q.MaxPrice > 0 && p.Price > q.MaxPrice:
+		default:
+			selected = append(selected, p)
+		}
+	}
+
+	if less != nil {
+		sort.SliceStable(selected, func(i, j int) bool { return less(selected[i], selected[j]) })
+	}
+	if q.Limit > 0 && len(selected) > q.Limit {
+		selected = selected[:q.Limit]
+	}
+	return selected, nil
+}
+
+func lessFunc(field string) (func(a, b scraper.Product) bool, error) {
+	if field == "" {
+		return nil, nil
+	}
+	desc := strings.HasPrefix(field, "-")
+	var less func(a, b scraper.Product) bool
+	switch strings.TrimPrefix(field, "-") {
+	case "name":
+		less = func(a, b scraper.Product) bool { return a.Name < b.Name }
+	case "price":
+		less = func(a, b scraper.Product) bool { return a.Price < b.Price }
+	case "savings":
+		less = func(a, b scraper.Product) bool { return a.Savings < b.Savings }
+	case "percentage":
+		less = func(a, b scraper.Product) bool { return a.Percentage < b.Percentage }
+	default:
+		return nil, fmt.Errorf("can't sort by %q: must be one of %s, optionally prefixed with -", field, strings.Join(SortFields, ", "))
+	}
+	if desc {
+		return func(a, b scraper.Product) bool { return less(b, a) }, nil
+	}
+	return less, nil
+}
@@ -0,0 +1,212 @@
+// Package config is the configuration of the ebucks-dealz commands. Every setting has a default, which can be
+// overridden by a YAML file, then by an environment variable, then by a command line flag.
+//
+// Settings are named by their key in the file, e.g. scraper.threads, whose environment variable is
+// EBUCKS_DEALZ_SCRAPER_THREADS. Lists are comma separated in environment variables and flags.
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+	"github.com/geniass/ebucks-dealz/pkg/web"
+)
+
+const (
+	// EnvPrefix is the prefix of the environment variables of settings.
+	EnvPrefix = "EBUCKS_DEALZ_"
+	// EnvFile is the environment variable with the path of the config file.
+	EnvFile = EnvPrefix + "CONFIG"
+	// DefaultFile is the config file loaded when no other is given, if it exists.
+	DefaultFile = "ebucks-dealz.yaml"
+)
+
+// Config is every setting of every command.
+type Config struct {
+	Log         Log         `yaml:"log"`
+	Scraper     Scraper     `yaml:"scraper"`
+	Storage     Storage     `yaml:"storage"`
+	Render      Render      `yaml:"render"`
+	Serve       Serve       `yaml:"serve"`
+	Watch       Watch       `yaml:"watch"`
+	Distributed Distributed `yaml:"distributed"`
+	Daemon      Daemon      `yaml:"daemon"`
+
+	// sources are where the settings that aren't defaults came from, by key: file:line, $VARIABLE or -flag
+	sources map[string]string
+	// flags are the keys of the settings bound to flags with Var, by flag name
+	flags map[string]string
+}
+
+// Log is how the commands log.
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Scraper is how the shop is crawled, see the scraper package's options.
+type Scraper struct {
+	BaseURL string `yaml:"baseURL"`
+	// StartURL is where the crawl starts, the home page if empty
+	StartURL    string        `yaml:"startURL"`
+	Threads     int           `yaml:"threads"`
+	Delay       time.Duration `yaml:"delay"`
+	RandomDelay time.Duration `yaml:"randomDelay"`
+	MaxRetries  int           `yaml:"maxRetries"`
+
+	// Login turns on logging in as a member, whose login flow hasn't been checked against the live site
+	Login           bool     `yaml:"login"`
+	CredentialsFile string   `yaml:"credentialsFile"`
+	SessionFile     string   `yaml:"sessionFile"`
+	Proxies         []string `yaml:"proxies"`
+	ProxyRotation   string   `yaml:"proxyRotation"`
+
+	Categories           []string      `yaml:"categories"`
+	ExcludeCategories    []string      `yaml:"excludeCategories"`
+	CategoryNames        string        `yaml:"categoryNames"`
+	ExcludeCategoryNames string        `yaml:"excludeCategoryNames"`
+	MaxDepth             int           `yaml:"maxDepth"`
+	MaxPages             int           `yaml:"maxPages"`
+	TimeBudget           time.Duration `yaml:"timeBudget"`
+
+	OutageSample    int     `yaml:"outageSample"`
+	OutageThreshold float64 `yaml:"outageThreshold"`
+
+	Progress         string        `yaml:"progress"`
+	ProgressInterval time.Duration `yaml:"progressInterval"`
+	MetricsAddr      string        `yaml:"metricsAddr"`
+	// TraceFile is where OTLP/JSON spans are written, empty to not trace
+	TraceFile string `yaml:"traceFile"`
+	// DebugAddr is where the debug server listens, empty for none
+	DebugAddr string `yaml:"debugAddr"`
+}
+
+// Storage is where scraped data is kept.
+type Storage struct {
+	DataDir     string `yaml:"dataDir"`
+	Overwrite   bool   `yaml:"overwrite"`
+	CacheDir    string `yaml:"cacheDir"`
+	WARCDir     string `yaml:"warcDir"`
+	WARCMaxSize int64  `yaml:"warcMaxSize"`
+}
+
+// Render is how the site is rendered.
+type Render struct {
+	OutputDir     string `yaml:"outputDir"`
+	PathPrefix    string `yaml:"pathPrefix"`
+	Timezone      string `yaml:"timezone"`
+	DiscountTitle string `yaml:"discountTitle"`
+	OtherTitle    string `yaml:"otherTitle"`
+}
+
+// Location is the timezone times are shown in.
+func (r Render) Location() (*time.Location, error) {
+	return time.LoadLocation(r.Timezone)
+}
+
+// Serve is how the site is served.
+type Serve struct {
+	Addr       string `yaml:"addr"`
+	PathPrefix string `yaml:"pathPrefix"`
+}
+
+// Watch is which products' discounts are watched, and where their changes are reported.
+type Watch struct {
+	Watchlist    string        `yaml:"watchlist"`
+	Categories   []string      `yaml:"categories"`
+	Relist       time.Duration `yaml:"relist"`
+	Interval     time.Duration `yaml:"interval"`
+	Jitter       time.Duration `yaml:"jitter"`
+	Budget       int           `yaml:"budget"`
+	BudgetWindow time.Duration `yaml:"budgetWindow"`
+	Out          string        `yaml:"out"`
+}
+
+// Distributed is how a crawl is spread over a coordinator and its workers.
+type Distributed struct {
+	Addr        string        `yaml:"addr"`
+	Lease       time.Duration `yaml:"lease"`
+	MaxAttempts int           `yaml:"maxAttempts"`
+	Coordinator string        `yaml:"coordinator"`
+}
+
+// Daemon is when the daemon scrapes, and how it retries failed scrapes.
+type Daemon struct {
+	// Schedule is a cron expression, see daemon.ParseSchedule
+	Schedule    string        `yaml:"schedule"`
+	RunOnStart  bool          `yaml:"runOnStart"`
+	MaxAttempts int           `yaml:"maxAttempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"maxBackoff"`
+	History     int           `yaml:"history"`
+	// LockFile keeps a second daemon from scraping into the same data dir, the data dir with .lock appended if empty
+	LockFile string `yaml:"lockFile"`
+}
+
+// Default is the configuration without a file, environment variables or flags.
+func Default() *Config {
+	return &Config{
+		Log: Log{Level: "info", Format: "text"},
+		Scraper: Scraper{
+			BaseURL:          scraper.DefaultBaseURL,
+			Threads:          1,
+			Delay:            2 * time.Second,
+			RandomDelay:      5 * time.Second,
+			MaxRetries:       scraper.DefaultMaxRetries,
+			ProxyRotation:    "request",
+			OutageSample:     scraper.DefaultOutageSample,
+			OutageThreshold:  scraper.DefaultOutageThreshold,
+			Progress:         "auto",
+			ProgressInterval: 10 * time.Second,
+		},
+		Storage: Storage{DataDir: "./data", WARCMaxSize: 1024},
+		Render: Render{
+			OutputDir:     "docs",
+			Timezone:      web.DefaultTimezone,
+			DiscountTitle: web.DefaultDiscountTitle,
+			OtherTitle:    web.DefaultOtherTitle,
+		},
+		Serve: Serve{Addr: ":8080"},
+		Watch: Watch{Relist: time.Hour, Interval: 5 * time.Minute, Jitter: time.Minute, Budget: 600, BudgetWindow: time.Hour, Out: "-"},
+		Distributed: Distributed{
+			Addr:        ":7070",
+			Lease:       5 * time.Minute,
+			MaxAttempts: 3,
+			Coordinator: "http://localhost:7070",
+		},
+		Daemon: Daemon{
+			Schedule:    "5 0/6 * * *",
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+			MaxBackoff:  30 * time.Minute,
+			History:     100,
+		},
+		sources: map[string]string{},
+		flags:   map[string]string{},
+	}
+}
+
+// Source is where the setting at key came from: file:line, $VARIABLE or -flag. It is empty for a default.
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Error is a setting that couldn't be loaded, or that is invalid.
+type Error struct {
+	Key string
+	// Source is where the setting came from, see Config.Source
+	Source string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("%s: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Source, e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
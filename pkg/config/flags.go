@@ -0,0 +1,66 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Var binds the flag name on fs to the setting at key, defaulting to its current value. The same setting can be
+// bound on more than one flag set, e.g. before and after a subcommand. It panics if there's no setting at key.
+func (c *Config) Var(fs *flag.FlagSet, name string, key string, usage string) {
+	s, ok := c.lookup(key)
+	if !ok {
+		panic(fmt.Sprintf("config: no setting %q", key))
+	}
+	if source, ok := c.sources[key]; ok {
+		usage += " (set by " + source + ")"
+	}
+	switch p := s.value.Addr().Interface().(type) {
+	case *string:
+		fs.StringVar(p, name, *p, usage)
+	case *int:
+		fs.IntVar(p, name, *p, usage)
+	case *int64:
+		fs.Int64Var(p, name, *p, usage)
+	case *float64:
+		fs.Float64Var(p, name, *p, usage)
+	case *bool:
+		fs.BoolVar(p, name, *p, usage)
+	case *time.Duration:
+		fs.DurationVar(p, name, *p, usage)
+	case *[]string:
+		fs.Var(listValue{p}, name, usage)
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", p))
+	}
+	c.flags[name] = key
+}
+
+// Parsed records which settings were set by the flags of fs, after it has been parsed.
+func (c *Config) Parsed(fs *flag.FlagSet) {
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := c.flags[f.Name]; ok {
+			c.sources[key] = "-" + f.Name
+		}
+	})
+}
+
+// listValue is a comma separated list flag
+type listValue struct {
+	items *[]string
+}
+
+func (v listValue) String() string {
+	if v.items == nil {
+		// flag makes zero values to find out the defaults
+		return ""
+	}
+	return strings.Join(*v.items, ",")
+}
+
+func (v listValue) Set(text string) error {
+	*v.items = splitList(text)
+	return nil
+}
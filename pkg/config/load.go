@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load loads the configuration: the defaults, overridden by the YAML file at path, overridden by the environment
+// (a list of KEY=value, like os.Environ). An empty path loads DefaultFile if it exists. Flags are bound with Var.
+func Load(path string, environ []string) (*Config, error) {
+	c := Default()
+
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	} else if err := c.loadFile(DefaultFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := c.loadEnv(environ); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile overrides the settings that are in the YAML file at path
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		// empty file
+		return nil
+	}
+	return c.decode(doc.Content[0], reflect.ValueOf(c).Elem(), "", path)
+}
+
+// decode sets the fields of v, a struct of settings, from the mapping n
+func (c *Config) decode(n *yaml.Node, v reflect.Value, prefix string, path string) error {
+	if n.Kind != yaml.MappingNode {
+		key := strings.TrimSuffix(prefix, ".")
+		if key == "" {
+			return fmt.Errorf("%s:%d: expected a mapping of settings", path, n.Line)
+		}
+		return &Error{Key: key, Source: fmt.Sprintf("%s:%d", path, n.Line), Err: errors.New("expected a mapping of settings")}
+	}
+
+	errs := []error{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, value := n.Content[i], n.Content[i+1]
+		key := prefix + k.Value
+		source := fmt.Sprintf("%s:%d", path, k.Line)
+
+		f, ok := field(v, k.Value)
+		if !ok {
+			errs = append(errs, &Error{Key: key, Source: source, Err: errors.New("unknown setting")})
+			continue
+		}
+		if f.Kind() == reflect.Struct {
+			if err := c.decode(value, f, key+".", path); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		s := setting{key: key, value: f}
+		if err := value.Decode(f.Addr().Interface()); err != nil {
+			errs = append(errs, &Error{Key: key, Source: source, Err: fmt.Errorf("invalid value %q, must be %s", value.Value, s.kind())})
+			continue
+		}
+		if f.Kind() == reflect.Slice && f.Len() == 0 {
+			// like an empty list in an environment variable or flag
+			f.Set(reflect.Zero(f.Type()))
+		}
+		c.sources[key] = source
+	}
+	return errors.Join(errs...)
+}
+
+// field finds the field of v, a struct of settings, named name in the file
+func field(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if n, ok := yamlName(v.Type().Field(i)); ok && n == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// loadEnv overrides the settings that have an environment variable
+func (c *Config) loadEnv(environ []string) error {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+
+	errs := []error{}
+	for _, s := range c.settings() {
+		name := EnvName(s.key)
+		text, ok := env[name]
+		if !ok {
+			continue
+		}
+		if err := s.set(text); err != nil {
+			errs = append(errs, &Error{Key: s.key, Source: "$" + name, Err: err})
+			continue
+		}
+		c.sources[s.key] = "$" + name
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"io"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WriteYAML writes the settings as a config file, with where each one that isn't a default came from as a comment.
+// Passwords in proxy URLs are redacted.
+func (c *Config) WriteYAML(w io.Writer) error {
+	redacted := *c
+	redacted.Scraper.Proxies = nil
+	for _, p := range c.Scraper.Proxies {
+		if u, err := url.Parse(p); err == nil {
+			p = u.Redacted()
+		}
+		redacted.Scraper.Proxies = append(redacted.Scraper.Proxies, p)
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(&redacted); err != nil {
+		return err
+	}
+	c.annotate(&doc, "")
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// annotate comments the settings in the mapping n with where they came from
+func (c *Config) annotate(n *yaml.Node, prefix string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, value := n.Content[i], n.Content[i+1]
+		key := prefix + k.Value
+		if value.Kind == yaml.MappingNode {
+			c.annotate(value, key+".")
+			continue
+		}
+		if source, ok := c.sources[key]; ok {
+			k.LineComment = "from " + source
+		}
+	}
+}
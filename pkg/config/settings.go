@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is a single setting of a Config
+type setting struct {
+	key   string
+	value reflect.Value
+}
+
+// settings are all of c's settings, in the order they're declared
+func (c *Config) settings() []setting {
+	ss := []setting{}
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := yamlName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			key := prefix + name
+			if f := v.Field(i); f.Kind() == reflect.Struct {
+				walk(f, key+".")
+			} else {
+				ss = append(ss, setting{key: key, value: f})
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return ss
+}
+
+// lookup finds the setting at key
+func (c *Config) lookup(key string) (setting, bool) {
+	for _, s := range c.settings() {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// yamlName is the name of a field in the file, false for fields that aren't settings
+func yamlName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return name, name != "" && name != "-"
+}
+
+// EnvName is the environment variable of the setting at key, e.g. EBUCKS_DEALZ_SCRAPER_BASE_URL for
+// scraper.baseURL.
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	rs := []rune(key)
+	for i, r := range rs {
+		switch {
+		case r == '.':
+			b.WriteRune('_')
+			continue
+		case unicode.IsUpper(r) && i > 0 && rs[i-1] != '.':
+			prevLower := unicode.IsLower(rs[i-1])
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if prevLower || nextLower {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// set parses text, as given in an environment variable or flag, into a setting
+func (s setting) set(text string) error {
+	v := s.value
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, e.g. 90s or 5m", text)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid whole number %q", text)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, must be true or false", text)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(splitList(text)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// String formats a setting the way set parses it
+func (s setting) String() string {
+	v := s.value
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// kind describes what a setting's value looks like, for error messages
+func (s setting) kind() string {
+	if s.value.Type() == durationType {
+		return "a duration, e.g. 90s or 5m"
+	}
+	switch s.value.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a list"
+	default:
+		return "a string"
+	}
+}
+
+// splitList splits a comma separated list, nil if it's empty
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
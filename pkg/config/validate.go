@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/daemon"
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+)
+
+// Validate checks every setting, returning an *Error for each invalid one.
+func (c *Config) Validate() error {
+	errs := []error{}
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, &Error{Key: key, Source: c.sources[key], Err: fmt.Errorf(format, args...)})
+	}
+	atLeast := func(key string, n int, min int) {
+		if n < min {
+			invalid(key, "must be at least %d, not %d", min, n)
+		}
+	}
+	notNegative := func(key string, d time.Duration) {
+		if d < 0 {
+			invalid(key, "must not be negative, not %s", d)
+		}
+	}
+	positive := func(key string, d time.Duration) {
+		if d <= 0 {
+			invalid(key, "must be more than 0, not %s", d)
+		}
+	}
+	oneOf := func(key string, value string, values ...string) {
+		for _, v := range values {
+			if value == v {
+				return
+			}
+		}
+		invalid(key, "must be one of %s, not %q", strings.Join(values, ", "), value)
+	}
+	absoluteURL := func(key string, value string) {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid(key, "must be an http or https URL, not %q", value)
+		}
+	}
+	pathPrefix := func(key string, value string) {
+		if value != "" && !strings.HasPrefix(value, "/") {
+			invalid(key, "must start with /, not %q", value)
+		}
+	}
+	pattern := func(key string, value string) {
+		if _, err := regexp.Compile(value); err != nil {
+			invalid(key, "invalid regular expression: %v", err)
+		}
+	}
+	notEmpty := func(key string, value string) {
+		if value == "" {
+			invalid(key, "must not be empty")
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level", "must be one of debug, info, warn, error, not %q", c.Log.Level)
+	}
+	oneOf("log.format", c.Log.Format, "text", "json")
+
+	s := c.Scraper
+	absoluteURL("scraper.baseURL", s.BaseURL)
+	if s.StartURL != "" {
+		absoluteURL("scraper.startURL", s.StartURL)
+	}
+	atLeast("scraper.threads", s.Threads, 1)
+	notNegative("scraper.delay", s.Delay)
+	notNegative("scraper.randomDelay", s.RandomDelay)
+	atLeast("scraper.maxRetries", s.MaxRetries, 0)
+	if len(s.Proxies) > 0 {
+		// the same checks the pool makes when the scraper is created
+		if _, err := proxy.New(s.Proxies, proxy.PerRequest); err != nil {
+			invalid("scraper.proxies", "%v", err)
+		}
+	}
+	if _, err := proxy.ParseRotation(s.ProxyRotation); err != nil {
+		invalid("scraper.proxyRotation", "%v, must be request or worker", err)
+	}
+	pattern("scraper.categoryNames", s.CategoryNames)
+	pattern("scraper.excludeCategoryNames", s.ExcludeCategoryNames)
+	atLeast("scraper.maxDepth", s.MaxDepth, 0)
+	atLeast("scraper.maxPages", s.MaxPages, 0)
+	notNegative("scraper.timeBudget", s.TimeBudget)
+	atLeast("scraper.outageSample", s.OutageSample, 0)
+	if s.OutageThreshold <= 0 || s.OutageThreshold > 1 {
+		invalid("scraper.outageThreshold", "must be more than 0 and at most 1, not %g", s.OutageThreshold)
+	}
+	oneOf("scraper.progress", s.Progress, "auto", "tty", "log", "off")
+	positive("scraper.progressInterval", s.ProgressInterval)
+	if s.TraceFile == "-" {
+		// stdout is where a scrape that finds the site down says so
+		invalid("scraper.traceFile", "must be a file, stdout is kept for the scrape's status")
+	}
+
+	notEmpty("storage.dataDir", c.Storage.DataDir)
+	if c.Storage.WARCMaxSize < 1 {
+		invalid("storage.warcMaxSize", "must be at least 1, not %d", c.Storage.WARCMaxSize)
+	}
+
+	notEmpty("render.outputDir", c.Render.OutputDir)
+	pathPrefix("render.pathPrefix", c.Render.PathPrefix)
+	if _, err := c.Render.Location(); err != nil {
+		invalid("render.timezone", "unknown timezone %q, e.g. Africa/Johannesburg or UTC", c.Render.Timezone)
+	}
+	notEmpty("render.discountTitle", c.Render.DiscountTitle)
+	notEmpty("render.otherTitle", c.Render.OtherTitle)
+
+	notEmpty("serve.addr", c.Serve.Addr)
+	pathPrefix("serve.pathPrefix", c.Serve.PathPrefix)
+
+	positive("watch.relist", c.Watch.Relist)
+	positive("watch.interval", c.Watch.Interval)
+	notNegative("watch.jitter", c.Watch.Jitter)
+	atLeast("watch.budget", c.Watch.Budget, 0)
+	positive("watch.budgetWindow", c.Watch.BudgetWindow)
+	notEmpty("watch.out", c.Watch.Out)
+
+	notEmpty("distributed.addr", c.Distributed.Addr)
+	positive("distributed.lease", c.Distributed.Lease)
+	atLeast("distributed.maxAttempts", c.Distributed.MaxAttempts, 1)
+	absoluteURL("distributed.coordinator", c.Distributed.Coordinator)
+
+	if _, err := daemon.ParseSchedule(c.Daemon.Schedule); err != nil {
+		invalid("daemon.schedule", "%v", err)
+	}
+	atLeast("daemon.maxAttempts", c.Daemon.MaxAttempts, 1)
+	positive("daemon.backoff", c.Daemon.Backoff)
+	if c.Daemon.MaxBackoff < c.Daemon.Backoff {
+		invalid("daemon.maxBackoff", "must be at least daemon.backoff %s, not %s", c.Daemon.Backoff, c.Daemon.MaxBackoff)
+	}
+	atLeast("daemon.history", c.Daemon.History, 1)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is when runs are due, as a cron expression.
+type Schedule struct {
+	expr string
+	// minute, hour, dom, month and dow are the values each field matches, dow 0 being Sunday
+	minute, hour, dom, month, dow [60]bool
+	// domAny and dowAny are whether the day fields are *, since a day matches if either of them does otherwise
+	domAny, dowAny bool
+}
+
+// shortcuts are the @ forms of common expressions
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a standard five field cron expression: minute, hour, day of month, month and day of week,
+// each a *, a value, a range a-b, a list of those separated by commas, and any of them followed by /step. A
+// single value followed by /step runs from that value, e.g. 5/15 in the minute field is 5, 20, 35 and 50. Days of
+// week are 0 to 7, both 0 and 7 being Sunday. @hourly, @daily, @weekly, @monthly and @yearly are shorthands.
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if s, ok := shortcuts[fields[0]]; ok {
+			fields = strings.Fields(s)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q should have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	s := &Schedule{expr: expr}
+	specs := []struct {
+		name     string
+		values   *[60]bool
+		min, max int
+	}{
+		{"minute", &s.minute, 0, 59},
+		{"hour", &s.hour, 0, 23},
+		{"day of month", &s.dom, 1, 31},
+		{"month", &s.month, 1, 12},
+		{"day of week", &s.dow, 0, 7},
+	}
+	for i, spec := range specs {
+		if err := parseField(fields[i], spec.values, spec.min, spec.max); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s: %w", expr, spec.name, err)
+		}
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+// parseField sets the values the field matches
+func parseField(field string, values *[60]bool, min int, max int) error {
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		var from, to int
+		switch first, last, isRange := strings.Cut(rng, "-"); {
+		case rng == "*":
+			from, to = min, max
+		case isRange:
+			var err error
+			if from, err = parseValue(first, min, max); err != nil {
+				return err
+			}
+			if to, err = parseValue(last, min, max); err != nil {
+				return err
+			}
+			if from > to {
+				return fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			var err error
+			if from, err = parseValue(rng, min, max); err != nil {
+				return err
+			}
+			to = from
+			if hasStep {
+				to = max
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return nil
+}
+
+func parseValue(text string, min int, max int) (int, error) {
+	v, err := strconv.Atoi(text)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value %q, must be %d to %d", text, min, max)
+	}
+	return v, nil
+}
+
+// maxSearch is how far ahead Next looks before giving up on a schedule that never matches, like 30 2 31 2 *
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next is the first time after t that the schedule matches, in t's location. It is zero if there is none.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(maxSearch)
+	for t.Before(end) {
+		switch {
+		case !s.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.day(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// day says whether t's day matches: both day fields, or either of them when neither is *
+func (s *Schedule) day(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *Schedule) String() string {
+	return s.expr
+}
@@ -0,0 +1,339 @@
+// Package daemon runs a job, like a scrape, on a cron schedule. A run that fails is retried with exponential
+// backoff, and the daemon keeps a history of its runs, served over HTTP along with its health.
+//
+// Runs never overlap: the next run is scheduled from when the last one finished, so a time that comes while a
+// run is still going is skipped. Lock keeps a second daemon from running at the same time.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Minute
+	defaultMaxBackoff  = 30 * time.Minute
+	defaultHistory     = 100
+)
+
+// ErrSiteUnavailable is what a job returns, wrapped, when it failed because the shop is down rather than because
+// something is wrong with the daemon.
+var ErrSiteUnavailable = errors.New("site unavailable")
+
+// Outcome is how a run or attempt ended.
+type Outcome string
+
+const (
+	OutcomeSucceeded       Outcome = "succeeded"
+	OutcomeFailed          Outcome = "failed"
+	OutcomeSiteUnavailable Outcome = "site-unavailable"
+)
+
+func outcome(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSucceeded
+	case errors.Is(err, ErrSiteUnavailable):
+		return OutcomeSiteUnavailable
+	default:
+		return OutcomeFailed
+	}
+}
+
+// Attempt is one go at a run.
+type Attempt struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Outcome  Outcome   `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Run is a scheduled run of the job, and its attempts.
+type Run struct {
+	ID        int       `json:"id"`
+	Scheduled time.Time `json:"scheduled"`
+	Started   time.Time `json:"started"`
+	// Finished and Outcome, that of the last attempt, are empty while the run is going
+	Finished *time.Time `json:"finished,omitempty"`
+	Outcome  Outcome    `json:"outcome,omitempty"`
+	Attempts []Attempt  `json:"attempts"`
+}
+
+// Config configures a Daemon.
+type Config struct {
+	// Job is what is run. It should return ctx's error once ctx is cancelled.
+	Job func(ctx context.Context) error
+	// MaxAttempts is how many times a run is attempted before it is given up on (default 3)
+	MaxAttempts int
+	// Backoff is how long to wait before the first retry of a run (default 1 minute), doubling for every retry
+	// after it up to MaxBackoff (default 30 minutes)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// History is how many of the last runs are kept (default 100)
+	History int
+	// RunOnStart runs the job as soon as the daemon starts, as well as on the schedule
+	RunOnStart bool
+	Logger     *slog.Logger
+}
+
+// Daemon runs a job on a schedule. It is an http.Handler serving its run history at /runs, newest first, and its
+// Health at /healthz.
+type Daemon struct {
+	schedule *Schedule
+	cfg      Config
+	mux      *http.ServeMux
+
+	mutex   sync.Mutex
+	started time.Time
+	// runs are the last cfg.History runs, oldest first
+	runs []*Run
+	// current is the run that is going, nil between runs
+	current     *Run
+	lastID      int
+	next        time.Time
+	lastSuccess time.Time
+
+	// sleep waits for d, or for ctx to be cancelled
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New creates a daemon that runs cfg.Job on schedule.
+func New(schedule *Schedule, cfg Config) (*Daemon, error) {
+	if cfg.Job == nil {
+		return nil, errors.New("no job to run")
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		return nil, fmt.Errorf("max backoff %s should be at least the backoff %s", cfg.MaxBackoff, cfg.Backoff)
+	}
+	if cfg.History <= 0 {
+		cfg.History = defaultHistory
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	d := &Daemon{schedule: schedule, cfg: cfg, mux: http.NewServeMux(), sleep: sleep}
+	d.mux.HandleFunc("/runs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Runs())
+	})
+	d.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h := d.Health()
+		status := http.StatusOK
+		if !h.Healthy() {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, h)
+	})
+	return d, nil
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// Run runs the job on the schedule until ctx is cancelled, and returns ctx's error. It fails straight away if the
+// schedule never comes round.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.mutex.Lock()
+	d.started = time.Now()
+	d.mutex.Unlock()
+
+	if d.cfg.RunOnStart {
+		d.run(ctx, time.Now())
+	}
+	for ctx.Err() == nil {
+		next := d.schedule.Next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("schedule %q never runs", d.schedule)
+		}
+		d.mutex.Lock()
+		d.next = next
+		d.mutex.Unlock()
+		d.cfg.Logger.Info("next run scheduled", "time", next)
+
+		if err := d.sleep(ctx, time.Until(next)); err != nil {
+			break
+		}
+		d.run(ctx, next)
+	}
+	return ctx.Err()
+}
+
+// run attempts a run until it succeeds, it has been attempted cfg.MaxAttempts times or ctx is cancelled
+func (d *Daemon) run(ctx context.Context, scheduled time.Time) {
+	r := d.start(scheduled)
+	defer d.finish(r)
+	logger := d.cfg.Logger.With("run", r.ID)
+	logger.Info("run started")
+
+	backoff := d.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		a := Attempt{Started: time.Now()}
+		err := d.cfg.Job(ctx)
+		a.Finished = time.Now()
+		a.Outcome = outcome(err)
+		if err != nil {
+			a.Error = err.Error()
+		}
+		d.record(r, a)
+
+		if err == nil {
+			logger.Info("run succeeded", "attempt", attempt, "took", a.Finished.Sub(r.Started))
+			return
+		}
+		if ctx.Err() != nil {
+			logger.Warn("run interrupted", "attempt", attempt, logging.KeyError, err)
+			return
+		}
+		if attempt == d.cfg.MaxAttempts {
+			logger.Error("run failed, giving up", "attempt", attempt, "outcome", a.Outcome, logging.KeyError, err)
+			return
+		}
+		logger.Warn("run failed, retrying", "attempt", attempt, "outcome", a.Outcome, "backoff", backoff, logging.KeyError, err)
+		if err := d.sleep(ctx, backoff); err != nil {
+			return
+		}
+		backoff = min(2*backoff, d.cfg.MaxBackoff)
+	}
+}
+
+// start adds a run to the history
+func (d *Daemon) start(scheduled time.Time) *Run {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.lastID++
+	r := &Run{ID: d.lastID, Scheduled: scheduled, Started: time.Now(), Attempts: []Attempt{}}
+	d.runs = append(d.runs, r)
+	if len(d.runs) > d.cfg.History {
+		d.runs = d.runs[len(d.runs)-d.cfg.History:]
+	}
+	d.current = r
+	d.next = time.Time{}
+	return r
+}
+
+// record adds an attempt to r
+func (d *Daemon) record(r *Run, a Attempt) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	r.Attempts = append(r.Attempts, a)
+	if a.Outcome == OutcomeSucceeded {
+		d.lastSuccess = a.Finished
+	}
+}
+
+// finish ends r with the outcome of its last attempt
+func (d *Daemon) finish(r *Run) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	finished := time.Now()
+	r.Finished = &finished
+	r.Outcome = OutcomeFailed
+	if len(r.Attempts) > 0 {
+		r.Outcome = r.Attempts[len(r.Attempts)-1].Outcome
+	}
+	d.current = nil
+}
+
+// Runs are the last runs, newest first.
+func (d *Daemon) Runs() []Run {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	runs := make([]Run, 0, len(d.runs))
+	for i := len(d.runs) - 1; i >= 0; i-- {
+		runs = append(runs, copyRun(d.runs[i]))
+	}
+	return runs
+}
+
+func copyRun(r *Run) Run {
+	copied := *r
+	copied.Attempts = append([]Attempt{}, r.Attempts...)
+	return copied
+}
+
+// Health is the state of the daemon.
+type Health struct {
+	// Status is ok, or failing if the last run failed for another reason than the shop being down
+	Status  string    `json:"status"`
+	Started time.Time `json:"started"`
+	// Running is whether a run is going
+	Running     bool       `json:"running"`
+	NextRun     *time.Time `json:"nextRun,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	// LastRun is the last finished run
+	LastRun *Run `json:"lastRun,omitempty"`
+}
+
+// Healthy says whether the status is ok.
+func (h Health) Healthy() bool {
+	return h.Status == "ok"
+}
+
+// Health is the state of the daemon.
+func (d *Daemon) Health() Health {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	h := Health{Status: "ok", Started: d.started, Running: d.current != nil}
+	if !d.next.IsZero() {
+		next := d.next
+		h.NextRun = &next
+	}
+	if !d.lastSuccess.IsZero() {
+		lastSuccess := d.lastSuccess
+		h.LastSuccess = &lastSuccess
+	}
+	for i := len(d.runs) - 1; i >= 0; i-- {
+		if d.runs[i] != d.current {
+			last := copyRun(d.runs[i])
+			h.LastRun = &last
+			break
+		}
+	}
+	if h.LastRun != nil && h.LastRun.Outcome == OutcomeFailed {
+		h.Status = "failing"
+	}
+	return h
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
@@ -0,0 +1,209 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Sunday
+	from := time.Date(2026, 3, 1, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 1, 10, 18, 0, 0, time.UTC)},
+		{"5 0/6 * * *", time.Date(2026, 3, 1, 12, 5, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)},
+		{"30 2 29 2 *", time.Date(2028, 2, 29, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// either day field matches when both are restricted
+		{"0 0 15 * 3", time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 2 31 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		s, err := ParseSchedule(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(test.expected) {
+			t.Errorf("%s: got %v expected %v", test.expr, got, test.expected)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@often"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("%q should be invalid", expr)
+		}
+	}
+}
+
+// newTestDaemon makes a daemon running job whose sleeps are recorded rather than slept
+func newTestDaemon(t *testing.T, job func(ctx context.Context) error, cfg Config) (*Daemon, *[]time.Duration) {
+	t.Helper()
+	s, err := ParseSchedule("@hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Job = job
+	cfg.Logger = logging.Discard()
+	d, err := New(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slept := []time.Duration{}
+	d.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return ctx.Err()
+	}
+	return d, &slept
+}
+
+func TestRunRetriesWithBackoff(t *testing.T) {
+	errs := []error{errors.New("boom"), fmt.Errorf("scrape: %w", ErrSiteUnavailable), errors.New("boom"), nil}
+	calls := 0
+	job := func(ctx context.Context) error {
+		calls++
+		return errs[calls-1]
+	}
+	d, slept := newTestDaemon(t, job, Config{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+
+	d.run(context.Background(), time.Now())
+	if calls != 4 {
+		t.Errorf("wrong number of attempts: got %d expected 4", calls)
+	}
+	if expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; !reflect.DeepEqual(*slept, expected) {
+		t.Errorf("wrong backoffs: got %v expected %v", *slept, expected)
+	}
+
+	runs := d.Runs()
+	if len(runs) != 1 || runs[0].Outcome != OutcomeSucceeded || runs[0].Finished == nil {
+		t.Fatalf("run should have succeeded: %+v", runs)
+	}
+	outcomes := []Outcome{}
+	for _, a := range runs[0].Attempts {
+		outcomes = append(outcomes, a.Outcome)
+	}
+	if expected := []Outcome{OutcomeFailed, OutcomeSiteUnavailable, OutcomeFailed, OutcomeSucceeded}; !reflect.DeepEqual(outcomes, expected) {
+		t.Errorf("wrong attempt outcomes: got %v expected %v", outcomes, expected)
+	}
+}
+
+func TestRunGivesUp(t *testing.T) {
+	calls := 0
+	job := func(ctx context.Context) error {
+		calls++
+		return errors.New("boom")
+	}
+	d, slept := newTestDaemon(t, job, Config{MaxAttempts: 2})
+
+	d.run(context.Background(), time.Now())
+	if calls != 2 || len(*slept) != 1 {
+		t.Errorf("wrong attempts: got %d attempts and %d backoffs expected 2 and 1", calls, len(*slept))
+	}
+	if runs := d.Runs(); runs[0].Outcome != OutcomeFailed || runs[0].Attempts[1].Error != "boom" {
+		t.Errorf("run should have failed: %+v", runs[0])
+	}
+}
+
+func TestHistoryAndHealth(t *testing.T) {
+	fail := false
+	job := func(ctx context.Context) error {
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	}
+	d, _ := newTestDaemon(t, job, Config{MaxAttempts: 1, History: 2})
+
+	get := func(path string, v interface{}) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return rec.Code
+	}
+
+	var h Health
+	if code := get("/healthz", &h); code != http.StatusOK || h.LastRun != nil || h.LastSuccess != nil {
+		t.Errorf("should be healthy before any run: got %d %+v", code, h)
+	}
+
+	d.run(context.Background(), time.Now())
+	d.run(context.Background(), time.Now())
+	fail = true
+	d.run(context.Background(), time.Now())
+
+	var runs []Run
+	get("/runs", &runs)
+	if len(runs) != 2 || runs[0].ID != 3 || runs[1].ID != 2 {
+		t.Errorf("history should be the last 2 runs, newest first: got %+v", runs)
+	}
+
+	h = Health{}
+	if code := get("/healthz", &h); code != http.StatusServiceUnavailable || h.Status != "failing" || h.LastRun.ID != 3 || h.LastSuccess == nil {
+		t.Errorf("should be failing after a failed run: got %d %+v", code, h)
+	}
+
+	fail = false
+	d.run(context.Background(), time.Now())
+	h = Health{}
+	if code := get("/healthz", &h); code != http.StatusOK || h.LastRun.ID != 4 {
+		t.Errorf("should be healthy again after a successful run: got %d %+v", code, h)
+	}
+}
+
+func TestRunStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	job := func(ctx context.Context) error {
+		calls++
+		cancel()
+		return ctx.Err()
+	}
+	d, _ := newTestDaemon(t, job, Config{RunOnStart: true})
+
+	if err := d.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error: got %v expected %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("wrong number of attempts: got %d expected 1", calls)
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.lock")
+	l, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lock(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("second lock should fail: got %v expected %v", err, ErrLocked)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Lock(path)
+	if err != nil {
+		t.Fatalf("lock should be free after unlocking: %v", err)
+	}
+	l.Unlock()
+}
@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned by Lock when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// RunLock is a lock held by a single process at a time, see Lock.
+type RunLock struct {
+	f    *os.File
+	path string
+}
+
+// Lock takes the lock at path, a file created if need be, which keeps another daemon from running the same job.
+// The process ID is written to the file, for people to find out which process holds it. It fails with ErrLocked
+// if another process holds the lock.
+func Lock(path string) (*RunLock, error) {
+	f, err := lockFile(path)
+	if errors.Is(err, ErrLocked) {
+		if pid := lockHolder(path); pid != "" {
+			return nil, fmt.Errorf("%s: %w (pid %s)", path, err, pid)
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &RunLock{f: f, path: path}, nil
+}
+
+// Unlock releases the lock.
+func (l *RunLock) Unlock() error {
+	return unlockFile(l.f, l.path)
+}
+
+// lockHolder is the process ID written to the lock file at path, if any
+func lockHolder(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile creates path, which must not exist yet. Unlike the flock on unix it outlives a process that dies
+// without unlocking, and then has to be removed by hand.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	}
+	return f, err
+}
+
+func unlockFile(f *os.File, path string) error {
+	f.Close()
+	return os.Remove(path)
+}
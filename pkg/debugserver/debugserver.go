@@ -0,0 +1,135 @@
+// Package debugserver serves a running crawl's internal state over HTTP, along with pprof,
+// for finding out what a crawl that seems stuck is doing.
+package debugserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// Target is the crawl being debugged, normally a scraper.Scraper.
+type Target interface {
+	Checkpoint() scraper.Checkpoint
+	Pause()
+	Resume()
+}
+
+const index = `<html><body><ul>
+<li><a href="/debug/queue">queue</a></li>
+<li><a href="/debug/inflight">in-flight requests</a></li>
+<li><a href="/debug/backoffs">backoffs</a></li>
+<li><a href="/debug/errors">recent errors</a></li>
+<li><a href="/debug/checkpoint">checkpoint</a> (POST to save it to a file)</li>
+<li><a href="/debug/pprof/">pprof</a></li>
+</ul>
+<form method="post" action="/debug/pause"><button>pause</button></form>
+<form method="post" action="/debug/resume"><button>resume</button></form>
+</body></html>
+`
+
+// Handler serves the state of t. Checkpoints POSTed to /debug/checkpoint are saved in checkpointDir, or the
+// system's temporary directory if it is empty. It shouldn't be a snapshot dir, which may end up being published.
+func Handler(t Target, checkpointDir string, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, index)
+	})
+
+	mux.HandleFunc("/debug/queue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, t.Checkpoint().Pending)
+	})
+	mux.HandleFunc("/debug/inflight", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, t.Checkpoint().InFlight)
+	})
+	mux.HandleFunc("/debug/backoffs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, t.Checkpoint().Backoffs)
+	})
+	mux.HandleFunc("/debug/errors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, t.Checkpoint().RecentErrors)
+	})
+
+	mux.HandleFunc("/debug/checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		c := t.Checkpoint()
+		if r.Method != http.MethodPost {
+			writeJSON(w, c)
+			return
+		}
+
+		path, err := saveCheckpoint(c, checkpointDir)
+		if err != nil {
+			logger.Error("could not save checkpoint", logging.KeyError, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("saved checkpoint", "path", path, "pending", len(c.Pending), "inFlight", len(c.InFlight))
+		fmt.Fprintln(w, path)
+	})
+
+	mux.HandleFunc("/debug/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		t.Pause()
+		logger.Info("crawl paused")
+		fmt.Fprintln(w, "paused")
+	})
+	mux.HandleFunc("/debug/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		t.Resume()
+		logger.Info("crawl resumed")
+		fmt.Fprintln(w, "resumed")
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+func saveCheckpoint(c scraper.Checkpoint, dir string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, "checkpoint-"+c.Time.Format("2006-01-02T15-04-05.000")+".json")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,163 @@
+package debugserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestPauseResumeAndCheckpoint(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 5)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	debug := httptest.NewServer(Handler(s, dir, logging.Discard()))
+	defer debug.Close()
+
+	post(t, debug.URL+"/debug/pause")
+	ps, errs := s.Stream(context.Background())
+
+	time.Sleep(200 * time.Millisecond)
+	if ts.RequestCount() != 0 {
+		t.Errorf("no requests should be made while paused: got %d", ts.RequestCount())
+	}
+	var c scraper.Checkpoint
+	get(t, debug.URL+"/debug/checkpoint", &c)
+	if !c.Paused {
+		t.Errorf("checkpoint should say the crawl is paused")
+	}
+
+	path := post(t, debug.URL+"/debug/checkpoint")
+	if _, err := os.Stat(strings.TrimSpace(path)); err != nil {
+		t.Errorf("checkpoint should have been saved: %s", err)
+	}
+
+	post(t, debug.URL+"/debug/resume")
+	n := 0
+	for ps != nil || errs != nil {
+		select {
+		case _, ok := <-ps:
+			if !ok {
+				ps = nil
+				continue
+			}
+			n++
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			t.Error(err)
+		}
+	}
+	if n != len(products) {
+		t.Errorf("wrong number of products after resuming: got %d expected %d", n, len(products))
+	}
+
+	get(t, debug.URL+"/debug/checkpoint", &c)
+	if c.Paused || len(c.Pending) != 0 || len(c.InFlight) != 0 {
+		t.Errorf("finished crawl should have nothing pending: %+v", c)
+	}
+}
+
+func TestCheckpointListsPendingAndErrors(t *testing.T) {
+	products := ebuckstest.MakeProducts("2", 5)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.BreakProduct("3")
+
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug := httptest.NewServer(Handler(s, t.TempDir(), logging.Discard()))
+	defer debug.Close()
+
+	// pause once the category page has queued its products
+	ts.AfterRequests(2, func(*ebuckstest.Server) {
+		s.Pause()
+	})
+	done := make(chan error)
+	go func() {
+		done <- s.Start()
+	}()
+
+	// the queue hands a request or two to the (blocked) worker ahead of time, so not all products are still queued
+	var pending []scraper.QueuedRequest
+	time.Sleep(200 * time.Millisecond)
+	get(t, debug.URL+"/debug/queue", &pending)
+	if len(pending) < len(products)-2 {
+		t.Fatalf("wrong number of pending requests: got %d expected at least %d", len(pending), len(products)-2)
+	}
+	for _, r := range pending {
+		if !strings.Contains(r.URL, "productSelected.do") || !strings.Contains(r.Parent, "categorySelected.do") {
+			t.Errorf("unexpected pending request: %+v", r)
+		}
+	}
+
+	s.Resume()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []scraper.RecentError
+	get(t, debug.URL+"/debug/errors", &errs)
+	if len(errs) != 1 || !strings.Contains(errs[0].URL, "prodId=3") {
+		t.Errorf("broken product should be a recent error: %+v", errs)
+	}
+}
+
+func TestCheckpointsDefaultToTempDir(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	path, err := saveCheckpoint(scraper.Checkpoint{Time: time.Now()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != tmp {
+		t.Errorf("checkpoint should be saved in the temporary directory: got %s", path)
+	}
+}
+
+func get(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func post(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: %d %s", url, resp.StatusCode, body)
+	}
+	return string(body)
+}
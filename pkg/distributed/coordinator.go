@@ -0,0 +1,429 @@
+package distributed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+const (
+	defaultLeaseDuration = 5 * time.Minute
+	defaultMaxAttempts   = 3
+)
+
+// Config configures a Coordinator.
+type Config struct {
+	// LeaseDuration is how long a worker can go without renewing its lease before its task is given to another
+	// worker (default 5 minutes)
+	LeaseDuration time.Duration
+	// MaxAttempts is how many times a task is handed out before it is given up on (default 3)
+	MaxAttempts int
+	// Callback is called for every product the workers scrape, once per prodId and catId
+	Callback scraper.ProductPageCallbackFunc
+	// Observers are notified of the events of the whole crawl, as if it had been done by a single scraper
+	Observers []scraper.Observer
+	// RunConfig is recorded in the start event, e.g. in the manifest
+	RunConfig scraper.RunConfig
+	Logger    *slog.Logger
+}
+
+type task struct {
+	Task
+	state    TaskState
+	attempts int
+	worker   string
+	lease    string
+	expires  time.Time
+	err      string
+}
+
+// Coordinator hands tasks out to workers and merges what they send back. It is an http.Handler serving the
+// protocol described in the package doc; Run waits for the crawl to finish.
+type Coordinator struct {
+	cfg Config
+	mux *http.ServeMux
+
+	mutex    sync.Mutex
+	tasks    []*task
+	leases   map[string]*task
+	finished bool
+	done     chan struct{}
+	// outage is the first outage a worker reported, which stops the crawl
+	outage *scraper.OutageError
+
+	// replays are the finished tasks whose events are still being replayed
+	replays     sync.WaitGroup
+	replayMutex sync.Mutex
+	seen        map[string]bool
+}
+
+// NewCoordinator creates a coordinator for tasks, see DiscoverTasks.
+func NewCoordinator(tasks []Task, cfg Config) (*Coordinator, error) {
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = defaultLeaseDuration
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	c := &Coordinator{
+		cfg:    cfg,
+		mux:    http.NewServeMux(),
+		leases: map[string]*task{},
+		done:   make(chan struct{}),
+		seen:   map[string]bool{},
+	}
+	ids := map[string]bool{}
+	for _, t := range tasks {
+		if ids[t.ID] {
+			return nil, fmt.Errorf("duplicate task %q", t.ID)
+		}
+		ids[t.ID] = true
+		c.tasks = append(c.tasks, &task{Task: t, state: TaskPending})
+	}
+	if len(c.tasks) == 0 {
+		close(c.done)
+	}
+
+	c.mux.HandleFunc("/lease", post(c.handleLease))
+	c.mux.HandleFunc("/renew", post(c.handleRenew))
+	c.mux.HandleFunc("/complete", post(c.handleComplete))
+	c.mux.HandleFunc("/fail", post(c.handleFail))
+	c.mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.Status())
+	})
+	return c, nil
+}
+
+// DiscoverTasks returns a task for every category linked from the shop's home page. If the home page can't be
+// fetched the error is a *scraper.OutageError, like a scraper's would be.
+func DiscoverTasks(ctx context.Context, client *scraper.Client) ([]Task, error) {
+	categories, err := client.ListCategories(ctx)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, &scraper.OutageError{Reason: scraper.OutageHomePage, Requests: 1, Failures: 1, LastError: err.Error()}
+	}
+	tasks := []Task{}
+	for _, c := range categories {
+		tasks = append(tasks, Task{ID: "category-" + c.CatID, Kind: TaskCategory, CatID: c.CatID, Name: c.Name, URL: c.URL})
+	}
+	return tasks, nil
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
+
+// Run sends the start event and blocks until every task is done or has been given up on, then sends the finish
+// event. It fails if any task was given up on or ctx is cancelled, and with the *scraper.OutageError if a worker
+// found the site to be down. Workers are told the crawl is over once Run
+// returns, so the handler should be served for a little longer to let them find out.
+func (c *Coordinator) Run(ctx context.Context) error {
+	c.observe(scraper.Event{Kind: scraper.EventStart, Time: time.Now(), URL: c.cfg.RunConfig.BaseURL, Config: &c.cfg.RunConfig})
+
+	ticker := time.NewTicker(c.cfg.LeaseDuration / 4)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		select {
+		case <-c.done:
+			break loop
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case <-ticker.C:
+			c.mutex.Lock()
+			c.reclaim(time.Now())
+			c.mutex.Unlock()
+		}
+	}
+
+	c.mutex.Lock()
+	c.finished = true
+	failed := 0
+	for _, t := range c.tasks {
+		if t.state == TaskFailed {
+			failed++
+		}
+	}
+	c.mutex.Unlock()
+	c.replays.Wait()
+
+	switch {
+	case err != nil:
+	case c.outage != nil:
+		err = c.outage
+	case failed > 0:
+		err = fmt.Errorf("%d of %d tasks failed", failed, len(c.tasks))
+	}
+	c.observe(scraper.Event{Kind: scraper.EventFinish, Time: time.Now(), Err: err})
+	return err
+}
+
+// Status returns the state of every task.
+func (c *Coordinator) Status() Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := Status{Tasks: []TaskStatus{}}
+	for _, t := range c.tasks {
+		switch t.state {
+		case TaskPending:
+			s.Pending++
+		case TaskLeased:
+			s.Leased++
+		case TaskDone:
+			s.Done++
+		case TaskFailed:
+			s.Failed++
+		}
+		ts := TaskStatus{Task: t.Task, State: t.state, Attempts: t.attempts, Error: t.err}
+		if t.state == TaskLeased {
+			ts.Worker = t.worker
+			ts.Expires = t.expires
+		}
+		s.Tasks = append(s.Tasks, ts)
+	}
+	return s
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.finished {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	now := time.Now()
+	c.reclaim(now)
+
+	for _, t := range c.tasks {
+		if t.state != TaskPending {
+			continue
+		}
+		t.state = TaskLeased
+		t.attempts++
+		t.worker = req.Worker
+		t.lease = newLeaseID()
+		t.expires = now.Add(c.cfg.LeaseDuration)
+		c.leases[t.lease] = t
+		c.cfg.Logger.Info("leased task", "task", t.ID, "worker", t.worker, "attempt", t.attempts)
+		writeJSON(w, leaseResponse{Lease: t.lease, Task: t.Task, Expires: t.expires, Duration: c.cfg.LeaseDuration})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleRenew(w http.ResponseWriter, r *http.Request) {
+	var req renewRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	c.reclaim(now)
+	t, ok := c.leases[req.Lease]
+	if !ok {
+		http.Error(w, "lease lost", http.StatusConflict)
+		return
+	}
+	t.expires = now.Add(c.cfg.LeaseDuration)
+	writeJSON(w, renewResponse{Expires: t.expires})
+}
+
+func (c *Coordinator) handleComplete(w http.ResponseWriter, r *http.Request) {
+	var req completeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	c.mutex.Lock()
+	if c.finished {
+		// too late, the finish event has been sent
+		c.mutex.Unlock()
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	c.reclaim(time.Now())
+	t, ok := c.leases[req.Lease]
+	if !ok {
+		c.mutex.Unlock()
+		http.Error(w, "lease lost", http.StatusConflict)
+		return
+	}
+	c.release(t, TaskDone)
+	c.cfg.Logger.Info("task done", "task", t.ID, "worker", t.worker, "events", len(req.Events))
+	c.replays.Add(1)
+	c.checkDone()
+	c.mutex.Unlock()
+
+	defer c.replays.Done()
+	c.replay(req.Events)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleFail(w http.ResponseWriter, r *http.Request) {
+	var req failRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reclaim(time.Now())
+	t, ok := c.leases[req.Lease]
+	if !ok {
+		http.Error(w, "lease lost", http.StatusConflict)
+		return
+	}
+	c.cfg.Logger.Warn("task failed", "task", t.ID, "worker", t.worker, "attempt", t.attempts, logging.KeyError, req.Error)
+	t.err = req.Error
+	if req.Outage != nil {
+		c.stop(t, req.Outage)
+	} else {
+		c.retry(t)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reclaim takes back the leases that have run out; the mutex must be held
+func (c *Coordinator) reclaim(now time.Time) {
+	for _, t := range c.tasks {
+		if t.state == TaskLeased && now.After(t.expires) {
+			c.cfg.Logger.Warn("lease expired", "task", t.ID, "worker", t.worker, "attempt", t.attempts)
+			t.err = "lease expired"
+			c.retry(t)
+		}
+	}
+}
+
+// retry hands a leased task out again, unless it has had all its attempts; the mutex must be held
+func (c *Coordinator) retry(t *task) {
+	if t.attempts < c.cfg.MaxAttempts {
+		c.release(t, TaskPending)
+		return
+	}
+	c.cfg.Logger.Error("giving up on task", "task", t.ID, "attempts", t.attempts, logging.KeyError, t.err)
+	c.release(t, TaskFailed)
+	c.checkDone()
+}
+
+// stop gives up on t and ends the crawl because the site is down; the mutex must be held
+func (c *Coordinator) stop(t *task, outage *scraper.OutageError) {
+	c.release(t, TaskFailed)
+	if c.outage != nil {
+		return
+	}
+	c.cfg.Logger.Error("stopping crawl, the site seems to be down", "task", t.ID, "worker", t.worker, logging.KeyError, outage)
+	c.outage = outage
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// release ends the lease on t; the mutex must be held
+func (c *Coordinator) release(t *task, state TaskState) {
+	delete(c.leases, t.lease)
+	t.state = state
+	t.lease = ""
+}
+
+// checkDone closes done once every task is done or failed; the mutex must be held
+func (c *Coordinator) checkDone() {
+	for _, t := range c.tasks {
+		if t.state == TaskPending || t.state == TaskLeased {
+			return
+		}
+	}
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// replay sends the events of a finished task to the observers, one task at a time so that each task's events
+// stay together
+func (c *Coordinator) replay(events []wireEvent) {
+	c.replayMutex.Lock()
+	defer c.replayMutex.Unlock()
+
+	for _, we := range events {
+		e := we.event()
+		if e.Kind == scraper.EventProduct && e.Product != nil {
+			// a product can only be in one task, but make sure it isn't written twice anyway
+			key := e.Product.ProdID + "/" + e.Product.CatID
+			if c.seen[key] {
+				continue
+			}
+			c.seen[key] = true
+			if c.cfg.Callback != nil {
+				c.cfg.Callback(*e.Product)
+			}
+		}
+		c.observe(e)
+	}
+}
+
+func (c *Coordinator) observe(e scraper.Event) {
+	for _, o := range c.cfg.Observers {
+		o.Observe(e)
+	}
+}
+
+func newLeaseID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func post(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
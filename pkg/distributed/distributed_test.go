@@ -0,0 +1,292 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	dataio "github.com/geniass/ebucks-dealz/pkg/io"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestWorkersCrawlEveryCategory(t *testing.T) {
+	products := append(append(ebuckstest.MakeProducts("1", 5), ebuckstest.MakeProducts("2", 3)...), ebuckstest.MakeProducts("3", 4)...)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.SetPageSize(2)
+
+	tasks := discover(t, ts)
+	if len(tasks) != 3 {
+		t.Fatalf("wrong number of tasks: got %d expected 3", len(tasks))
+	}
+
+	manifestPath := filepath.Join(t.TempDir(), dataio.ManifestFilename)
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scraped := &collector{}
+	c, err := NewCoordinator(tasks, Config{
+		Callback:  scraped.add,
+		Observers: []scraper.Observer{scraper.NewManifestWriter(f, "test")},
+		RunConfig: scraper.RunConfig{BaseURL: ts.URL},
+		Logger:    logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := httptest.NewServer(c)
+	defer cs.Close()
+
+	workers := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := newWorker(cs.URL, ts).Run(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	workers.Wait()
+
+	if scraped.len() != len(products) {
+		t.Errorf("wrong number of products: got %d expected %d", scraped.len(), len(products))
+	}
+	m, err := dataio.LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.End == nil || m.End.Products != len(products) || m.End.Error != "" {
+		t.Errorf("manifest should end with every product: %+v", m.End)
+	}
+	for _, u := range m.URLs {
+		if u.PageType == scraper.PageHome {
+			t.Errorf("the home page fetched by every worker shouldn't be in the manifest")
+		}
+	}
+	for _, cc := range m.Completeness() {
+		if !cc.Complete() {
+			t.Errorf("category %s should be complete: %+v", cc.CatID, cc)
+		}
+	}
+	if s := c.Status(); s.Done != 3 || s.Pending != 0 || s.Leased != 0 || s.Failed != 0 {
+		t.Errorf("every task should be done: %+v", s)
+	}
+}
+
+func TestCoordinatorReassignsExpiredLeases(t *testing.T) {
+	products := append(ebuckstest.MakeProducts("1", 2), ebuckstest.MakeProducts("2", 2)...)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	scraped := &collector{}
+	c, err := NewCoordinator(discover(t, ts), Config{LeaseDuration: 200 * time.Millisecond, Callback: scraped.add, Logger: logging.Discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := httptest.NewServer(c)
+	defer cs.Close()
+
+	// a worker that leases a task and dies
+	var dead leaseResponse
+	if status := call(t, cs.URL+"/lease", leaseRequest{Worker: "dead"}, &dead); status != http.StatusOK {
+		t.Fatalf("could not lease a task: got status %d", status)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+	if err := newWorker(cs.URL, ts).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if scraped.len() != len(products) {
+		t.Errorf("wrong number of products: got %d expected %d", scraped.len(), len(products))
+	}
+	for _, ts := range c.Status().Tasks {
+		if ts.ID == dead.Task.ID && (ts.Attempts != 2 || ts.State != TaskDone) {
+			t.Errorf("task of dead worker should have been done on the second attempt: %+v", ts)
+		}
+	}
+	if status := call(t, cs.URL+"/complete", completeRequest{Lease: dead.Lease}, nil); status != http.StatusGone {
+		t.Errorf("late worker should be told the crawl is over: got status %d", status)
+	}
+}
+
+func TestCoordinatorGivesUpOnFailingTasks(t *testing.T) {
+	c, err := NewCoordinator([]Task{{ID: "a", Kind: TaskCategory, CatID: "1"}}, Config{MaxAttempts: 2, Logger: logging.Discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := httptest.NewServer(c)
+	defer cs.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+
+	for i := 0; i < 2; i++ {
+		var lease leaseResponse
+		if status := call(t, cs.URL+"/lease", leaseRequest{Worker: "w"}, &lease); status != http.StatusOK {
+			t.Fatalf("attempt %d: could not lease the task: got status %d", i+1, status)
+		}
+		if status := call(t, cs.URL+"/renew", renewRequest{Lease: lease.Lease}, nil); status != http.StatusOK {
+			t.Errorf("attempt %d: could not renew lease: got status %d", i+1, status)
+		}
+		if status := call(t, cs.URL+"/fail", failRequest{Lease: lease.Lease, Error: "broken"}, nil); status != http.StatusNoContent {
+			t.Errorf("attempt %d: could not fail the task: got status %d", i+1, status)
+		}
+		if status := call(t, cs.URL+"/renew", renewRequest{Lease: lease.Lease}, nil); status != http.StatusConflict {
+			t.Errorf("attempt %d: failed lease shouldn't be renewed: got status %d", i+1, status)
+		}
+	}
+
+	if err := <-done; err == nil {
+		t.Errorf("crawl should fail when a task is given up on")
+	}
+	if s := c.Status(); s.Failed != 1 || s.Tasks[0].Error != "broken" {
+		t.Errorf("task should have failed: %+v", s)
+	}
+	if status := call(t, cs.URL+"/lease", leaseRequest{Worker: "w"}, nil); status != http.StatusGone {
+		t.Errorf("workers should be told the crawl is over: got status %d", status)
+	}
+}
+
+func TestCoordinatorStopsOnOutage(t *testing.T) {
+	ts := ebuckstest.NewServer(append(ebuckstest.MakeProducts("1", 2), ebuckstest.MakeProducts("2", 2)...))
+	defer ts.Close()
+	tasks := discover(t, ts)
+
+	c, err := NewCoordinator(tasks, Config{Logger: logging.Discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := httptest.NewServer(c)
+	defer cs.Close()
+
+	ts.FailNext(1000, http.StatusServiceUnavailable)
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+	w := newWorker(cs.URL, ts)
+	w.Options = append(w.Options, scraper.WithMaxRetries(0))
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var outage *scraper.OutageError
+	if err := <-done; !errors.As(err, &outage) || outage.Reason != scraper.OutageHomePage {
+		t.Errorf("crawl should stop with the worker's outage: got %v", err)
+	}
+	if s := c.Status(); s.Failed != 1 || s.Pending != 1 {
+		t.Errorf("only the task that found the outage should have been tried: %+v", s)
+	}
+
+	client, err := scraper.NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetLogger(logging.Discard())
+	if _, err := DiscoverTasks(context.Background(), client); !errors.As(err, &outage) {
+		t.Errorf("discovering tasks of a site that is down should be an outage: got %v", err)
+	}
+}
+
+func TestWireEventKeepsErrorPages(t *testing.T) {
+	e := toWire(scraper.Event{Kind: scraper.EventError, Err: &scraper.ScrapeError{URL: "u", Err: scraper.ErrRedirectToErrorPage}})
+	var decoded wireEvent
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got := decoded.event()
+	if got.Err == nil || got.Err.Error() != e.Err {
+		t.Fatalf("error message should survive: got %v", got.Err)
+	}
+	if !errors.Is(got.Err, scraper.ErrRedirectToErrorPage) {
+		t.Errorf("error page errors should still be recognised")
+	}
+}
+
+type collector struct {
+	mutex    sync.Mutex
+	products []scraper.Product
+}
+
+func (c *collector) add(p scraper.Product) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.products = append(c.products, p)
+}
+
+func (c *collector) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.products)
+}
+
+func discover(t *testing.T, ts *ebuckstest.Server) []Task {
+	t.Helper()
+	client, err := scraper.NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetLogger(logging.Discard())
+	tasks, err := DiscoverTasks(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tasks
+}
+
+func newWorker(coordinatorURL string, ts *ebuckstest.Server) *Worker {
+	return &Worker{
+		CoordinatorURL: coordinatorURL,
+		Options:        []scraper.Option{scraper.WithBaseURL(ts.URL), scraper.WithLogger(logging.Discard())},
+		PollInterval:   50 * time.Millisecond,
+		Logger:         logging.Discard(),
+	}
+}
+
+func call(t *testing.T, url string, req interface{}, resp interface{}) int {
+	t.Helper()
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if resp != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
@@ -0,0 +1,186 @@
+// Package distributed spreads a crawl over several worker processes.
+//
+// A Coordinator splits the shop into tasks, one per category, and hands them out over HTTP as leases. Workers
+// crawl each task they lease with a normal Scraper restricted to the task's category, renewing the lease while
+// they work, and send back every event of the crawl when they're done. The coordinator replays the events of
+// each finished task into its own observers and callback, so a manifest written there describes the whole crawl
+// as if it had been done by a single scraper. A task whose lease runs out (because its worker died or lost
+// touch) is handed to another worker; events from a worker that lost its lease are thrown away.
+//
+// The protocol is JSON over HTTP POST:
+//
+//	/lease    {"worker"}           -> 200 {"lease", "task", "expires", "duration"}, 204 if nothing is free
+//	                                  right now, or 410 once the crawl is over
+//	/renew    {"lease"}            -> 200 {"expires"}, or 409 if the lease has been lost
+//	/complete {"lease", "events"}  -> 204, or 409 if the lease has been lost
+//	/fail     {"lease", "error",   -> 204, or 409 if the lease has been lost
+//	           "outage"}
+//
+// and GET /status returns the state of every task. A failure that comes with an outage (a scraper.OutageError)
+// means the site is down, so rather than handing the task out again the coordinator stops the whole crawl.
+package distributed
+
+import (
+	"errors"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// TaskKind is how a task's share of the shop is described.
+type TaskKind string
+
+const (
+	// TaskCategory is every page and product of the category CatID.
+	TaskCategory TaskKind = "category"
+)
+
+// Task is a share of the crawl handed to one worker at a time.
+type Task struct {
+	ID    string   `json:"id"`
+	Kind  TaskKind `json:"kind"`
+	CatID string   `json:"catId,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	URL   string   `json:"url,omitempty"`
+}
+
+// TaskState is where a task is in its life.
+type TaskState string
+
+const (
+	TaskPending TaskState = "pending"
+	TaskLeased  TaskState = "leased"
+	TaskDone    TaskState = "done"
+	// TaskFailed tasks failed on every attempt and were given up on
+	TaskFailed TaskState = "failed"
+)
+
+// TaskStatus is a task as shown by /status.
+type TaskStatus struct {
+	Task
+	State    TaskState `json:"state"`
+	Attempts int       `json:"attempts"`
+	Worker   string    `json:"worker,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Status is the response of /status.
+type Status struct {
+	Pending int          `json:"pending"`
+	Leased  int          `json:"leased"`
+	Done    int          `json:"done"`
+	Failed  int          `json:"failed"`
+	Tasks   []TaskStatus `json:"tasks"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+type leaseResponse struct {
+	Lease   string    `json:"lease"`
+	Task    Task      `json:"task"`
+	Expires time.Time `json:"expires"`
+	// Duration is how long each renewal extends the lease by
+	Duration time.Duration `json:"duration"`
+}
+
+type renewRequest struct {
+	Lease string `json:"lease"`
+}
+
+type renewResponse struct {
+	Expires time.Time `json:"expires"`
+}
+
+type completeRequest struct {
+	Lease  string      `json:"lease"`
+	Events []wireEvent `json:"events"`
+}
+
+type failRequest struct {
+	Lease string `json:"lease"`
+	Error string `json:"error"`
+	// Outage is set if the task failed because the site seems to be down
+	Outage *scraper.OutageError `json:"outage,omitempty"`
+}
+
+// wireEvent is a scraper.Event that can be sent as JSON
+type wireEvent struct {
+	Kind       scraper.EventKind     `json:"kind"`
+	Time       time.Time             `json:"time"`
+	URL        string                `json:"url,omitempty"`
+	Parent     string                `json:"parent,omitempty"`
+	PageType   scraper.PageType      `json:"pageType,omitempty"`
+	Attempt    int                   `json:"attempt,omitempty"`
+	StatusCode int                   `json:"status,omitempty"`
+	Bytes      int                   `json:"bytes,omitempty"`
+	Duration   time.Duration         `json:"duration,omitempty"`
+	Err        string                `json:"error,omitempty"`
+	ErrorPage  bool                  `json:"errorPage,omitempty"`
+	Retrying   bool                  `json:"retrying,omitempty"`
+	Product    *scraper.Product      `json:"product,omitempty"`
+	Category   *scraper.CategoryPage `json:"category,omitempty"`
+}
+
+func toWire(e scraper.Event) wireEvent {
+	w := wireEvent{
+		Kind:       e.Kind,
+		Time:       e.Time,
+		URL:        e.URL,
+		Parent:     e.Parent,
+		PageType:   e.PageType,
+		Attempt:    e.Attempt,
+		StatusCode: e.StatusCode,
+		Bytes:      e.Bytes,
+		Duration:   e.Duration,
+		Retrying:   e.Retrying,
+		Product:    e.Product,
+		Category:   e.Category,
+	}
+	if e.Err != nil {
+		w.Err = e.Err.Error()
+		// the manifest tells these apart from other errors
+		w.ErrorPage = errors.Is(e.Err, scraper.ErrRedirectToErrorPage)
+	}
+	return w
+}
+
+func (w wireEvent) event() scraper.Event {
+	e := scraper.Event{
+		Kind:       w.Kind,
+		Time:       w.Time,
+		URL:        w.URL,
+		Parent:     w.Parent,
+		PageType:   w.PageType,
+		Attempt:    w.Attempt,
+		StatusCode: w.StatusCode,
+		Bytes:      w.Bytes,
+		Duration:   w.Duration,
+		Retrying:   w.Retrying,
+		Product:    w.Product,
+		Category:   w.Category,
+	}
+	if w.Err != "" {
+		e.Err = &remoteError{msg: w.Err}
+		if w.ErrorPage {
+			e.Err = &remoteError{msg: w.Err, wrapped: scraper.ErrRedirectToErrorPage}
+		}
+	}
+	return e
+}
+
+// remoteError is an error that happened on a worker
+type remoteError struct {
+	msg     string
+	wrapped error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.wrapped
+}
@@ -0,0 +1,262 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	// maxConsecutiveErrors is how many times in a row the coordinator can fail to answer before a worker gives up
+	maxConsecutiveErrors = 5
+	// handBackTimeout is how long a worker that is stopping waits for the coordinator to take its task back
+	handBackTimeout = 10 * time.Second
+)
+
+var (
+	errCrawlOver = errors.New("crawl is over")
+	errLeaseLost = errors.New("lease lost")
+)
+
+// Worker leases tasks from a coordinator and crawls them.
+type Worker struct {
+	// CoordinatorURL is where the coordinator's handler is served
+	CoordinatorURL string
+	// Name identifies the worker in the coordinator's logs and status (default host-pid)
+	Name string
+	// Options configure the scraper each task is crawled with. It is restricted to the task's category and
+	// observed by the worker on top of them.
+	Options    []scraper.Option
+	HTTPClient *http.Client
+	// PollInterval is how long to wait before asking again when there is no task to lease (default 5 seconds)
+	PollInterval time.Duration
+	Logger       *slog.Logger
+}
+
+// Run crawls tasks until the coordinator says the crawl is over, ctx is cancelled, or the coordinator can't be
+// reached. Tasks that fail are handed back to the coordinator to try again.
+func (w *Worker) Run(ctx context.Context) error {
+	w.defaults()
+
+	failures := 0
+	for {
+		var lease leaseResponse
+		status, err := w.call(ctx, "/lease", leaseRequest{Worker: w.Name}, &lease)
+		switch {
+		case errors.Is(err, errCrawlOver):
+			w.Logger.Info("crawl is over")
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			failures++
+			if failures >= maxConsecutiveErrors {
+				return fmt.Errorf("could not lease a task: %w", err)
+			}
+			w.Logger.Warn("could not lease a task", logging.KeyError, err)
+		case status == http.StatusNoContent:
+			failures = 0
+		default:
+			failures = 0
+			w.work(ctx, lease)
+			continue
+		}
+
+		select {
+		case <-time.After(w.PollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *Worker) defaults() {
+	if w.Name == "" {
+		host, _ := os.Hostname()
+		w.Name = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if w.HTTPClient == nil {
+		w.HTTPClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+	if w.PollInterval <= 0 {
+		w.PollInterval = defaultPollInterval
+	}
+	if w.Logger == nil {
+		w.Logger = slog.Default()
+	}
+}
+
+// work crawls the task of a lease, renewing the lease until it's done, and reports back
+func (w *Worker) work(ctx context.Context, lease leaseResponse) {
+	logger := w.Logger.With("task", lease.Task.ID)
+	logger.Info("crawling task")
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := make(chan struct{})
+	renewing := make(chan struct{})
+	go func() {
+		defer close(renewing)
+		if w.renew(taskCtx, lease, logger) {
+			close(lost)
+			cancel()
+		}
+	}()
+
+	start := time.Now()
+	events, err := w.crawl(taskCtx, lease.Task)
+	cancel()
+	<-renewing
+
+	select {
+	case <-lost:
+		logger.Warn("lease lost, dropping the task's results")
+		return
+	default:
+	}
+
+	if ctx.Err() != nil {
+		// hand the task back so that it doesn't have to wait for the lease to run out
+		err = ctx.Err()
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(context.Background(), handBackTimeout)
+		defer stop()
+	}
+	if err != nil {
+		logger.Warn("task failed", logging.KeyError, err)
+		req := failRequest{Lease: lease.Lease, Error: err.Error()}
+		errors.As(err, &req.Outage)
+		if _, err := w.call(ctx, "/fail", req, nil); err != nil {
+			logger.Warn("could not report failed task", logging.KeyError, err)
+		}
+		return
+	}
+	if _, err := w.call(ctx, "/complete", completeRequest{Lease: lease.Lease, Events: events}, nil); err != nil {
+		logger.Warn("could not report finished task", logging.KeyError, err)
+		return
+	}
+	logger.Info("task done", "events", len(events), "duration", time.Since(start))
+}
+
+// renew keeps the lease going until ctx is cancelled, and says whether it was lost
+func (w *Worker) renew(ctx context.Context, lease leaseResponse, logger *slog.Logger) bool {
+	ticker := time.NewTicker(lease.Duration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+		_, err := w.call(ctx, "/renew", renewRequest{Lease: lease.Lease}, nil)
+		switch {
+		case errors.Is(err, errLeaseLost) || errors.Is(err, errCrawlOver):
+			return true
+		case err != nil && ctx.Err() == nil:
+			// it might come back before the lease runs out
+			logger.Warn("could not renew lease", logging.KeyError, err)
+		}
+	}
+}
+
+// crawl runs a scraper over the task and returns its events
+func (w *Worker) crawl(ctx context.Context, t Task) ([]wireEvent, error) {
+	if t.Kind != TaskCategory {
+		return nil, fmt.Errorf("unknown kind of task %q", t.Kind)
+	}
+
+	rec := &recorder{}
+	opts := append(append([]scraper.Option{}, w.Options...), scraper.WithCategories(t.CatID), scraper.WithObserver(rec))
+	s, err := scraper.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var crawlErr error
+	products, errs := s.Stream(ctx)
+	for products != nil || errs != nil {
+		select {
+		case _, ok := <-products:
+			if !ok {
+				products = nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			var scrapeErr *scraper.ScrapeError
+			if !errors.As(err, &scrapeErr) {
+				crawlErr = err
+			}
+		}
+	}
+	return rec.events, crawlErr
+}
+
+// call POSTs req to the coordinator and decodes its answer into resp, if there is one
+func (w *Worker) call(ctx context.Context, path string, req interface{}, resp interface{}) (int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(w.CoordinatorURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := w.HTTPClient.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if resp == nil {
+			return res.StatusCode, nil
+		}
+		return res.StatusCode, json.NewDecoder(res.Body).Decode(resp)
+	case http.StatusNoContent:
+		return res.StatusCode, nil
+	case http.StatusGone:
+		return res.StatusCode, errCrawlOver
+	case http.StatusConflict:
+		return res.StatusCode, errLeaseLost
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return res.StatusCode, fmt.Errorf("%s: got status %d: %s", path, res.StatusCode, bytes.TrimSpace(msg))
+}
+
+// recorder keeps the events of a task's crawl that belong in the coordinator's. The start and finish are the
+// coordinator's own, every task fetches the home page, and the queue is the worker's business.
+type recorder struct {
+	mutex  sync.Mutex
+	events []wireEvent
+}
+
+func (r *recorder) Observe(e scraper.Event) {
+	switch {
+	case e.Kind == scraper.EventStart || e.Kind == scraper.EventFinish || e.Kind == scraper.EventQueued:
+		return
+	case e.PageType == scraper.PageHome:
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, toWire(e))
+}
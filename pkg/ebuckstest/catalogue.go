@@ -0,0 +1,115 @@
+// Package ebuckstest provides a fake eBucks shop server for tests and offline development.
+//
+// The server serves the same pages the scraper relies on (shopHome.do, categorySelected.do,
+// productSelected.do and the productSelectedDiscount.do fragment) from an in-memory catalogue,
+// with sessions and member logins, and can be told to misbehave like the real site: redirecting to
+// globalExceptionPage.jsp, failing with bursts of 5xx responses, responding slowly, timing out sessions,
+// or changing the catalogue mid-crawl.
+package ebuckstest
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// Levels is the discount percentage of each eBucks level, from level 1 to level 5.
+var Levels = []int{10, 15, 20, 30, 40}
+
+// Product is a product in the fake shop.
+type Product struct {
+	ProdID string
+	CatID  string
+	Name   string
+	// Price is the normal price in Rands
+	Price float64
+	// Savings is shown on the product page as the "was price" savings (0 to hide it)
+	Savings float64
+	// Discounts lists the percentage off at each level; empty means the product is not discounted
+	Discounts []int
+}
+
+// Category is a category of products in the fake shop.
+type Category struct {
+	CatID string
+	Name  string
+}
+
+// Tier is a single row of the discount table, in the same units as the real site.
+type Tier struct {
+	Level         int
+	Percent       int
+	EbucksPrice   int
+	EbucksSavings int
+}
+
+// Tiers returns the discount table the server will render for p.
+func (p Product) Tiers() []Tier {
+	ts := []Tier{}
+	for i, percent := range p.Discounts {
+		price := randsToEbucks(p.Price)
+		savings := price * percent / 100
+		ts = append(ts, Tier{
+			Level:         i + 1,
+			Percent:       percent,
+			EbucksPrice:   price - savings,
+			EbucksSavings: savings,
+		})
+	}
+	return ts
+}
+
+// Path returns the shop-relative URL of the product page.
+func (p Product) Path() string {
+	return fmt.Sprintf("/web/shop/productSelected.do?prodId=%s&catId=%s", p.ProdID, p.CatID)
+}
+
+// MakeProducts returns n undiscounted products in category catId, with product IDs 0 to n-1.
+func MakeProducts(catId string, n int) []Product {
+	ps := []Product{}
+	for i := 0; i < n; i++ {
+		prodId := strconv.Itoa(i)
+		ps = append(ps, Product{
+			ProdID: prodId,
+			CatID:  catId,
+			Name:   "Product " + prodId,
+			Price:  float64(i * 1000),
+		})
+	}
+	return ps
+}
+
+// RandomCatalogue returns a deterministic catalogue of n products spread over numCategories categories (at least
+// one), of which roughly one in five are discounted.
+func RandomCatalogue(seed int64, numCategories int, n int) ([]Category, []Product) {
+	r := rand.New(rand.NewSource(seed))
+	if numCategories < 1 {
+		numCategories = 1
+	}
+
+	cs := []Category{}
+	for i := 0; i < numCategories; i++ {
+		id := strconv.Itoa(1000 + i)
+		cs = append(cs, Category{CatID: id, Name: "Category " + id})
+	}
+
+	ps := []Product{}
+	for i := 0; i < n; i++ {
+		prodId := strconv.Itoa(100000 + i)
+		p := Product{
+			ProdID: prodId,
+			CatID:  cs[r.Intn(len(cs))].CatID,
+			Name:   "Product " + prodId,
+			Price:  float64(r.Intn(2000000)) / 100,
+		}
+		if r.Intn(5) == 0 {
+			p.Discounts = Levels
+		}
+		ps = append(ps, p)
+	}
+	return cs, ps
+}
+
+func randsToEbucks(r float64) int {
+	return int(r*10 + 0.5)
+}
@@ -0,0 +1,309 @@
+package ebuckstest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	links := []string{}
+	for _, id := range s.sortedCategoryIDs() {
+		links = append(links, fmt.Sprintf(
+			`<a href="%s?catId=%s">%s</a>`,
+			CategoryPath, id, html.EscapeString(s.categoryName(id)),
+		))
+	}
+	s.mutex.Unlock()
+
+	s.writePage(w, r, strings.Join(links, "\n"))
+}
+
+func (s *Server) handleCategory(w http.ResponseWriter, r *http.Request) {
+	catId := r.URL.Query().Get("catId")
+	if isMissing(catId) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	_, known := s.categories[catId]
+	products, ok := s.products[catId]
+	if !ok && !known {
+		s.mutex.Unlock()
+		redirectToErrorPage(w, r)
+		return
+	}
+	ps := []Product{}
+	for _, p := range products {
+		ps = append(ps, p)
+	}
+	name := s.categoryName(catId)
+	pageSize := s.pageSize
+	s.mutex.Unlock()
+	total := len(ps)
+
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ProdID < ps[j].ProdID })
+	if r.URL.Query().Get("sortBy") == "price" {
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].Price < ps[j].Price })
+	}
+
+	// pages are numbered from 1, and page 1 is also served without a page parameter
+	page, pages := 1, 1
+	if pageSize > 0 && len(ps) > pageSize {
+		pages = (len(ps) + pageSize - 1) / pageSize
+		if p := r.URL.Query().Get("page"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 1 || n > pages {
+				redirectToErrorPage(w, r)
+				return
+			}
+			page = n
+		}
+		start := (page - 1) * pageSize
+		end := start + pageSize
+		if end > len(ps) {
+			end = len(ps)
+		}
+		ps = ps[start:end]
+	}
+
+	tiles := []string{}
+	for _, p := range ps {
+		tiles = append(tiles, fmt.Sprintf(`<li class="product-tile" data-prodid="%[1]s">
+				<a href="%[2]s"><img src="/images/products/%[1]s.jpg" alt="%[3]s"></a>
+				<a class="product-name" href="%[2]s">%[3]s</a>
+				<span class="product-price randValue">%[4]s</span>
+			</li>`,
+			html.EscapeString(p.ProdID), html.EscapeString(p.Path()), html.EscapeString(p.Name), formatRands(p.Price),
+		))
+	}
+
+	s.writePage(w, r, fmt.Sprintf(`<h1>%s</h1>
+		<p class="product-count">%d products</p>
+		<div class="sort-by"><a href="%s?catId=%s&sortBy=price">Price</a></div>
+		<ul class="product-list">
+			%s
+		</ul>
+		%s`,
+		html.EscapeString(name), total, CategoryPath, html.EscapeString(catId), strings.Join(tiles, "\n"), pagination(catId, page, pages),
+	))
+}
+
+// pagination renders the page links of a category, like the real site: numbered pages plus next and previous
+func pagination(catId string, page int, pages int) string {
+	if pages <= 1 {
+		return ""
+	}
+	link := func(n int, class string, text string) string {
+		return fmt.Sprintf(`<li><a class="%s" href="%s?catId=%s&page=%d">%s</a></li>`, class, CategoryPath, html.EscapeString(catId), n, text)
+	}
+
+	items := []string{}
+	if page > 1 {
+		items = append(items, link(page-1, "prev", "Previous"))
+	}
+	for n := 1; n <= pages; n++ {
+		class := "page"
+		if n == page {
+			class = "page current"
+		}
+		items = append(items, link(n, class, strconv.Itoa(n)))
+	}
+	if page < pages {
+		items = append(items, link(page+1, "next", "Next"))
+	}
+	return `<ul class="pagination">` + strings.Join(items, "\n") + `</ul>`
+}
+
+func (s *Server) handleProduct(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupProduct(w, r)
+	if !ok {
+		return
+	}
+
+	savings := ""
+	if p.Savings > 0 {
+		savings = formatRands(p.Savings)
+	}
+
+	s.writePage(w, r, fmt.Sprintf(`
+		<form name="productOptionsBean" method="post" action="/web/shop/productOptionSelected.do">
+			<div class="product-detail-frame">
+				<div class="info-container-frame">
+					<h2 id="product-name" class="product-name " data-maincat="%[4]s"
+						data-currentcat="%[4]s">%[1]s</h2>
+					<div class="product-price holiday">
+						<p class="was-price">Save: <strong><span class="randValue">%[5]s</span></strong></p>
+						<p>Pay in Rands: <strong><span id="randPrice" class="randValue">%[2]s</span></strong>
+						</p>
+						<p>Pay in eBucks: <strong><span id="eBPrice" class="eBucksValue">%[6]s</span></strong>
+						</p>
+					</div>
+				</div>
+			</div> <input type="hidden" name="prodId" value="%[3]s"> <input type="hidden" name="catId"
+				   value="%[4]s"> <input type="hidden" id="prodName" value="%[1]s" />
+			<input type="hidden" id="catName" value="%[7]s" />
+		</form>`,
+		html.EscapeString(p.Name),
+		formatRands(p.Price),
+		p.ProdID,
+		p.CatID,
+		savings,
+		formatEbucks(randsToEbucks(p.Price)),
+		html.EscapeString(s.categoryName(p.CatID)),
+	))
+}
+
+// handleDiscount serves the HTML fragment the real site loads into the product page.
+// Undiscounted products get a fragment without a table.
+func (s *Server) handleDiscount(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookupProduct(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	tiers := p.Tiers()
+	if len(tiers) == 0 {
+		w.Write([]byte(`<div class="no-discount"></div>`))
+		return
+	}
+
+	rows := []string{}
+	for _, t := range tiers {
+		rows = append(rows, fmt.Sprintf(`
+			<div class="discount-level level-%d">
+				<table>
+					<tbody>
+						<tr>
+							<td class="col1"><p class="percentage">%d%%</p></td>
+							<td class="col2"><span class="eBucksValue">%s</span></td>
+							<td class="col3"><span class="randValue">%s</span></td>
+							<td class="col4"><span class="eBucksValue">%s</span></td>
+						</tr>
+					</tbody>
+				</table>
+			</div>`,
+			t.Level,
+			t.Percent,
+			formatEbucks(t.EbucksPrice),
+			formatRands(float64(t.EbucksPrice)/10),
+			formatEbucks(t.EbucksSavings),
+		))
+	}
+	fmt.Fprintf(w, `<table id="discount-table"><tbody><tr><td>%s</td></tr></tbody></table>`, strings.Join(rows, ""))
+}
+
+func (s *Server) handleErrorPage(w http.ResponseWriter, r *http.Request) {
+	s.writePage(w, r, `<h1>Oops! Something went wrong</h1>`)
+}
+
+// handleLogin shows the login form, or logs the session in as the member whose username and password were posted.
+// Members are sent back to the home page; wrong details get the form again.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	const form = `<form name="loginForm" method="post" action="%s">
+			%s
+			<input type="text" name="username" /> <input type="password" name="password" />
+		</form>`
+	if r.Method != http.MethodPost {
+		s.writePage(w, r, fmt.Sprintf(form, LoginPath, ""))
+		return
+	}
+
+	s.mutex.Lock()
+	c, err := r.Cookie(SessionCookie)
+	live := false
+	if err == nil {
+		_, live = s.sessions[c.Value]
+	}
+	m, ok := s.members[r.PostFormValue("username")]
+	ok = ok && live && m.password == r.PostFormValue("password")
+	if ok {
+		s.sessions[c.Value] = m.level
+		s.logins++
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		s.writePage(w, r, fmt.Sprintf(form, LoginPath, `<p class="login-error">Incorrect username or password</p>`))
+		return
+	}
+	http.Redirect(w, r, HomePath, http.StatusFound)
+}
+
+// memberLevel returns the level of the member logged in to r's session, 0 if nobody is
+func (s *Server) memberLevel(r *http.Request) int {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[c.Value]
+}
+
+// lookupProduct writes an error response and returns false if the request doesn't refer to a product in the catalogue.
+func (s *Server) lookupProduct(w http.ResponseWriter, r *http.Request) (Product, bool) {
+	q := r.URL.Query()
+	catId := q.Get("catId")
+	prodId := q.Get("prodId")
+	if isMissing(catId, prodId) {
+		w.WriteHeader(http.StatusBadRequest)
+		return Product{}, false
+	}
+
+	s.mutex.Lock()
+	p, ok := s.products[catId][prodId]
+	s.mutex.Unlock()
+	if !ok {
+		redirectToErrorPage(w, r)
+		return Product{}, false
+	}
+	return p, true
+}
+
+// writePage wraps body in the site's header, which shows who is logged in
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, body string) {
+	member := fmt.Sprintf(`<a href="%s" class="login">Log in</a>`, LoginPath)
+	if level := s.memberLevel(r); level > 0 {
+		member = fmt.Sprintf(`<span class="member-level" data-level="%d">Level %d member</span>`, level, level)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+	<body>
+		<a href="%s" class="active header-top-shop">SHOP</a>
+		%s
+		%s
+	</body>
+</html>
+`, HomePath, member, body)
+}
+
+// formatRands formats like the real site, e.g. "R12 345.00".
+func formatRands(r float64) string {
+	s := strconv.FormatFloat(r, 'f', 2, 64)
+	return "R" + groupThousands(s[:len(s)-3]) + s[len(s)-3:]
+}
+
+// formatEbucks formats like the real site, e.g. "eB123 450".
+func formatEbucks(eb int) string {
+	return "eB" + groupThousands(strconv.Itoa(eb))
+}
+
+func groupThousands(digits string) string {
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package ebuckstest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Proxy is a fake HTTP forward proxy. All configuration methods are safe to call while requests are being served.
+type Proxy struct {
+	*httptest.Server
+
+	mutex  sync.Mutex
+	hits   int
+	status int
+}
+
+// NewProxy starts a proxy that forwards every request it is sent.
+func NewProxy() *Proxy {
+	p := &Proxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.handle))
+	return p
+}
+
+// Fail makes the proxy answer every request with status instead of forwarding it, e.g. 429 to act throttled.
+// 0 makes it work again.
+func (p *Proxy) Fail(status int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.status = status
+}
+
+// Hits returns the number of requests the proxy has been sent.
+func (p *Proxy) Hits() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.hits
+}
+
+func (p *Proxy) handle(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	p.hits++
+	status := p.status
+	p.mutex.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	resp, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
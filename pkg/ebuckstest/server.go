@@ -0,0 +1,408 @@
+package ebuckstest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HomePath      = "/web/shop/shopHome.do"
+	CategoryPath  = "/web/shop/categorySelected.do"
+	ProductPath   = "/web/shop/productSelected.do"
+	DiscountPath  = "/web/shop/productSelectedDiscount.do"
+	ErrorPagePath = "/web/eBucks/errors/globalExceptionPage.jsp"
+	LoginPath     = "/web/eBucks/login.do"
+
+	SessionCookie = "JSESSIONID"
+)
+
+// Server is a fake eBucks shop. All configuration methods are safe to call while requests are being served.
+type Server struct {
+	*httptest.Server
+
+	mutex      sync.Mutex
+	categories map[string]Category
+	products   map[string]map[string]Product
+	pageSize   int
+
+	minLatency time.Duration
+	maxLatency time.Duration
+	rand       *rand.Rand
+
+	failNext     int
+	failStatus   int
+	flakiness    float64
+	broken       []func(r *http.Request) bool
+	afterHooks   []afterHook
+	requestCount int
+	hits         map[string]int
+
+	// sessions maps live session IDs to the level of the member logged in to them (0 for nobody);
+	// the home page starts a new one for requests without one
+	sessions       map[string]int
+	sessionCount   int
+	requireSession bool
+	members        map[string]member
+	logins         int
+	// productSessions maps each product to the session its page was last requested on, to check that its
+	// discount fragment is requested on the same one
+	productSessions   map[string]string
+	sessionMismatches int
+}
+
+type member struct {
+	password string
+	level    int
+}
+
+type afterHook struct {
+	n int
+	f func(s *Server)
+}
+
+// NewServer starts a fake shop serving ps. Categories are created as needed from the products' CatIDs.
+func NewServer(ps []Product) *Server {
+	s := NewUnstartedServer(ps)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer is like NewServer but doesn't start the server, so it can be configured first.
+func NewUnstartedServer(ps []Product) *Server {
+	s := &Server{
+		categories: make(map[string]Category),
+		products:   make(map[string]map[string]Product),
+		rand:       rand.New(rand.NewSource(1)),
+		hits:       make(map[string]int),
+		sessions:   make(map[string]int),
+		members:    make(map[string]member),
+
+		productSessions: make(map[string]string),
+	}
+	s.SetProducts(ps)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HomePath, s.handleHome)
+	mux.HandleFunc(CategoryPath, s.handleCategory)
+	mux.HandleFunc(ProductPath, s.handleProduct)
+	mux.HandleFunc(DiscountPath, s.handleDiscount)
+	mux.HandleFunc(ErrorPagePath, s.handleErrorPage)
+	mux.HandleFunc(LoginPath, s.handleLogin)
+
+	s.Server = httptest.NewUnstartedServer(s.middleware(mux))
+	return s
+}
+
+// StartURL is the URL of the shop home page, where a crawl should start.
+func (s *Server) StartURL() string {
+	return s.URL + HomePath
+}
+
+// SetCategories sets the display names of categories. Categories without products are still listed on the home page.
+func (s *Server) SetCategories(cs []Category) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range cs {
+		s.categories[c.CatID] = c
+	}
+}
+
+// SetProducts replaces the whole catalogue.
+func (s *Server) SetProducts(ps []Product) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.products = make(map[string]map[string]Product)
+	for _, p := range ps {
+		s.putProduct(p)
+	}
+}
+
+// PutProduct adds p, or replaces the product with the same CatID and ProdID.
+func (s *Server) PutProduct(p Product) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.putProduct(p)
+}
+
+// RemoveProduct removes a product; its pages will redirect to the error page like the real site does.
+func (s *Server) RemoveProduct(catId string, prodId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.products[catId], prodId)
+}
+
+// Products returns the current catalogue, sorted by CatID and ProdID.
+func (s *Server) Products() []Product {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ps := []Product{}
+	for _, cat := range s.products {
+		for _, p := range cat {
+			ps = append(ps, p)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].CatID != ps[j].CatID {
+			return ps[i].CatID < ps[j].CatID
+		}
+		return ps[i].ProdID < ps[j].ProdID
+	})
+	return ps
+}
+
+// SetPageSize splits category pages into pages of n products, linked with a pagination list.
+// 0 (the default) lists every product on a single page.
+func (s *Server) SetPageSize(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pageSize = n
+}
+
+// SetLatency delays every response by a random duration between min and max.
+func (s *Server) SetLatency(min time.Duration, max time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.minLatency = min
+	s.maxLatency = max
+}
+
+// FailNext makes the next n requests fail with status (e.g. a burst of 503s).
+func (s *Server) FailNext(n int, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failNext = n
+	s.failStatus = status
+}
+
+// SetFlakiness makes a random fraction (0 to 1) of requests fail with a 500.
+func (s *Server) SetFlakiness(fraction float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flakiness = fraction
+}
+
+// Break makes every request matching match redirect to the global error page.
+func (s *Server) Break(match func(r *http.Request) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.broken = append(s.broken, match)
+}
+
+// BreakProduct makes the product page and discount fragment of prodId redirect to the global error page.
+func (s *Server) BreakProduct(prodId string) {
+	s.Break(func(r *http.Request) bool {
+		return r.URL.Query().Get("prodId") == prodId
+	})
+}
+
+// AfterRequests calls f once, after the n-th request has been received. Use it to change the catalogue mid-crawl.
+func (s *Server) AfterRequests(n int, f func(s *Server)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.afterHooks = append(s.afterHooks, afterHook{n: n, f: f})
+}
+
+// RequireSession makes every page but the home page redirect to the home page, like the real site does
+// when a session times out, unless the request has the cookie of a live session.
+func (s *Server) RequireSession() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requireSession = true
+}
+
+// ExpireSessions ends every session, as if they had all timed out.
+func (s *Server) ExpireSessions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions = make(map[string]int)
+}
+
+// AddMember creates an account that can log in at LoginPath. Pages show the level of the member logged in to
+// the session, or a login link if nobody is.
+func (s *Server) AddMember(username string, password string, level int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.members[username] = member{password: password, level: level}
+}
+
+// LogEveryoneOut logs every member out while keeping their sessions.
+func (s *Server) LogEveryoneOut() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id := range s.sessions {
+		s.sessions[id] = 0
+	}
+}
+
+// Logins returns the number of successful logins.
+func (s *Server) Logins() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.logins
+}
+
+// Sessions returns the number of sessions started.
+func (s *Server) Sessions() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessionCount
+}
+
+// SessionMismatches returns the number of discount fragments requested on a different session than the one
+// their product page was requested on.
+func (s *Server) SessionMismatches() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessionMismatches
+}
+
+// Hits returns the number of requests received for path (e.g. ProductPath).
+func (s *Server) Hits(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hits[path]
+}
+
+// RequestCount returns the total number of requests received.
+func (s *Server) RequestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requestCount
+}
+
+func (s *Server) putProduct(p Product) {
+	if s.products[p.CatID] == nil {
+		s.products[p.CatID] = make(map[string]Product)
+	}
+	s.products[p.CatID][p.ProdID] = p
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requestCount++
+		s.hits[r.URL.Path]++
+		hooks := []func(s *Server){}
+		remaining := s.afterHooks[:0]
+		for _, h := range s.afterHooks {
+			if s.requestCount >= h.n {
+				hooks = append(hooks, h.f)
+			} else {
+				remaining = append(remaining, h)
+			}
+		}
+		s.afterHooks = remaining
+
+		latency := s.minLatency
+		if s.maxLatency > s.minLatency {
+			latency += time.Duration(s.rand.Int63n(int64(s.maxLatency - s.minLatency)))
+		}
+
+		status := 0
+		if s.failNext > 0 {
+			s.failNext--
+			status = s.failStatus
+		} else if s.flakiness > 0 && s.rand.Float64() < s.flakiness {
+			status = http.StatusInternalServerError
+		}
+
+		live := false
+		sessionID := ""
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			sessionID = c.Value
+			_, live = s.sessions[c.Value]
+		}
+		product := r.URL.Query().Get("prodId") + "/" + r.URL.Query().Get("catId")
+		switch r.URL.Path {
+		case ProductPath:
+			s.productSessions[product] = sessionID
+		case DiscountPath:
+			if id, ok := s.productSessions[product]; ok && id != sessionID {
+				s.sessionMismatches++
+			}
+		}
+		newSession := ""
+		if !live && r.URL.Path == HomePath {
+			s.sessionCount++
+			newSession = fmt.Sprintf("%016X", s.sessionCount)
+			s.sessions[newSession] = 0
+		}
+		expired := s.requireSession && !live && r.URL.Path != HomePath && r.URL.Path != ErrorPagePath
+
+		broken := false
+		if r.URL.Path != ErrorPagePath {
+			for _, match := range s.broken {
+				if match(r) {
+					broken = true
+					break
+				}
+			}
+		}
+		s.mutex.Unlock()
+
+		for _, f := range hooks {
+			f(s)
+		}
+
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+
+		if newSession != "" {
+			http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: newSession, Path: "/web", HttpOnly: true})
+		}
+
+		switch {
+		case status != 0:
+			http.Error(w, http.StatusText(status), status)
+		case expired:
+			http.Redirect(w, r, HomePath, http.StatusFound)
+		case broken:
+			redirectToErrorPage(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func redirectToErrorPage(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, ErrorPagePath, http.StatusFound)
+}
+
+func (s *Server) categoryName(catId string) string {
+	if c, ok := s.categories[catId]; ok && c.Name != "" {
+		return c.Name
+	}
+	return "Category " + catId
+}
+
+func (s *Server) sortedCategoryIDs() []string {
+	ids := map[string]bool{}
+	for id := range s.categories {
+		ids[id] = true
+	}
+	for id := range s.products {
+		ids[id] = true
+	}
+	sorted := []string{}
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func isMissing(vs ...string) bool {
+	for _, v := range vs {
+		if strings.TrimSpace(v) == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package io
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/geniass/ebucks-dealz/pkg/warc"
+)
+
+// ArchivedProduct holds the archived raw responses for a single product.
+// Discount is nil if the discount fragment was never fetched in that run.
+type ArchivedProduct struct {
+	ProdID   string
+	Page     *warc.Record
+	Discount *warc.Record
+}
+
+// LoadArchivedProduct finds the product page and discount fragment for prodID in the WARC archive of a single run.
+// If a page was fetched more than once (e.g. retries) the latest capture is used.
+func LoadArchivedProduct(runDir string, prodID string) (ArchivedProduct, error) {
+	a, err := warc.Open(runDir)
+	if err != nil {
+		return ArchivedProduct{}, err
+	}
+
+	ap := ArchivedProduct{ProdID: prodID}
+	var pageEntry, discountEntry *warc.IndexEntry
+	for _, e := range a.Entries() {
+		e := e
+		u, err := url.Parse(e.URL)
+		if err != nil || u.Query().Get("prodId") != prodID {
+			continue
+		}
+
+		switch path.Base(u.Path) {
+		case "productSelected.do":
+			if pageEntry == nil || e.Timestamp.After(pageEntry.Timestamp) {
+				pageEntry = &e
+			}
+		case "productSelectedDiscount.do":
+			if discountEntry == nil || e.Timestamp.After(discountEntry.Timestamp) {
+				discountEntry = &e
+			}
+		}
+	}
+
+	if pageEntry == nil {
+		return ap, fmt.Errorf("product %q not found in archive %q", prodID, runDir)
+	}
+	if ap.Page, err = a.ReadRecord(*pageEntry); err != nil {
+		return ap, err
+	}
+	if discountEntry != nil {
+		if ap.Discount, err = a.ReadRecord(*discountEntry); err != nil {
+			return ap, err
+		}
+	}
+	return ap, nil
+}
@@ -0,0 +1,91 @@
+package io
+
+import (
+	"bytes"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+	"github.com/geniass/ebucks-dealz/pkg/warc"
+)
+
+func TestLoadArchivedProduct(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	products[1].Discounts = []int{10, 25}
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	w, err := warc.NewWriter(dir, "test", warc.DefaultMaxFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := sync.Mutex{}
+	scraped := map[string]scraper.Product{}
+	s, err := scraper.New(
+		scraper.WithBaseURL(ts.URL),
+		scraper.WithArchive(w),
+		scraper.WithLogger(logging.Discard()),
+		scraper.WithCallback(func(p scraper.Product) {
+			m.Lock()
+			defer m.Unlock()
+			scraped[p.ProdID] = p
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want, ok := scraped[products[1].ProdID]
+	if !ok {
+		t.Fatalf("product %s was not scraped", products[1].ProdID)
+	}
+	ap, err := LoadArchivedProduct(dir, want.ProdID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ap.Discount == nil {
+		t.Fatal("the discount fragment should have been archived")
+	}
+
+	page := parseRecord(t, ap.Page)
+	pageURL, err := url.Parse(want.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := scraper.ExtractProduct(page, pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = scraper.ApplyDiscounts(got, scraper.ExtractDiscounts(parseRecord(t, ap.Discount)))
+	if got != want {
+		t.Errorf("archived product differs from the scraped one:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	if _, err := LoadArchivedProduct(dir, "no-such-product"); err == nil {
+		t.Error("loading a product that isn't in the archive should fail")
+	}
+}
+
+func parseRecord(t *testing.T, r *warc.Record) *goquery.Document {
+	t.Helper()
+	body, err := r.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
@@ -0,0 +1,75 @@
+package io
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// ManifestFilename is the name of the crawl manifest within a snapshot directory.
+const ManifestFilename = "manifest.jsonl"
+
+// LoadManifest reads a crawl manifest written by scraper.ManifestWriter.
+func LoadManifest(path string) (scraper.Manifest, error) {
+	var m scraper.Manifest
+
+	f, err := os.Open(path)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return m, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		switch record.Type {
+		case scraper.ManifestRecordRun:
+			err = json.Unmarshal(scanner.Bytes(), &m.Run)
+		case scraper.ManifestRecordURL:
+			var u scraper.ManifestURL
+			err = json.Unmarshal(scanner.Bytes(), &u)
+			m.URLs = append(m.URLs, u)
+		case scraper.ManifestRecordCategory:
+			var c scraper.ManifestCategory
+			err = json.Unmarshal(scanner.Bytes(), &c)
+			m.Categories = append(m.Categories, c)
+		case scraper.ManifestRecordEnd:
+			m.End = &scraper.ManifestEnd{}
+			err = json.Unmarshal(scanner.Bytes(), m.End)
+		default:
+			err = fmt.Errorf("unknown record type %q", record.Type)
+		}
+		if err != nil {
+			return m, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+// LatestManifest loads the manifest of LatestSnapshot. It returns fs.ErrNotExist if there is none.
+func LatestManifest(dataDir string, logger *slog.Logger) (scraper.Manifest, error) {
+	s, err := LatestSnapshot(dataDir, logger)
+	if err != nil {
+		return scraper.Manifest{}, err
+	}
+	return LoadManifest(filepath.Join(s.Dir, ManifestFilename))
+}
@@ -0,0 +1,224 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("1", 3))
+	defer ts.Close()
+	ts.BreakProduct("2")
+
+	path := filepath.Join(t.TempDir(), ManifestFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := scraper.NewManifestWriter(f, "test-run")
+
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithThreads(2), scraper.WithObserver(mw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := mw.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Run.RunID != "test-run" || m.Run.Config.Threads != 2 {
+		t.Errorf("wrong run record: %+v", m.Run)
+	}
+	if m.End == nil || m.End.Products != 2 {
+		t.Fatalf("wrong end record: %+v", m.End)
+	}
+
+	byURL := map[string]scraper.ManifestURL{}
+	for _, u := range m.URLs {
+		byURL[u.URL] = u
+	}
+	// home + category + 3 products + 2 discount fragments
+	if len(byURL) != 7 {
+		t.Errorf("wrong number of URLs: got %d expected 7", len(byURL))
+	}
+
+	broken := byURL[ts.URL+"/web/shop/productSelected.do?prodId=2&catId=1"]
+	if broken.Outcome != scraper.OutcomeErrorPage {
+		t.Errorf("broken product should be recorded as an error page: %+v", broken)
+	}
+
+	discount := byURL[ts.URL+"/web/shop/productSelectedDiscount.do?prodId=1&catId=1"]
+	if discount.Outcome != scraper.OutcomeOK || discount.Status != 200 || discount.Bytes == 0 || discount.Attempts != 1 {
+		t.Errorf("wrong discount record: %+v", discount)
+	}
+	if discount.Parent != ts.URL+"/web/shop/productSelected.do?prodId=1&catId=1" {
+		t.Errorf("discount parent should be its product page: got %q", discount.Parent)
+	}
+	if product := byURL[discount.Parent]; product.Parent != ts.URL+"/web/shop/categorySelected.do?catId=1" {
+		t.Errorf("product parent should be its category page: got %q", product.Parent)
+	}
+}
+
+func TestManifestCountsFailedPagesOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ManifestFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := scraper.NewManifestWriter(f, "test-run")
+	now := time.Now()
+	mw.Observe(scraper.Event{Kind: scraper.EventStart, Time: now, Config: &scraper.RunConfig{}})
+	for attempt := 1; attempt <= 3; attempt++ {
+		mw.Observe(scraper.Event{Kind: scraper.EventRequest, URL: "u", Attempt: attempt})
+		mw.Observe(scraper.Event{Kind: scraper.EventError, URL: "u", StatusCode: 503, Err: errors.New("503"), Retrying: attempt < 3})
+	}
+	mw.Observe(scraper.Event{Kind: scraper.EventFinish, Time: now})
+	f.Close()
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.End == nil || m.End.Errors != 1 {
+		t.Errorf("a page that failed after retries should be one error: %+v", m.End)
+	}
+	if len(m.URLs) != 1 || m.URLs[0].Attempts != 3 {
+		t.Errorf("the page should be recorded once with every attempt: %+v", m.URLs)
+	}
+}
+
+func TestLatestManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(run string, start time.Time, products int, finished bool) {
+		if err := os.MkdirAll(filepath.Join(dir, run), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(filepath.Join(dir, run, ManifestFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		enc := json.NewEncoder(f)
+		enc.Encode(scraper.ManifestRun{Type: scraper.ManifestRecordRun, RunID: run, Start: start})
+		if finished {
+			enc.Encode(scraper.ManifestEnd{Type: scraper.ManifestRecordEnd, End: start.Add(time.Hour), Products: products})
+		}
+	}
+
+	if _, err := LatestManifest(dir, logging.Discard()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist without manifests: got %v", err)
+	}
+
+	now := time.Now()
+	write("old", now.Add(-48*time.Hour), 10, true)
+	write("previous", now.Add(-24*time.Hour), 20, true)
+	write("died", now.Add(-time.Hour), 5, false)
+
+	m, err := LatestManifest(dir, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Run.RunID != "previous" || m.End.Products != 20 {
+		t.Errorf("wrong manifest: got run %q with %d products expected %q with 20", m.Run.RunID, m.End.Products, "previous")
+	}
+}
+
+func TestManifestCompleteness(t *testing.T) {
+	ts := ebuckstest.NewServer(append(ebuckstest.MakeProducts("1", 10), ebuckstest.MakeProducts("2", 3)...))
+	defer ts.Close()
+	ts.SetPageSize(4)
+	ts.BreakProduct("7")
+
+	path := filepath.Join(t.TempDir(), ManifestFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := scraper.NewManifestWriter(f, "test-run")
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithObserver(mw), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := m.Completeness()
+	if len(cs) != 2 {
+		t.Fatalf("wrong number of categories: got %d expected 2", len(cs))
+	}
+
+	broken := cs[0]
+	if broken.CatID != "1" || broken.Expected != 10 || broken.Listed != 10 || broken.Scraped != 9 || broken.Pages != 3 || broken.PagesSeen != 3 {
+		t.Errorf("wrong completeness for category 1: %+v", broken)
+	}
+	if broken.Complete() {
+		t.Errorf("category 1 is missing a product and should not be complete")
+	}
+	if !cs[1].Complete() || cs[1].Scraped != 3 {
+		t.Errorf("category 2 should be complete: %+v", cs[1])
+	}
+}
+
+func TestManifestRecordsProxyStats(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("1", 2))
+	defer ts.Close()
+	p := ebuckstest.NewProxy()
+	defer p.Close()
+	pool, err := proxy.New([]string{p.URL}, proxy.PerWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), ManifestFilename)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := scraper.NewManifestWriter(f, "test-run")
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithProxies(pool), scraper.WithObserver(mw), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Run.Config.Proxies != 1 {
+		t.Errorf("wrong number of proxies in run record: got %d expected 1", m.Run.Config.Proxies)
+	}
+	if m.End == nil || len(m.End.Proxies) != 1 {
+		t.Fatalf("wrong end record: %+v", m.End)
+	}
+	if stats := m.End.Proxies[0]; stats.URL != p.URL || stats.Requests != p.Hits() || !stats.Healthy {
+		t.Errorf("wrong proxy stats: got %+v with %d hits", stats, p.Hits())
+	}
+}
@@ -3,9 +3,11 @@ package io
 import (
 	"encoding/json"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 
+	"github.com/geniass/ebucks-dealz/pkg/logging"
 	"github.com/geniass/ebucks-dealz/pkg/scraper"
 )
 
@@ -14,7 +16,8 @@ type ProductWithPath struct {
 	Path string
 }
 
-func LoadFromDir(dir string) ([]ProductWithPath, error) {
+// LoadFromDir loads every product JSON file under dir.
+func LoadFromDir(dir string, logger *slog.Logger) ([]ProductWithPath, error) {
 	var ps []ProductWithPath
 	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -34,8 +37,10 @@ func LoadFromDir(dir string) ([]ProductWithPath, error) {
 		dec := json.NewDecoder(f)
 		var p scraper.Product
 		if err := dec.Decode(&p); err != nil {
+			logger.Error("could not decode product", "path", path, logging.KeyError, err)
 			return err
 		}
+		logger.Debug("loaded product", "path", path, logging.KeyProdID, p.ProdID, logging.KeyCatID, p.CatID)
 		ps = append(ps, ProductWithPath{Product: p, Path: path})
 		return nil
 	})
@@ -43,5 +48,6 @@ func LoadFromDir(dir string) ([]ProductWithPath, error) {
 	if err != nil {
 		return ps, err
 	}
+	logger.Info("loaded products", "dir", dir, "count", len(ps))
 	return ps, nil
 }
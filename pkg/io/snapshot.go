@@ -0,0 +1,78 @@
+package io
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+// RawDirname is the name of the directory of product files within a snapshot directory.
+const RawDirname = "raw"
+
+// Snapshot is the directory of a single crawl's data.
+type Snapshot struct {
+	Dir string
+	// Time is when the crawl finished, or zero for data from before manifests were written
+	Time time.Time
+}
+
+// RawDir is the directory of the snapshot's product files.
+func (s Snapshot) RawDir() string {
+	return filepath.Join(s.Dir, RawDirname)
+}
+
+// LatestSnapshot finds the most recent successfully finished crawl among the snapshot directories in dataDir (dataDir
+// itself is included, for data written with -overwrite). Crawls that ended with an error or an outage are skipped, as
+// their data is incomplete. Data without a manifest only counts if nothing else is found. It returns fs.ErrNotExist if
+// there is no snapshot. Manifests that can't be read, e.g. because a crawl was killed mid-write, are logged and
+// skipped.
+func LatestSnapshot(dataDir string, logger *slog.Logger) (Snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(dataDir, "*", ManifestFilename))
+	if err != nil {
+		return Snapshot{}, err
+	}
+	paths = append(paths, filepath.Join(dataDir, ManifestFilename))
+
+	var latest Snapshot
+	var latestStart time.Time
+	found := false
+	for _, path := range paths {
+		m, err := LoadManifest(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			logger.Warn("skipping unreadable manifest", "path", path, logging.KeyError, err)
+			continue
+		}
+		if m.End == nil || m.End.Error != "" || m.End.Outage != nil {
+			continue
+		}
+		if !found || m.Run.Start.After(latestStart) {
+			latest = Snapshot{Dir: filepath.Dir(path), Time: m.End.End}
+			latestStart = m.Run.Start
+			found = true
+		}
+	}
+	if found {
+		return latest, nil
+	}
+
+	if info, err := os.Stat(filepath.Join(dataDir, RawDirname)); err == nil && info.IsDir() {
+		return Snapshot{Dir: dataDir}, nil
+	}
+	return Snapshot{}, fs.ErrNotExist
+}
+
+// LoadSnapshot loads the products of the snapshot in dir, which can also be the snapshot's raw directory.
+func LoadSnapshot(dir string, logger *slog.Logger) ([]ProductWithPath, error) {
+	raw := filepath.Join(dir, RawDirname)
+	if info, err := os.Stat(raw); err == nil && info.IsDir() {
+		return LoadFromDir(raw, logger)
+	}
+	return LoadFromDir(dir, logger)
+}
@@ -0,0 +1,113 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestLatestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LatestSnapshot(dir, logging.Discard()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist without snapshots: got %v", err)
+	}
+
+	// data from before manifests, written with -overwrite
+	writeProduct(t, filepath.Join(dir, RawDirname), scraper.Product{ProdID: "1", CatID: "1", Name: "Old"})
+	s, err := LatestSnapshot(dir, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Dir != dir || !s.Time.IsZero() {
+		t.Errorf("wrong snapshot: got %+v expected %s without a time", s, dir)
+	}
+
+	end := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	run := filepath.Join(dir, "run")
+	writeProduct(t, filepath.Join(run, RawDirname), scraper.Product{ProdID: "2", CatID: "1", Name: "New"})
+	f, err := os.Create(filepath.Join(run, ManifestFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	enc.Encode(scraper.ManifestRun{Type: scraper.ManifestRecordRun, RunID: "run", Start: end.Add(-time.Hour)})
+	enc.Encode(scraper.ManifestEnd{Type: scraper.ManifestRecordEnd, End: end, Products: 1})
+	f.Close()
+
+	// newer crawls that failed or hit an outage don't count
+	failed := filepath.Join(dir, "failed")
+	writeProduct(t, filepath.Join(failed, RawDirname), scraper.Product{ProdID: "3", CatID: "1", Name: "Partial"})
+	writeManifest(t, failed,
+		scraper.ManifestRun{Type: scraper.ManifestRecordRun, RunID: "failed", Start: end},
+		scraper.ManifestEnd{Type: scraper.ManifestRecordEnd, End: end.Add(time.Hour), Error: "2 tasks failed"})
+	outage := filepath.Join(dir, "outage")
+	writeManifest(t, outage,
+		scraper.ManifestRun{Type: scraper.ManifestRecordRun, RunID: "outage", Start: end.Add(time.Hour)},
+		scraper.ManifestEnd{Type: scraper.ManifestRecordEnd, End: end.Add(2 * time.Hour),
+			Outage: &scraper.OutageError{Reason: "home page returned 503"}})
+	// nor does a crawl that was killed while writing its manifest
+	truncated := filepath.Join(dir, "truncated")
+	if err := os.MkdirAll(truncated, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(truncated, ManifestFilename), []byte(`{"type":"run","runId":"trunc`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = LatestSnapshot(dir, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Dir != run || !s.Time.Equal(end) {
+		t.Errorf("wrong snapshot: got %+v expected %s at %s", s, run, end)
+	}
+
+	for _, d := range []string{s.Dir, s.RawDir()} {
+		ps, err := LoadSnapshot(d, logging.Discard())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ps) != 1 || ps[0].Name != "New" {
+			t.Errorf("wrong products loaded from %s: %+v", d, ps)
+		}
+	}
+}
+
+func writeManifest(t *testing.T, dir string, records ...any) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, ManifestFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeProduct(t *testing.T, dir string, p scraper.Product) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, p.ProdID+".json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package logging sets up the structured loggers used by the commands.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Common field keys, so that logs can be filtered the same way across packages.
+const (
+	KeyURL      = "url"
+	KeyParent   = "parent"
+	KeyProdID   = "prodId"
+	KeyCatID    = "catId"
+	KeyAttempt  = "attempt"
+	KeyStatus   = "status"
+	KeyError    = "error"
+	KeyDuration = "duration"
+)
+
+// Flags holds the logging command line flags.
+type Flags struct {
+	Level  string
+	Format string
+}
+
+// RegisterFlags adds -log-level and -log-format to fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Level, "log-level", "info", "minimum level to log: debug, info, warn or error")
+	fs.StringVar(&f.Format, "log-format", "text", "log output format: text or json")
+	return f
+}
+
+// Logger creates a logger writing to stderr according to the flags and makes it the default,
+// so that packages using slog.Default (and the standard log package) go through it too.
+func (f *Flags) Logger() (*slog.Logger, error) {
+	l, err := New(os.Stderr, f.Level, f.Format)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(l)
+	return l, nil
+}
+
+// New creates a logger writing to w.
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// Discard returns a logger that drops everything.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(100)}))
+}
+
+// Fatal logs msg at error level and exits, like log.Fatal.
+func Fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
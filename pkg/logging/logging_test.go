@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Debug("hidden")
+	l.Info("found product", KeyURL, "http://example.com/", KeyProdID, "1", KeyAttempt, 2)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %s", buf.String(), err)
+	}
+	if line["msg"] != "found product" {
+		t.Errorf("msg: got %v expected %q", line["msg"], "found product")
+	}
+	if line[KeyProdID] != "1" {
+		t.Errorf("prodId: got %v expected %q", line[KeyProdID], "1")
+	}
+	if line[KeyAttempt] != float64(2) {
+		t.Errorf("attempt: got %v expected 2", line[KeyAttempt])
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "loud", "text"); err == nil {
+		t.Errorf("expected an error for an invalid level")
+	}
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Errorf("expected an error for an invalid format")
+	}
+}
@@ -0,0 +1,136 @@
+// Package metrics exposes crawl health as Prometheus metrics.
+package metrics
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "ebucks_scraper"
+
+// Metrics is a scraper.Observer that keeps Prometheus metrics about a crawl.
+type Metrics struct {
+	requests           *prometheus.CounterVec
+	responses          *prometheus.CounterVec
+	errors             *prometheus.CounterVec
+	retries            *prometheus.CounterVec
+	errorPageRedirects prometheus.Counter
+	products           prometheus.Counter
+	discounted         prometheus.Counter
+	latency            *prometheus.HistogramVec
+	running            prometheus.Gauge
+}
+
+// New creates the crawl metrics and registers them with reg.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests made, including retries, by page type.",
+		}, []string{"page_type"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "responses_total",
+			Help:      "Responses received, by page type and HTTP status code.",
+		}, []string{"page_type", "code"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Failed requests, by page type.",
+		}, []string{"page_type"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Failed requests that will be retried, by page type.",
+		}, []string{"page_type"}),
+		errorPageRedirects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "error_page_redirects_total",
+			Help:      "Requests the site redirected to its error page.",
+		}),
+		products: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "products_total",
+			Help:      "Products emitted.",
+		}),
+		discounted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discounted_products_total",
+			Help:      "Emitted products that are discounted.",
+		}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time from sending a request to receiving its response or error, by page type.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, []string{"page_type"}),
+		running: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "running",
+			Help:      "1 while a crawl is running.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.requests, m.responses, m.errors, m.retries, m.errorPageRedirects,
+		m.products, m.discounted, m.latency, m.running,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RegisterQueueDepth reports the number of queued requests, e.g. from Scraper.QueueSize.
+func RegisterQueueDepth(reg prometheus.Registerer, size func() int) error {
+	return reg.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Requests waiting in the queue.",
+	}, func() float64 {
+		return float64(size())
+	}))
+}
+
+func (m *Metrics) Observe(e scraper.Event) {
+	pageType := string(e.PageType)
+
+	switch e.Kind {
+	case scraper.EventStart:
+		m.running.Set(1)
+
+	case scraper.EventRequest:
+		m.requests.WithLabelValues(pageType).Inc()
+
+	case scraper.EventResponse:
+		m.responses.WithLabelValues(pageType, strconv.Itoa(e.StatusCode)).Inc()
+		m.latency.WithLabelValues(pageType).Observe(e.Duration.Seconds())
+
+	case scraper.EventError:
+		m.errors.WithLabelValues(pageType).Inc()
+		if e.StatusCode != 0 {
+			m.responses.WithLabelValues(pageType, strconv.Itoa(e.StatusCode)).Inc()
+		}
+		m.latency.WithLabelValues(pageType).Observe(e.Duration.Seconds())
+		if e.Retrying {
+			m.retries.WithLabelValues(pageType).Inc()
+		}
+		if errors.Is(e.Err, scraper.ErrRedirectToErrorPage) {
+			m.errorPageRedirects.Inc()
+		}
+
+	case scraper.EventProduct:
+		m.products.Inc()
+		if e.Product != nil && e.Product.Percentage > 0 {
+			m.discounted.Inc()
+		}
+
+	case scraper.EventFinish:
+		m.running.Set(0)
+	}
+}
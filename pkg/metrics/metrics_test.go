@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsObserveCrawl(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 4)
+	products[0].Discounts = ebuckstest.Levels
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.BreakProduct("2")
+	// the home page is the first request
+	ts.AfterRequests(1, func(s *ebuckstest.Server) {
+		s.FailNext(1, http.StatusServiceUnavailable)
+	})
+
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithObserver(m), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterQueueDepth(reg, s.QueueSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(m.products); got != 3 {
+		t.Errorf("products: got %f expected 3", got)
+	}
+	if got := testutil.ToFloat64(m.discounted); got != 1 {
+		t.Errorf("discounted products: got %f expected 1", got)
+	}
+	if got := testutil.ToFloat64(m.errorPageRedirects); got != 1 {
+		t.Errorf("error page redirects: got %f expected 1", got)
+	}
+	if got := testutil.ToFloat64(m.retries.WithLabelValues(string(scraper.PageCategory))); got != 1 {
+		t.Errorf("category retries: got %f expected 1", got)
+	}
+	if got := testutil.ToFloat64(m.requests.WithLabelValues(string(scraper.PageDiscount))); got != 3 {
+		t.Errorf("discount requests: got %f expected 3", got)
+	}
+	if got := testutil.ToFloat64(m.running); got != 0 {
+		t.Errorf("running: got %f expected 0", got)
+	}
+	if n, err := testutil.GatherAndCount(reg, namespace+"_queue_depth"); err != nil || n != 1 {
+		t.Errorf("queue depth gauge not registered: %d %v", n, err)
+	}
+}
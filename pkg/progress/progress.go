@@ -0,0 +1,232 @@
+// Package progress tracks how far along a crawl is and reports it while it runs.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// Progress is a snapshot of a crawl.
+type Progress struct {
+	Elapsed        time.Duration
+	CategoriesDone int
+	Categories     int
+	// ProductsFound counts distinct product pages queued, ProductsEmitted the products scraped so far
+	ProductsFound   int
+	ProductsEmitted int
+	// ProductsExpected is the previous run's product count, or 0 if unknown
+	ProductsExpected int
+	QueueSize        int
+	Requests         int
+	Errors           int
+	// RequestRate is in requests per second, over the last interval
+	RequestRate float64
+	// ETA is the estimated time remaining, or 0 if it can't be estimated yet
+	ETA time.Duration
+}
+
+// Tracker is a scraper.Observer that keeps track of a crawl's progress.
+type Tracker struct {
+	queueSize func() int
+	expected  int
+
+	mutex      *sync.Mutex
+	start      time.Time
+	categories map[string]bool
+	products   map[string]bool
+	emitted    int
+	requests   int
+	errors     int
+
+	// for the rates between snapshots
+	lastTime     time.Time
+	lastRequests int
+	lastEmitted  int
+	productRate  float64
+}
+
+// NewTracker creates a tracker. queueSize (e.g. Scraper.QueueSize) can be nil; expectedProducts is the
+// number of products the previous run found, used to estimate the time remaining, or 0 if unknown.
+func NewTracker(queueSize func() int, expectedProducts int) *Tracker {
+	return &Tracker{
+		queueSize:  queueSize,
+		expected:   expectedProducts,
+		mutex:      &sync.Mutex{},
+		categories: make(map[string]bool),
+		products:   make(map[string]bool),
+	}
+}
+
+func (t *Tracker) Observe(e scraper.Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch e.Kind {
+	case scraper.EventStart:
+		t.start = e.Time
+		t.lastTime = e.Time
+
+	case scraper.EventQueued:
+		switch e.PageType {
+		case scraper.PageCategory:
+			// later pages of a category are the same category
+			if id := catID(e.URL); id != "" {
+				if _, ok := t.categories[id]; !ok {
+					t.categories[id] = false
+				}
+			}
+		case scraper.PageProduct:
+			t.products[e.URL] = true
+		}
+
+	case scraper.EventRequest:
+		t.requests++
+
+	case scraper.EventCategory:
+		if e.Category.Page >= e.Category.Pages {
+			t.categories[e.Category.CatID] = true
+		}
+
+	case scraper.EventError:
+		t.errors++
+		if e.PageType == scraper.PageCategory && !e.Retrying {
+			if id := catID(e.URL); id != "" {
+				t.categories[id] = true
+			}
+		}
+
+	case scraper.EventProduct:
+		t.emitted++
+	}
+}
+
+// Snapshot returns the progress so far. Rates are measured since the previous snapshot.
+func (t *Tracker) Snapshot() Progress {
+	queueSize := 0
+	if t.queueSize != nil {
+		queueSize = t.queueSize()
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	p := Progress{
+		Categories:       len(t.categories),
+		ProductsFound:    len(t.products),
+		ProductsEmitted:  t.emitted,
+		ProductsExpected: t.expected,
+		QueueSize:        queueSize,
+		Requests:         t.requests,
+		Errors:           t.errors,
+	}
+	for _, done := range t.categories {
+		if done {
+			p.CategoriesDone++
+		}
+	}
+	if t.start.IsZero() {
+		return p
+	}
+	p.Elapsed = now.Sub(t.start)
+
+	if interval := now.Sub(t.lastTime).Seconds(); interval > 0 {
+		p.RequestRate = float64(t.requests-t.lastRequests) / interval
+		rate := float64(t.emitted-t.lastEmitted) / interval
+		if t.productRate == 0 {
+			t.productRate = rate
+		} else {
+			// smooth it out, the rate jumps around a lot between categories
+			t.productRate = 0.7*t.productRate + 0.3*rate
+		}
+	}
+	t.lastTime = now
+	t.lastRequests = t.requests
+	t.lastEmitted = t.emitted
+
+	p.ETA = estimate(p, t.productRate)
+	return p
+}
+
+func catID(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("catId")
+}
+
+// estimate the time remaining from the expected number of products, falling back to the ones found so far
+func estimate(p Progress, productRate float64) time.Duration {
+	total := p.ProductsExpected
+	if total < p.ProductsFound {
+		total = p.ProductsFound
+	}
+	remaining := total - p.ProductsEmitted
+	if remaining <= 0 || productRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / productRate * float64(time.Second))
+}
+
+// String formats p as a compact status line.
+func (p Progress) String() string {
+	products := fmt.Sprintf("%d/%d", p.ProductsEmitted, p.ProductsFound)
+	if p.ProductsExpected > 0 {
+		products += fmt.Sprintf(" (~%d expected)", p.ProductsExpected)
+	}
+	eta := "?"
+	if p.ETA > 0 {
+		eta = p.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s categories %d/%d | products %s | queue %d | %.1f req/s | errors %d | ETA %s",
+		p.Elapsed.Round(time.Second), p.CategoriesDone, p.Categories, products, p.QueueSize, p.RequestRate, p.Errors, eta)
+}
+
+// Report redraws a status line on the terminal w every interval until ctx is done.
+func Report(ctx context.Context, t *Tracker, w io.Writer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(w, "\r\033[K%s\n", t.Snapshot())
+			return
+		case <-ticker.C:
+			fmt.Fprintf(w, "\r\033[K%s", t.Snapshot())
+		}
+	}
+}
+
+// Log logs the progress every interval until ctx is done, for CI where there is no terminal.
+func Log(ctx context.Context, t *Tracker, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p := t.Snapshot()
+			logger.Info("progress",
+				"elapsed", p.Elapsed.Round(time.Second),
+				"categoriesDone", p.CategoriesDone,
+				"categories", p.Categories,
+				"productsEmitted", p.ProductsEmitted,
+				"productsFound", p.ProductsFound,
+				"productsExpected", p.ProductsExpected,
+				"queue", p.QueueSize,
+				"requestRate", p.RequestRate,
+				"errors", p.Errors,
+				"eta", p.ETA.Round(time.Second),
+			)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package progress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestTrackerCountsCrawl(t *testing.T) {
+	cs, ps := ebuckstest.RandomCatalogue(1, 3, 30)
+	ts := ebuckstest.NewServer(ps)
+	defer ts.Close()
+	ts.SetCategories(cs)
+	ts.SetPageSize(3)
+
+	var s scraper.Scraper
+	tracker := NewTracker(func() int { return s.QueueSize() }, 40)
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithThreads(4), scraper.WithObserver(tracker), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := tracker.Snapshot()
+	if p.Categories != len(cs) || p.CategoriesDone != len(cs) {
+		t.Errorf("categories: got %d/%d expected %d/%d", p.CategoriesDone, p.Categories, len(cs), len(cs))
+	}
+	if p.ProductsFound != len(ps) || p.ProductsEmitted != len(ps) {
+		t.Errorf("products: got %d/%d expected %d/%d", p.ProductsEmitted, p.ProductsFound, len(ps), len(ps))
+	}
+	if p.QueueSize != 0 {
+		t.Errorf("queue size: got %d expected 0", p.QueueSize)
+	}
+	if p.Requests == 0 || p.Elapsed == 0 {
+		t.Errorf("requests and elapsed time should have been counted: %+v", p)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	cases := []struct {
+		p        Progress
+		rate     float64
+		expected time.Duration
+	}{
+		{Progress{ProductsExpected: 100, ProductsFound: 50, ProductsEmitted: 40}, 2, 30 * time.Second},
+		// more found than expected
+		{Progress{ProductsExpected: 100, ProductsFound: 120, ProductsEmitted: 100}, 10, 2 * time.Second},
+		// no previous run
+		{Progress{ProductsFound: 50, ProductsEmitted: 40}, 1, 10 * time.Second},
+		{Progress{ProductsExpected: 100, ProductsEmitted: 40}, 0, 0},
+		{Progress{ProductsExpected: 100, ProductsEmitted: 100}, 5, 0},
+	}
+	for _, c := range cases {
+		if got := estimate(c.p, c.rate); got != c.expected {
+			t.Errorf("estimate(%+v, %f): got %s expected %s", c.p, c.rate, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+// the environment variables CredentialsFromEnv reads
+const (
+	EnvUsername = "EBUCKS_USERNAME"
+	EnvPassword = "EBUCKS_PASSWORD"
+)
+
+// loginPath is where the login form is posted. Like the form's fields and the markers ExtractMemberLevel looks
+// for, it is a guess that hasn't been checked against the live site, so logging in is experimental.
+const loginPath = "/web/eBucks/login.do"
+
+var ErrLoginFailed = errors.New("login failed")
+
+// Credentials are the login details of an eBucks member. Pages fetched while logged in show the discount
+// tiers of the member's level. Logging in is experimental, see WithCredentials.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialsFromEnv reads credentials from EBUCKS_USERNAME and EBUCKS_PASSWORD.
+// ok is false if either is unset.
+func CredentialsFromEnv() (c Credentials, ok bool) {
+	c.Username = os.Getenv(EnvUsername)
+	c.Password = os.Getenv(EnvPassword)
+	return c, c.Username != "" && c.Password != ""
+}
+
+// LoadCredentials reads credentials from a JSON file like {"username": "...", "password": "..."}.
+func LoadCredentials(path string) (Credentials, error) {
+	c := Credentials{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Username == "" || c.Password == "" {
+		return c, fmt.Errorf("%s: username and password are required", path)
+	}
+	return c, nil
+}
+
+// login logs sess in with the pool's credentials. The session must already have been started from the home page.
+func (p *sessionPool) login(sess *session, req *http.Request) error {
+	home, err := url.Parse(p.homeURL)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"username": {p.credentials.Username}, "password": {p.credentials.Password}}
+	loginURL := home.ResolveReference(&url.URL{Path: loginPath})
+	r, err := http.NewRequestWithContext(req.Context(), http.MethodPost, loginURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header = req.Header.Clone()
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Referer", p.homeURL)
+
+	resp, err := p.send(sess, r)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// members are sent on to the home page, wrong details get the login form again
+	if !redirectsHome(resp) {
+		return fmt.Errorf("%w: %s got status %d", ErrLoginFailed, p.credentials.Username, resp.StatusCode)
+	}
+	p.logger.Debug("logged in", "session", sess.id)
+	p.save(sess)
+	return nil
+}
+
+// loggedOut says whether resp is a page shown to someone who isn't logged in. The body is read and replaced.
+func loggedOut(resp *http.Response) (bool, error) {
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return false, nil
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
+	if err != nil {
+		return false, nil
+	}
+	level, ok := ExtractMemberLevel(doc)
+	return ok && level == 0, nil
+}
+
+// savedSessions is the session file: the cookies of each logged-in session, by session number, so the
+// sessions can be reused by the next run instead of logging in again
+type savedSessions struct {
+	Username string                    `json:"username"`
+	Sessions map[string][]*http.Cookie `json:"sessions"`
+}
+
+// restore loads the cookies saved for sess, if there are any for the current user
+func (p *sessionPool) restore(sess *session) {
+	if p.sessionFile == "" {
+		return
+	}
+	saved, err := p.readSessionFile()
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			p.logger.Warn("could not read session file", "file", p.sessionFile, logging.KeyError, err)
+		}
+		return
+	}
+	cookies := saved.Sessions[strconv.Itoa(sess.id)]
+	if saved.Username != p.credentials.Username || len(cookies) == 0 {
+		return
+	}
+
+	home, err := url.Parse(p.homeURL)
+	if err != nil {
+		return
+	}
+	for _, c := range cookies {
+		// the jar doesn't give back paths, and the login page is outside the shop
+		c.Path = "/"
+	}
+	sess.jar.SetCookies(home, cookies)
+	sess.sessionCookies = len(cookies)
+	// if it has expired the first page will say so
+	sess.started = true
+	sess.lastUsed = time.Now()
+	p.logger.Debug("restored session", "session", sess.id)
+}
+
+// save writes the cookies of sess to the session file
+func (p *sessionPool) save(sess *session) {
+	if p.sessionFile == "" {
+		return
+	}
+	home, err := url.Parse(p.homeURL)
+	if err != nil {
+		return
+	}
+
+	p.fileMutex.Lock()
+	defer p.fileMutex.Unlock()
+	saved, err := p.readSessionFile()
+	if err != nil || saved.Username != p.credentials.Username {
+		saved = savedSessions{Username: p.credentials.Username, Sessions: map[string][]*http.Cookie{}}
+	}
+	// the jar only gives back names and values, which is all that's needed to send them again
+	saved.Sessions[strconv.Itoa(sess.id)] = sess.jar.Cookies(home)
+
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		err = os.WriteFile(p.sessionFile, b, 0600)
+	}
+	if err != nil {
+		p.logger.Warn("could not write session file", "file", p.sessionFile, logging.KeyError, err)
+	}
+}
+
+func (p *sessionPool) readSessionFile() (savedSessions, error) {
+	saved := savedSessions{}
+	b, err := os.ReadFile(p.sessionFile)
+	if err != nil {
+		return saved, err
+	}
+	err = json.Unmarshal(b, &saved)
+	return saved, err
+}
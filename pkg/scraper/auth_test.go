@@ -0,0 +1,161 @@
+package scraper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func TestScraperLogsIn(t *testing.T) {
+	products := ebuckstest.MakeProducts("16", 5)
+	products[1].Discounts = ebuckstest.Levels
+	products[1].Price = 1000
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.AddMember("member", "secret", 3)
+
+	scraped, err := scrapeWith(ts, WithThreads(2), WithCredentials(Credentials{Username: "member", Password: "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scraped) != len(products) {
+		t.Fatalf("wrong number of scraped products: got %d expected %d", len(scraped), len(products))
+	}
+	for _, p := range scraped {
+		if p.MemberLevel != 3 {
+			t.Errorf("product %q has wrong member level: got %d expected 3", p.ProdID, p.MemberLevel)
+		}
+		if p.ProdID == "1" && p.Percentage != float64(ebuckstest.Levels[2]) {
+			t.Errorf("wrong percentage for level 3: got %f expected %d", p.Percentage, ebuckstest.Levels[2])
+		}
+	}
+	if ts.Logins() < 1 || ts.Logins() > 2 {
+		t.Errorf("every worker should log in once: got %d logins", ts.Logins())
+	}
+}
+
+func TestScraperFailsWithWrongPassword(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("17", 2))
+	defer ts.Close()
+	ts.AddMember("member", "secret", 3)
+
+	_, err := scrapeWith(ts, WithCredentials(Credentials{Username: "member", Password: "wrong"}))
+	if !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("expected ErrLoginFailed: got %v", err)
+	}
+	if ts.Hits(ebuckstest.CategoryPath) != 0 {
+		t.Errorf("nothing should be scraped without logging in: got %d category requests", ts.Hits(ebuckstest.CategoryPath))
+	}
+}
+
+func TestScraperLogsBackIn(t *testing.T) {
+	products := ebuckstest.MakeProducts("18", 6)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.AddMember("member", "secret", 2)
+	// after logging in and fetching the home and category pages
+	ts.AfterRequests(4, func(s *ebuckstest.Server) {
+		s.LogEveryoneOut()
+	})
+
+	scraped, err := scrapeWith(ts, WithCredentials(Credentials{Username: "member", Password: "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scraped) != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scraped), len(products))
+	}
+	for _, p := range scraped {
+		if p.MemberLevel != 2 {
+			t.Errorf("product %q was scraped logged out", p.ProdID)
+		}
+	}
+	if ts.Logins() != 2 {
+		t.Errorf("wrong number of logins: got %d expected 2", ts.Logins())
+	}
+}
+
+func TestScraperReusesSavedSessions(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("19", 2))
+	defer ts.Close()
+	ts.AddMember("member", "secret", 1)
+	sessionFile := filepath.Join(t.TempDir(), "sessions.json")
+	creds := WithCredentials(Credentials{Username: "member", Password: "secret"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := scrapeWith(ts, creds, WithSessionFile(sessionFile)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ts.Logins() != 1 {
+		t.Errorf("the second run should reuse the first's session: got %d logins", ts.Logins())
+	}
+	if info, err := os.Stat(sessionFile); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("session file should only be readable by its owner: got %v", info.Mode().Perm())
+	}
+
+	// a session that has timed out is replaced
+	ts.ExpireSessions()
+	if _, err := scrapeWith(ts, creds, WithSessionFile(sessionFile)); err != nil {
+		t.Fatal(err)
+	}
+	if ts.Logins() != 2 {
+		t.Errorf("an expired session should be logged in again: got %d logins", ts.Logins())
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	os.WriteFile(good, []byte(`{"username": "member", "password": "secret"}`), 0600)
+	missing := filepath.Join(dir, "missing.json")
+	os.WriteFile(missing, []byte(`{"username": "member"}`), 0600)
+
+	c, err := LoadCredentials(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "member" || c.Password != "secret" {
+		t.Errorf("wrong credentials: %+v", c)
+	}
+	if _, err := LoadCredentials(missing); err == nil {
+		t.Errorf("credentials without a password should be rejected")
+	}
+
+	t.Setenv(EnvUsername, "member")
+	t.Setenv(EnvPassword, "")
+	if _, ok := CredentialsFromEnv(); ok {
+		t.Errorf("credentials without a password should be rejected")
+	}
+	t.Setenv(EnvPassword, "secret")
+	if c, ok := CredentialsFromEnv(); !ok || c.Username != "member" || c.Password != "secret" {
+		t.Errorf("wrong credentials from environment: %+v", c)
+	}
+}
+
+func scrapeWith(ts *ebuckstest.Server, opts ...Option) ([]Product, error) {
+	m := sync.Mutex{}
+	scraped := []Product{}
+	opts = append([]Option{
+		WithBaseURL(ts.URL),
+		WithLogger(logging.Discard()),
+		WithCallback(func(p Product) {
+			m.Lock()
+			defer m.Unlock()
+			scraped = append(scraped, p)
+		}),
+	}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Start()
+	return scraped, err
+}
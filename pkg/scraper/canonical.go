@@ -0,0 +1,81 @@
+package scraper
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// the session ID the site sometimes puts in paths, e.g. categorySelected.do;jsessionid=E1FECBC2B41C4EBBE86854E78CD8A882?catId=300
+var jsessionidRegex = regexp.MustCompile(`(?i);jsessionid=[^?#]*`)
+
+// the parameters that identify each type of page, in the order the site itself puts them
+var canonicalParams = map[PageType][]string{
+	PageHome:     {},
+	PageCategory: {"catId", "page"},
+	PageProduct:  {"prodId", "catId"},
+	PageDiscount: {"prodId", "catId"},
+}
+
+// CanonicalURL returns the one URL the scraper uses for the page link points at, so that the same page
+// isn't fetched twice under different URLs. Session IDs, fragments and tracking or sort parameters are
+// removed, the identifying parameters are put in a fixed order, page 1 of a category is the category
+// itself, and the scheme and host are lower-cased. Pages of other types only lose their session ID and
+// fragment. A link that can't be parsed is returned unchanged.
+func CanonicalURL(link string) string {
+	u, err := url.Parse(jsessionidRegex.ReplaceAllString(link, ""))
+	if err != nil {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	params, ok := canonicalParams[PageTypeOf(u)]
+	if !ok {
+		return u.String()
+	}
+
+	// ParseQuery returns what it could parse along with an error for the rest
+	q, _ := url.ParseQuery(u.RawQuery)
+	parts := []string{}
+	for _, k := range params {
+		v := firstNonEmpty(q[k])
+		if v == "" || (k == "page" && strings.TrimLeft(v, "0") == "1") {
+			continue
+		}
+		parts = append(parts, k+"="+url.QueryEscape(v))
+	}
+	u.RawQuery = strings.Join(parts, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// discountURL returns the URL of the discount fragment of the product page at productURL,
+// or false if it doesn't have the IDs needed for one
+func discountURL(productURL *url.URL) (string, bool) {
+	q := productURL.Query()
+	if q.Get("prodId") == "" || q.Get("catId") == "" {
+		return "", false
+	}
+	u := *productURL
+	u.Path = path.Join(path.Dir(u.Path), "productSelectedDiscount.do")
+	return CanonicalURL(u.String()), true
+}
+
+// isCategoryPagination says whether link is a later page of a category
+func isCategoryPagination(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && PageTypeOf(u) == PageCategory && u.Query().Get("page") != ""
+}
+
+func firstNonEmpty(vs []string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package scraper
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	const shop = "https://www.ebucks.com/web/shop/"
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		// categories
+		{shop + "categorySelected.do;jsessionid=E1FECBC2B41C4EBBE86854E78CD8A882?catId=300&extraInfo=cellphone_number", shop + "categorySelected.do?catId=300"},
+		{shop + "categorySelected.do?catId=842815916", shop + "categorySelected.do?catId=842815916"},
+		{shop + "categorySelected.do?extraInfo=x&catId=12", shop + "categorySelected.do?catId=12"},
+		{shop + "categorySelected.do", shop + "categorySelected.do"},
+		{shop + "categorySelected.do?catId=300&page=2", shop + "categorySelected.do?catId=300&page=2"},
+		{shop + "categorySelected.do;jsessionid=E1FE?sortBy=price&page=3&catId=300", shop + "categorySelected.do?catId=300&page=3"},
+		{shop + "categorySelected.do?catId=300&page=1", shop + "categorySelected.do?catId=300"},
+		{shop + "categorySelected.do?catId=300&page=01", shop + "categorySelected.do?catId=300"},
+		{shop + "categorySelected.do?catId=300&sortBy=price", shop + "categorySelected.do?catId=300"},
+		{shop + "categorySelected.do?catId=&catId=300", shop + "categorySelected.do?catId=300"},
+
+		// products
+		{shop + "productSelected.do?prodId=1&catId=2", shop + "productSelected.do?prodId=1&catId=2"},
+		{shop + "productSelected.do?catId=2&prodId=1", shop + "productSelected.do?prodId=1&catId=2"},
+		{shop + "productSelected.do;jsessionid=0A1B2C?prodId=1&catId=2", shop + "productSelected.do?prodId=1&catId=2"},
+		{shop + "productSelected.do;JSESSIONID=0A1B2C?prodId=1&catId=2", shop + "productSelected.do?prodId=1&catId=2"},
+		{shop + "productSelected.do?prodId=1&catId=2&utm_source=newsletter&utm_medium=email", shop + "productSelected.do?prodId=1&catId=2"},
+		{shop + "productSelected.do?prodId=1&catId=2#reviews", shop + "productSelected.do?prodId=1&catId=2"},
+		{"HTTPS://WWW.EBUCKS.COM/web/shop/productSelected.do?prodId=1&catId=2", shop + "productSelected.do?prodId=1&catId=2"},
+		{shop + "productSelected.do?prodId=1", shop + "productSelected.do?prodId=1"},
+		{shop + "productSelected.do?prodId=1&catId=2&", shop + "productSelected.do?prodId=1&catId=2"},
+
+		// discount fragments
+		{shop + "productSelectedDiscount.do?catId=2&prodId=1&_=1700000000", shop + "productSelectedDiscount.do?prodId=1&catId=2"},
+		{shop + "productSelectedDiscount.do;jsessionid=0A1B2C?prodId=1&catId=2", shop + "productSelectedDiscount.do?prodId=1&catId=2"},
+
+		// the home page
+		{shop + "shopHome.do", shop + "shopHome.do"},
+		{shop + "shopHome.do;jsessionid=0A1B2C?cmpId=summer", shop + "shopHome.do"},
+
+		// everything else only loses the session and fragment
+		{shop + "search.do;jsessionid=0A1B2C?q=tv&page=2#top", shop + "search.do?q=tv&page=2"},
+		{"https://www.ebucks.com/web/eBucks/about.do", "https://www.ebucks.com/web/eBucks/about.do"},
+
+		// unparseable links are left alone
+		{"http://[::1", "http://[::1"},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalURL(tt.in); got != tt.expected {
+			t.Errorf("CanonicalURL(%q): got %q expected %q", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestDiscountURL(t *testing.T) {
+	u, _ := url.Parse("https://www.ebucks.com/web/shop/productSelected.do?prodId=1&catId=2")
+	if got, ok := discountURL(u); !ok || got != "https://www.ebucks.com/web/shop/productSelectedDiscount.do?prodId=1&catId=2" {
+		t.Errorf("wrong discount URL: got %q", got)
+	}
+
+	u, _ = url.Parse("https://www.ebucks.com/web/shop/productSelected.do?prodId=1")
+	if got, ok := discountURL(u); ok {
+		t.Errorf("product without a catId should have no discount URL: got %q", got)
+	}
+}
+
+func FuzzCanonicalURL(f *testing.F) {
+	f.Add("https://www.ebucks.com/web/shop/categorySelected.do;jsessionid=E1FECBC2B41C4EBBE86854E78CD8A882?catId=300&extraInfo=cellphone_number")
+	f.Add("https://www.ebucks.com/web/shop/productSelected.do?catId=2&prodId=1#reviews")
+	f.Add("https://www.ebucks.com/web/shop/search.do?q=tv")
+	f.Fuzz(func(t *testing.T, s string) {
+		once := CanonicalURL(s)
+		if twice := CanonicalURL(once); twice != once {
+			t.Errorf("CanonicalURL is not idempotent: %q -> %q -> %q", s, once, twice)
+		}
+	})
+}
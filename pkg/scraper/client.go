@@ -0,0 +1,189 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ProductDetail is a product along with its full table of discount tiers.
+type ProductDetail struct {
+	Product
+	Discounts []Discount
+}
+
+// Client fetches individual products and categories on demand, without crawling the whole shop.
+// It uses the same extraction logic as the Scraper.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	sessions   *sessionPool
+	logger     *slog.Logger
+}
+
+// NewClient creates a client for the shop at baseURL (e.g. DefaultBaseURL).
+// httpClient can be nil to use a client with sensible timeouts; its CheckRedirect and Jar are replaced and its
+// Transport is wrapped to give each concurrent request its own session.
+func NewClient(baseURL string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &http.Client{Timeout: 60 * time.Second}
+	if httpClient != nil {
+		copied := *httpClient
+		c = &copied
+	}
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client := &Client{baseURL: u, httpClient: c, logger: slog.Default()}
+	client.sessions = newSessionPool(base, client.url("/web/shop/shopHome.do", "").String(), 0, client.logger)
+	c.Transport = client.sessions
+	c.Jar = nil
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return checkRedirect(client.logger)(req, via)
+	}
+	return client, nil
+}
+
+// SetLogger replaces the logger (default slog.Default()).
+func (c *Client) SetLogger(l *slog.Logger) {
+	c.logger = l
+	c.sessions.logger = l
+}
+
+// SetCredentials logs the client in as a member, so prices are those of the member's level.
+// It must be called before the first request. Like WithCredentials, this is experimental.
+func (c *Client) SetCredentials(creds Credentials) {
+	c.sessions.credentials = &creds
+}
+
+// GetProduct fetches the product page and discount fragment of a single product.
+// Errors wrap ErrRedirectToErrorPage if the site doesn't know the product.
+func (c *Client) GetProduct(ctx context.Context, prodID string, catID string) (ProductDetail, error) {
+	pageURL := c.url("/web/shop/productSelected.do", productQuery(prodID, catID))
+	doc, err := c.get(ctx, pageURL, c.url("/web/shop/categorySelected.do", "catId="+url.QueryEscape(catID)).String())
+	if err != nil {
+		return ProductDetail{}, err
+	}
+	p, err := ExtractProduct(doc, pageURL)
+	if err != nil {
+		return ProductDetail{}, err
+	}
+
+	discounts, err := c.GetDiscounts(ctx, prodID, catID)
+	if err != nil {
+		return ProductDetail{}, err
+	}
+
+	return ProductDetail{Product: ApplyDiscounts(p, discounts), Discounts: discounts}, nil
+}
+
+// GetDiscounts fetches only the discount tiers of a product; there are none if it isn't discounted.
+func (c *Client) GetDiscounts(ctx context.Context, prodID string, catID string) ([]Discount, error) {
+	productURL := c.url("/web/shop/productSelected.do", productQuery(prodID, catID))
+	doc, err := c.get(ctx, c.url("/web/shop/productSelectedDiscount.do", productQuery(prodID, catID)), productURL.String())
+	if err != nil {
+		return nil, err
+	}
+	return ExtractDiscounts(doc), nil
+}
+
+// MemberLevel returns the eBucks level of the member the client is logged in as, 0 without credentials.
+// It is the level ApplyDiscounts picks the tier of.
+func (c *Client) MemberLevel(ctx context.Context) (int, error) {
+	if c.sessions.credentials == nil {
+		return 0, nil
+	}
+	doc, err := c.get(ctx, c.url("/web/shop/shopHome.do", ""), "")
+	if err != nil {
+		return 0, err
+	}
+	level, ok := ExtractMemberLevel(doc)
+	if !ok {
+		return 0, fmt.Errorf("the home page doesn't say who is logged in")
+	}
+	return level, nil
+}
+
+// ListCategories returns the categories linked from the home page.
+func (c *Client) ListCategories(ctx context.Context) ([]CategoryLink, error) {
+	homeURL := c.url("/web/shop/shopHome.do", "")
+	doc, err := c.get(ctx, homeURL, "")
+	if err != nil {
+		return nil, err
+	}
+	return ExtractCategoryLinks(doc, homeURL), nil
+}
+
+// ListCategory returns the listing tiles of every page of a category.
+func (c *Client) ListCategory(ctx context.Context, catID string) ([]ListingTile, error) {
+	tiles := []ListingTile{}
+	pageURL := c.url("/web/shop/categorySelected.do", "catId="+url.QueryEscape(catID))
+	referer := c.url("/web/shop/shopHome.do", "").String()
+	for page := 1; ; page++ {
+		doc, err := c.get(ctx, pageURL, referer)
+		if err != nil {
+			return nil, err
+		}
+		cp := ExtractCategoryPage(doc, pageURL)
+		tiles = append(tiles, cp.Tiles...)
+
+		// a broken next link could go round in circles
+		if cp.Next == "" || page >= cp.Pages {
+			return tiles, nil
+		}
+		referer = pageURL.String()
+		pageURL, err = url.Parse(cp.Next)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) url(path string, rawQuery string) *url.URL {
+	return c.baseURL.ResolveReference(&url.URL{Path: path, RawQuery: rawQuery})
+}
+
+// productQuery keeps the parameter order the site itself uses
+func productQuery(prodID string, catID string) string {
+	return "prodId=" + url.QueryEscape(prodID) + "&catId=" + url.QueryEscape(catID)
+}
+
+// get fetches u as if its link had been followed from the page at referer (empty for none)
+func (c *Client) get(ctx context.Context, u *url.URL, referer string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ScrapeError{URL: u.String(), StatusCode: resp.StatusCode, Err: fmt.Errorf("unexpected status %q", resp.Status)}
+	}
+
+	return goquery.NewDocumentFromReader(bytes.NewReader(body))
+}
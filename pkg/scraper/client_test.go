@@ -0,0 +1,101 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+)
+
+func TestClientGetProduct(t *testing.T) {
+	products := ebuckstest.MakeProducts("7", 3)
+	products[2].Discounts = []int{10, 20}
+	products[2].Price = 500
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := c.GetProduct(context.Background(), "2", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Product 2" || p.ProdID != "2" || p.CatID != "7" {
+		t.Errorf("wrong product: %+v", p)
+	}
+	if len(p.Discounts) != 2 {
+		t.Fatalf("wrong number of discount tiers: got %d expected 2", len(p.Discounts))
+	}
+	if p.Percentage != 20 || p.Price != 400 || p.Savings != 100 {
+		t.Errorf("highest tier not applied: %+v", p.Product)
+	}
+
+	p, err = c.GetProduct(context.Background(), "1", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Discounts) != 0 || p.Price != 1000 {
+		t.Errorf("undiscounted product has wrong details: %+v", p)
+	}
+
+	if _, err := c.GetProduct(context.Background(), "404", "7"); !errors.Is(err, ErrRedirectToErrorPage) {
+		t.Errorf("expected ErrRedirectToErrorPage for unknown product: got %v", err)
+	}
+}
+
+func TestClientListCategory(t *testing.T) {
+	ts := ebuckstest.NewServer(append(ebuckstest.MakeProducts("8", 4), ebuckstest.MakeProducts("9", 2)...))
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := c.ListCategory(context.Background(), "8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 4 {
+		t.Fatalf("wrong number of products: got %d expected 4", len(links))
+	}
+	for _, l := range links {
+		if l.CatID != "8" || l.Name != "Product "+l.ProdID || l.Price < 0 || l.Image == "" {
+			t.Errorf("wrong listing tile: %+v", l)
+		}
+	}
+
+	ts.SetPageSize(3)
+	links, err = c.ListCategory(context.Background(), "8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 4 {
+		t.Errorf("wrong number of products over 2 pages: got %d expected 4", len(links))
+	}
+}
+
+func TestClientListCategories(t *testing.T) {
+	ts := ebuckstest.NewServer(append(ebuckstest.MakeProducts("8", 4), ebuckstest.MakeProducts("9", 2)...))
+	defer ts.Close()
+	ts.SetCategories([]ebuckstest.Category{{CatID: "8", Name: "Garden"}, {CatID: "9", Name: "Kitchen"}})
+
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := c.ListCategories(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("wrong number of categories: got %d expected 2", len(cs))
+	}
+	if cs[0].CatID != "8" || cs[0].Name != "Garden" || cs[0].URL != ts.URL+ebuckstest.CategoryPath+"?catId=8" {
+		t.Errorf("wrong category: %+v", cs[0])
+	}
+}
@@ -0,0 +1,136 @@
+package scraper
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// how many errors Checkpoint remembers
+const maxRecentErrors = 100
+
+// InFlightRequest is a request that has been sent but hasn't been answered yet.
+type InFlightRequest struct {
+	URL     string    `json:"url"`
+	Attempt int       `json:"attempt"`
+	Since   time.Time `json:"since"`
+}
+
+// RecentError is a failed request.
+type RecentError struct {
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status,omitempty"`
+	Error      string    `json:"error"`
+	Retrying   bool      `json:"retrying"`
+	Time       time.Time `json:"time"`
+}
+
+// Checkpoint is a snapshot of a running crawl's state, for debugging a crawl that seems stuck.
+type Checkpoint struct {
+	Time         time.Time         `json:"time"`
+	Paused       bool              `json:"paused"`
+	Pending      []QueuedRequest   `json:"pending"`
+	InFlight     []InFlightRequest `json:"inFlight"`
+	Backoffs     map[string]int    `json:"backoffs"`
+	RecentErrors []RecentError     `json:"recentErrors"`
+}
+
+// debugState keeps track of what Checkpoint reports that the scraper doesn't need itself. It is an Observer.
+type debugState struct {
+	mutex    *sync.Mutex
+	inFlight map[string]InFlightRequest
+	errors   []RecentError
+	// paused is non-nil while the crawl is paused and closed on resume
+	paused chan struct{}
+}
+
+func newDebugState() *debugState {
+	return &debugState{
+		mutex:    &sync.Mutex{},
+		inFlight: make(map[string]InFlightRequest),
+	}
+}
+
+func (d *debugState) Observe(e Event) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	switch e.Kind {
+	case EventRequest:
+		d.inFlight[e.URL] = InFlightRequest{URL: e.URL, Attempt: e.Attempt, Since: e.Time}
+	case EventResponse:
+		delete(d.inFlight, e.URL)
+	case EventError:
+		delete(d.inFlight, e.URL)
+		r := RecentError{URL: e.URL, Attempt: e.Attempt, StatusCode: e.StatusCode, Retrying: e.Retrying, Time: e.Time}
+		if e.Err != nil {
+			r.Error = e.Err.Error()
+		}
+		d.errors = append(d.errors, r)
+		if len(d.errors) > maxRecentErrors {
+			d.errors = d.errors[len(d.errors)-maxRecentErrors:]
+		}
+	}
+}
+
+// Pause stops new requests from being sent until Resume is called. Requests in flight are finished.
+func (s Scraper) Pause() {
+	s.debug.mutex.Lock()
+	defer s.debug.mutex.Unlock()
+	if s.debug.paused == nil {
+		s.debug.paused = make(chan struct{})
+	}
+}
+
+// Resume continues a paused crawl.
+func (s Scraper) Resume() {
+	s.debug.mutex.Lock()
+	defer s.debug.mutex.Unlock()
+	if s.debug.paused != nil {
+		close(s.debug.paused)
+		s.debug.paused = nil
+	}
+}
+
+// waitIfPaused blocks while the crawl is paused, or until it is cancelled
+func (s Scraper) waitIfPaused() {
+	s.debug.mutex.Lock()
+	paused := s.debug.paused
+	s.debug.mutex.Unlock()
+	if paused == nil {
+		return
+	}
+	select {
+	case <-paused:
+	case <-s.sink.ctx.Done():
+	}
+}
+
+// Checkpoint returns a snapshot of the crawl's state. It is safe to call while the crawl runs.
+func (s Scraper) Checkpoint() Checkpoint {
+	c := Checkpoint{
+		Time:     time.Now(),
+		Pending:  s.storage.Pending(),
+		Backoffs: make(map[string]int),
+	}
+
+	s.mutex.Lock()
+	for u, n := range s.urlBackoffs {
+		c.Backoffs[u] = n
+	}
+	s.mutex.Unlock()
+
+	s.debug.mutex.Lock()
+	defer s.debug.mutex.Unlock()
+	c.Paused = s.debug.paused != nil
+	c.InFlight = make([]InFlightRequest, 0, len(s.debug.inFlight))
+	for _, r := range s.debug.inFlight {
+		c.InFlight = append(c.InFlight, r)
+	}
+	sort.Slice(c.InFlight, func(i, j int) bool {
+		return c.InFlight[i].Since.Before(c.InFlight[j].Since)
+	})
+	c.RecentErrors = append([]RecentError{}, s.debug.errors...)
+	return c
+}
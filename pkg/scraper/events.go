@@ -0,0 +1,125 @@
+package scraper
+
+import (
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+)
+
+// PageType is the kind of page a URL points at.
+type PageType string
+
+const (
+	PageHome     PageType = "home"
+	PageCategory PageType = "category"
+	PageProduct  PageType = "product"
+	PageDiscount PageType = "discount"
+	PageOther    PageType = "other"
+)
+
+// PageTypeOf classifies a shop URL by its path.
+func PageTypeOf(u *url.URL) PageType {
+	switch path.Base(u.Path) {
+	case "shopHome.do":
+		return PageHome
+	case "categorySelected.do":
+		return PageCategory
+	case "productSelected.do":
+		return PageProduct
+	case "productSelectedDiscount.do":
+		return PageDiscount
+	default:
+		return PageOther
+	}
+}
+
+type EventKind string
+
+const (
+	// EventStart is sent once before the first request, with Config set.
+	EventStart EventKind = "start"
+	// EventQueued is sent when a newly found URL is added to the queue.
+	EventQueued EventKind = "queued"
+	// EventRequest is sent before every attempt at fetching a URL.
+	EventRequest EventKind = "request"
+	// EventResponse is sent when a URL was fetched successfully.
+	EventResponse EventKind = "response"
+	// EventError is sent when fetching a URL failed. Retrying says whether it will be tried again.
+	EventError EventKind = "error"
+	// EventCategory is sent for every category listing page, with Category set.
+	EventCategory EventKind = "category"
+	// EventProduct is sent for every product that is emitted, with Product set.
+	EventProduct EventKind = "product"
+	// EventFinish is sent once when the crawl is over, with Err set if it failed.
+	EventFinish EventKind = "finish"
+)
+
+// Event describes something that happened during a crawl. Which fields are set depends on Kind.
+type Event struct {
+	Kind     EventKind
+	Time     time.Time
+	URL      string
+	Parent   string
+	PageType PageType
+	// Attempt is 1 for the first try at a URL
+	Attempt    int
+	StatusCode int
+	Bytes      int
+	Duration   time.Duration
+	Err        error
+	Retrying   bool
+	Product    *Product
+	Category   *CategoryPage
+	Config     *RunConfig
+	// Proxies are the stats of each proxy, on EventFinish when the crawl used proxies
+	Proxies []proxy.Stats
+}
+
+// RunConfig is the part of a scraper's configuration that is worth recording with its results.
+type RunConfig struct {
+	BaseURL        string        `json:"baseUrl"`
+	StartURL       string        `json:"startUrl,omitempty"`
+	AllowedDomains []string      `json:"allowedDomains"`
+	Threads        int           `json:"threads"`
+	UserAgent      string        `json:"userAgent"`
+	CacheDir       string        `json:"cacheDir,omitempty"`
+	Delay          time.Duration `json:"delay,omitempty"`
+	RandomDelay    time.Duration `json:"randomDelay,omitempty"`
+	Archive        bool          `json:"archive,omitempty"`
+
+	IncludeCategories []string      `json:"includeCategories,omitempty"`
+	ExcludeCategories []string      `json:"excludeCategories,omitempty"`
+	IncludeNames      []string      `json:"includeNames,omitempty"`
+	ExcludeNames      []string      `json:"excludeNames,omitempty"`
+	MaxDepth          int           `json:"maxDepth,omitempty"`
+	MaxPages          int           `json:"maxPages,omitempty"`
+	TimeBudget        time.Duration `json:"timeBudget,omitempty"`
+
+	// LoggedIn is whether the crawl was done as a member
+	LoggedIn bool `json:"loggedIn,omitempty"`
+	// Proxies is the number of proxies the crawl was spread over
+	Proxies int `json:"proxies,omitempty"`
+}
+
+// Observer is notified of crawl events. Observe is called concurrently from the workers and must not block for long.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+func (s Scraper) observe(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, o := range s.observers {
+		o.Observe(e)
+	}
+}
@@ -0,0 +1,318 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var ErrNotProductPage = errors.New("not a product page")
+var ErrProductIDMismatch = errors.New("prodId or catId mismatch")
+
+// ExtractProduct parses a product page fetched from pageURL.
+// The discount fields are left empty, they come from the discount fragment (see ExtractDiscounts).
+// A price that can't be parsed is returned as -1 rather than as an error. Products bought for an amount of the
+// member's choosing, like airtime vouchers, show a range such as "From R5 to R1 000"; their price is the lower
+// bound, the least they can be bought for.
+func ExtractProduct(doc *goquery.Document, pageURL *url.URL) (Product, error) {
+	form := doc.Find("form[name=productOptionsBean]").First()
+	if form.Length() == 0 {
+		return Product{}, ErrNotProductPage
+	}
+
+	// sanity check: URL IDs must match hidden form inputs otherwise we somehow ended up with the wrong page (?!)
+	urlProdId := pageURL.Query().Get("prodId")
+	urlCatId := pageURL.Query().Get("catId")
+	pid, _ := form.Find("input[name=prodId]").Attr("value")
+	cid, _ := form.Find("input[name=catId]").Attr("value")
+	if pid != urlProdId || cid != urlCatId {
+		return Product{}, fmt.Errorf("%w: pid: (formPID=%q urlPID=%q) cid: (formCID=%q urlCID=%q)", ErrProductIDMismatch, pid, urlProdId, cid, urlCatId)
+	}
+
+	price := float64(-1)
+	if priceString := childText(form, "#randPrice"); priceString != "" {
+		// parseRands takes the first amount, which is the lower bound of a range
+		if f, err := parseRands(priceString); err == nil {
+			price = f
+		}
+	}
+
+	savings := float64(0)
+	if savingsString := childText(form, ".was-price .randValue"); savingsString != "" {
+		if f, err := parseRands(savingsString); err == nil {
+			savings = f
+		}
+	}
+
+	level, _ := ExtractMemberLevel(doc)
+	return Product{
+		URL:         CanonicalURL(pageURL.String()),
+		Name:        childText(form, "h2.product-name"),
+		ProdID:      urlProdId,
+		CatID:       urlCatId,
+		Price:       price,
+		Savings:     savings,
+		MemberLevel: level,
+	}, nil
+}
+
+// ExtractMemberLevel returns the eBucks level of the member a page was shown to, 0 if nobody was logged in.
+// ok is false if the page doesn't say either way, e.g. the discount fragment. The markers it looks for
+// (.member-level[data-level] and a.login) are those of the fake shop in pkg/ebuckstest and haven't been checked
+// against the live site, where ok may well always be false and MemberLevel stay 0.
+func ExtractMemberLevel(doc *goquery.Document) (level int, ok bool) {
+	if s := doc.Find(".member-level[data-level]").First(); s.Length() > 0 {
+		level, err := strconv.Atoi(s.AttrOr("data-level", ""))
+		return level, err == nil
+	}
+	return 0, doc.Find("a.login").Length() > 0
+}
+
+// ExtractDiscounts parses the productSelectedDiscount.do fragment into its discount tiers, lowest level first.
+// It returns no tiers if the product isn't discounted.
+func ExtractDiscounts(doc *goquery.Document) []Discount {
+	discounts := []Discount{}
+	doc.Find("table#discount-table").First().Find("div > table > tbody").Each(func(i int, s *goquery.Selection) {
+		discounts = append(discounts, Discount{
+			Level:         i + 1,
+			Percent:       parsePercentage(childText(s, "p.percentage")),
+			EbucksPrice:   parseEbucksValue(childText(s, "td.col2 > span.eBucksValue")),
+			EbucksSavings: parseEbucksValue(childText(s, "td.col4 > span.eBucksValue")),
+		})
+	})
+	return discounts
+}
+
+// ApplyDiscounts sets the price, savings and percentage of p from the tier of p's member level, or the highest
+// discount tier if it wasn't scraped by a member (or there is no tier for their level).
+// p is returned unchanged if there are no tiers.
+func ApplyDiscounts(p Product, discounts []Discount) Product {
+	if len(discounts) == 0 {
+		return p
+	}
+	discount := discounts[len(discounts)-1]
+	for _, d := range discounts {
+		if p.MemberLevel > 0 && d.Level == p.MemberLevel {
+			discount = d
+		}
+	}
+	p.Percentage = float64(discount.Percent)
+	p.Price = discount.RandPrice()
+	p.Savings = discount.RandSavings()
+	return p
+}
+
+// childText behaves like colly's HTMLElement.ChildText
+func childText(s *goquery.Selection, selector string) string {
+	return strings.TrimSpace(s.Find(selector).Text())
+}
+
+// CategoryLink is a category as linked from the home page.
+type CategoryLink struct {
+	URL   string
+	Name  string
+	CatID string
+}
+
+// ExtractCategoryLinks returns every distinct category linked from a page fetched from pageURL, in page order.
+// Links to later pages of a category aren't counted.
+func ExtractCategoryLinks(doc *goquery.Document, pageURL *url.URL) []CategoryLink {
+	links := []CategoryLink{}
+	seen := map[string]bool{}
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := pageURL.Parse(href)
+		if err != nil || PageTypeOf(u) != PageCategory {
+			return
+		}
+		link := CanonicalURL(u.String())
+		catID := u.Query().Get("catId")
+		if catID == "" || seen[catID] || isCategoryPagination(link) {
+			return
+		}
+		seen[catID] = true
+		links = append(links, CategoryLink{URL: link, Name: strings.TrimSpace(s.Text()), CatID: catID})
+	})
+	return links
+}
+
+// ProductLink is a product as linked from a category page.
+type ProductLink struct {
+	URL    string
+	Name   string
+	ProdID string
+	CatID  string
+}
+
+// ExtractProductLinks returns every distinct product linked from a page fetched from pageURL, in page order.
+func ExtractProductLinks(doc *goquery.Document, pageURL *url.URL) []ProductLink {
+	links := []ProductLink{}
+	seen := map[string]int{}
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := pageURL.Parse(href)
+		if err != nil || !strings.HasSuffix(u.Path, "/productSelected.do") {
+			return
+		}
+
+		q := u.Query()
+		l := ProductLink{
+			URL:    CanonicalURL(u.String()),
+			Name:   strings.TrimSpace(s.Text()),
+			ProdID: q.Get("prodId"),
+			CatID:  q.Get("catId"),
+		}
+		if l.ProdID == "" {
+			return
+		}
+		if i, ok := seen[l.ProdID+"/"+l.CatID]; ok {
+			// products are often linked from an image first, then from their name
+			if links[i].Name == "" {
+				links[i].Name = l.Name
+			}
+			return
+		}
+		seen[l.ProdID+"/"+l.CatID] = len(links)
+		links = append(links, l)
+	})
+	return links
+}
+
+// ListingTile is a product as shown on a category listing page.
+type ListingTile struct {
+	ProductLink
+	// Price is the rand price shown on the tile, -1 if there isn't one
+	Price float64
+	Image string
+}
+
+// CategoryPage is one page of a category's product listing.
+type CategoryPage struct {
+	URL   string
+	CatID string
+	Name  string
+	// Page is numbered from 1, out of Pages
+	Page  int
+	Pages int
+	// ProductCount is the number of products the site says the whole category has, -1 if it doesn't say
+	ProductCount int
+	Tiles        []ListingTile
+	// Next is the URL of the next page, empty on the last one
+	Next string
+}
+
+var digitsRegex = regexp.MustCompile(`\d+`)
+
+// ExtractCategoryPage extracts the listing tiles and pagination of a category page fetched from pageURL.
+// If the page has no tiles, every product it links to is returned as a tile without a price or image.
+//
+// The listing markup (.product-tile, .product-price, .product-count and .pagination a.next) is that of the fake
+// shop in pkg/ebuckstest and hasn't been checked against a saved page from the live site, so nothing depends on
+// it: without tiles the product links are used, without a count ProductCount is -1, and the pages are found from
+// any link to another page of the same category.
+func ExtractCategoryPage(doc *goquery.Document, pageURL *url.URL) CategoryPage {
+	c := CategoryPage{
+		URL:          CanonicalURL(pageURL.String()),
+		CatID:        pageURL.Query().Get("catId"),
+		Name:         childText(doc.Selection, "h1"),
+		Page:         1,
+		Pages:        1,
+		ProductCount: -1,
+		Tiles:        []ListingTile{},
+	}
+	if n, err := strconv.Atoi(pageURL.Query().Get("page")); err == nil && n > 0 {
+		c.Page = n
+	}
+	if m := digitsRegex.FindString(childText(doc.Selection, ".product-count")); m != "" {
+		c.ProductCount, _ = strconv.Atoi(m)
+	}
+
+	seen := map[string]bool{}
+	doc.Find(".product-tile").Each(func(i int, s *goquery.Selection) {
+		link := s.Find("a.product-name[href]")
+		if link.Length() == 0 {
+			link = s.Find("a[href]")
+		}
+		href, _ := link.First().Attr("href")
+		u, err := pageURL.Parse(href)
+		if err != nil {
+			return
+		}
+
+		q := u.Query()
+		t := ListingTile{
+			ProductLink: ProductLink{
+				URL:    CanonicalURL(u.String()),
+				Name:   strings.TrimSpace(link.First().Text()),
+				ProdID: q.Get("prodId"),
+				CatID:  q.Get("catId"),
+			},
+			Price: -1,
+		}
+		if t.ProdID == "" || seen[t.ProdID+"/"+t.CatID] {
+			return
+		}
+		seen[t.ProdID+"/"+t.CatID] = true
+
+		if price, err := parseRands(childText(s, ".product-price")); err == nil {
+			t.Price = price
+		}
+		if src, ok := s.Find("img[src]").First().Attr("src"); ok {
+			if img, err := pageURL.Parse(src); err == nil {
+				t.Image = img.String()
+			}
+		}
+		c.Tiles = append(c.Tiles, t)
+	})
+	if len(c.Tiles) == 0 {
+		for _, l := range ExtractProductLinks(doc, pageURL) {
+			c.Tiles = append(c.Tiles, ListingTile{ProductLink: l, Price: -1})
+		}
+	}
+
+	following := ""
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := pageURL.Parse(href)
+		if err != nil || PageTypeOf(u) != PageCategory || u.Query().Get("catId") != c.CatID {
+			return
+		}
+		n, err := strconv.Atoi(u.Query().Get("page"))
+		if err != nil {
+			return
+		}
+		if n > c.Pages {
+			c.Pages = n
+		}
+		if s.HasClass("next") && s.Closest(".pagination").Length() > 0 {
+			c.Next = CanonicalURL(u.String())
+		} else if n == c.Page+1 && following == "" {
+			following = CanonicalURL(u.String())
+		}
+	})
+	if c.Next == "" {
+		c.Next = following
+	}
+	if c.Page > c.Pages {
+		c.Pages = c.Page
+	}
+	return c
+}
+
+// CompareListing cross-checks a listing tile against the product page it links to (before discounts are applied),
+// returning a description of every difference.
+func CompareListing(t ListingTile, p Product) []string {
+	diffs := []string{}
+	if t.Name != "" && t.Name != p.Name {
+		diffs = append(diffs, fmt.Sprintf("name %q on the listing, %q on the product page", t.Name, p.Name))
+	}
+	if t.Price >= 0 && p.Price >= 0 && t.Price != p.Price {
+		diffs = append(diffs, fmt.Sprintf("price %.2f on the listing, %.2f on the product page", t.Price, p.Price))
+	}
+	return diffs
+}
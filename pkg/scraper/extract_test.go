@@ -0,0 +1,222 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// testdata/product holds product pages named <prodId>-<catId>.html, testdata/category holds category pages
+// named <catId>-<page>.html and testdata/discount holds discount fragments.
+// Each has a .golden.json file next to it with the expected output; run with -update to regenerate them.
+// Every fixture starts with a comment saying where it came from, see testdata/README.md.
+var update = flag.Bool("update", false, "update golden files")
+
+func TestExtractProductGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "product", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no product fixtures found")
+	}
+
+	for _, f := range files {
+		f := f
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			ids := strings.SplitN(strings.TrimSuffix(filepath.Base(f), ".html"), "-", 2)
+			u, _ := url.Parse("https://www.ebucks.com/web/shop/productSelected.do?prodId=" + ids[0] + "&catId=" + ids[1])
+
+			p, err := ExtractProduct(loadDocument(t, f), u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, f, p)
+		})
+	}
+}
+
+func TestExtractDiscountsGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "discount", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no discount fixtures found")
+	}
+
+	for _, f := range files {
+		f := f
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			checkGolden(t, f, ExtractDiscounts(loadDocument(t, f)))
+		})
+	}
+}
+
+func TestExtractCategoryPageGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "category", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no category fixtures found")
+	}
+
+	for _, f := range files {
+		f := f
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			ids := strings.SplitN(strings.TrimSuffix(filepath.Base(f), ".html"), "-", 2)
+			u, _ := url.Parse("https://www.ebucks.com/web/shop/categorySelected.do?catId=" + ids[0] + "&page=" + ids[1])
+
+			checkGolden(t, f, ExtractCategoryPage(loadDocument(t, f), u))
+		})
+	}
+}
+
+func TestExtractCategoryPageWithoutListingMarkup(t *testing.T) {
+	u, _ := url.Parse("https://www.ebucks.com/web/shop/categorySelected.do?catId=300&page=2")
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<a href="/web/shop/categorySelected.do?catId=301&page=3">Other category</a>
+		<div><a href="/web/shop/productSelected.do?prodId=1&catId=300"><img src="/1.jpg" alt="Kettle"></a>
+			<a href="/web/shop/productSelected.do?prodId=1&catId=300">Kettle</a> R 499</div>
+		<div><a href="/web/shop/productSelected.do?prodId=2&catId=300">Toaster</a> R 299</div>
+		<p><a href="/web/shop/categorySelected.do?catId=300&page=1">1</a> 2
+			<a href="/web/shop/categorySelected.do?catId=300&page=3">3</a>
+			<a href="/web/shop/categorySelected.do?catId=300&page=4">4</a></p>
+	</body></html>`))
+
+	c := ExtractCategoryPage(doc, u)
+	if c.Page != 2 || c.Pages != 4 {
+		t.Errorf("wrong pages: got page %d of %d expected 2 of 4", c.Page, c.Pages)
+	}
+	if c.Next != "https://www.ebucks.com/web/shop/categorySelected.do?catId=300&page=3" {
+		t.Errorf("wrong next page: got %q", c.Next)
+	}
+	if c.ProductCount != -1 {
+		t.Errorf("a page that doesn't say how many products there are should have count -1: got %d", c.ProductCount)
+	}
+	if len(c.Tiles) != 2 || c.Tiles[0].Name != "Kettle" || c.Tiles[1].ProdID != "2" {
+		t.Errorf("every linked product should be a tile: %+v", c.Tiles)
+	}
+}
+
+func TestCompareListing(t *testing.T) {
+	tile := ListingTile{ProductLink: ProductLink{Name: "Kettle"}, Price: 100}
+	if diffs := CompareListing(tile, Product{Name: "Kettle", Price: 100}); len(diffs) != 0 {
+		t.Errorf("matching listing should have no differences: %v", diffs)
+	}
+	if diffs := CompareListing(tile, Product{Name: "Kettle 2", Price: 90}); len(diffs) != 2 {
+		t.Errorf("wrong number of differences: got %v expected 2", diffs)
+	}
+	// unknown prices can't be compared
+	if diffs := CompareListing(ListingTile{Price: -1}, Product{Price: 90}); len(diffs) != 0 {
+		t.Errorf("missing listing price should not be a difference: %v", diffs)
+	}
+}
+
+func TestExtractProductErrors(t *testing.T) {
+	u, _ := url.Parse("https://www.ebucks.com/web/shop/productSelected.do?prodId=1&catId=2")
+
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><body><a href="/web/shop/shopHome.do">SHOP</a></body></html>`))
+	if _, err := ExtractProduct(doc, u); !errors.Is(err, ErrNotProductPage) {
+		t.Errorf("expected ErrNotProductPage: got %v", err)
+	}
+
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(`<form name="productOptionsBean">
+		<input type="hidden" name="prodId" value="1"> <input type="hidden" name="catId" value="3">
+	</form>`))
+	if _, err := ExtractProduct(doc, u); !errors.Is(err, ErrProductIDMismatch) {
+		t.Errorf("expected ErrProductIDMismatch: got %v", err)
+	}
+}
+
+func TestExtractProductPriceRangeIsLowerBound(t *testing.T) {
+	u, _ := url.Parse("https://www.ebucks.com/web/shop/productSelected.do?prodId=1199000123&catId=300")
+	doc := loadDocument(t, filepath.Join("testdata", "product", "1199000123-300.html"))
+	if text := strings.TrimSpace(doc.Find("#randPrice").Text()); text != "From R5 to R1 000" {
+		t.Fatalf("fixture should show a price range: got %q", text)
+	}
+
+	p, err := ExtractProduct(doc, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Price != 5 {
+		t.Errorf("price of a range should be its lower bound: got %v expected 5", p.Price)
+	}
+}
+
+func TestApplyDiscountsUsesLastTier(t *testing.T) {
+	p := ApplyDiscounts(Product{Price: 100}, []Discount{
+		{Level: 1, Percent: 10, EbucksPrice: 900, EbucksSavings: 100},
+		{Level: 2, Percent: 40, EbucksPrice: 600, EbucksSavings: 400},
+	})
+	if p.Percentage != 40 || p.Price != 60 || p.Savings != 40 {
+		t.Errorf("wrong discount applied: %+v", p)
+	}
+
+	if p := ApplyDiscounts(Product{Price: 100}, nil); p.Price != 100 || p.Percentage != 0 {
+		t.Errorf("product without discounts should be unchanged: %+v", p)
+	}
+}
+
+// fixtureSources are how a fixture's first line can start
+var fixtureSources = []string{"<!-- captured: ", "<!-- synthetic: "}
+
+func loadDocument(t *testing.T, path string) *goquery.Document {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelled := false
+	for _, prefix := range fixtureSources {
+		labelled = labelled || bytes.HasPrefix(b, []byte(prefix))
+	}
+	if !labelled {
+		t.Fatalf("%s should start with a comment saying whether it was captured or is synthetic", path)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func checkGolden(t *testing.T, fixture string, got interface{}) {
+	t.Helper()
+	goldenPath := strings.TrimSuffix(fixture, ".html") + ".golden.json"
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(got); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if *update {
+		if err := os.WriteFile(goldenPath, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("%s (run with -update to create it)", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("output does not match %s:\ngot:\n%s\nexpected:\n%s", goldenPath, b, expected)
+	}
+}
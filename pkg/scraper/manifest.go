@@ -0,0 +1,282 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+)
+
+// The manifest is a JSON lines file: a "run" record, one "url" record per URL once its fate is known,
+// a "category" record per category listing page, and an "end" record when the crawl is over.
+// A manifest without an end record belongs to a crawl that died.
+const (
+	ManifestRecordRun      = "run"
+	ManifestRecordURL      = "url"
+	ManifestRecordCategory = "category"
+	ManifestRecordEnd      = "end"
+)
+
+// URL outcomes recorded in the manifest.
+const (
+	OutcomeOK          = "ok"
+	OutcomeErrorPage   = "error-page"
+	OutcomeClientError = "client-error"
+	OutcomeFailed      = "failed"
+)
+
+type ManifestRun struct {
+	Type   string    `json:"type"`
+	RunID  string    `json:"runId"`
+	Start  time.Time `json:"start"`
+	Config RunConfig `json:"config"`
+}
+
+type ManifestURL struct {
+	Type     string    `json:"type"`
+	URL      string    `json:"url"`
+	Parent   string    `json:"parent,omitempty"`
+	PageType PageType  `json:"pageType"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"`
+	Bytes    int       `json:"bytes"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+type ManifestCategory struct {
+	Type         string        `json:"type"`
+	URL          string        `json:"url"`
+	CatID        string        `json:"catId"`
+	Name         string        `json:"name"`
+	Page         int           `json:"page"`
+	Pages        int           `json:"pages"`
+	ProductCount int           `json:"productCount"`
+	Tiles        []ListingTile `json:"tiles"`
+	Time         time.Time     `json:"time"`
+}
+
+type ManifestEnd struct {
+	Type     string    `json:"type"`
+	End      time.Time `json:"end"`
+	Products int       `json:"products"`
+	// Errors is the number of pages that still failed after their last retry
+	Errors int    `json:"errors"`
+	Error  string `json:"error,omitempty"`
+	// Proxies are the stats of each proxy the crawl used
+	Proxies []proxy.Stats `json:"proxies,omitempty"`
+	// Outage says why the crawl was stopped, if it was because the site seems to be down
+	Outage *OutageError `json:"outage,omitempty"`
+}
+
+// Manifest is a whole manifest file, see pkg/io to load one.
+type Manifest struct {
+	Run  ManifestRun
+	URLs []ManifestURL
+	// Categories has a record per category page
+	Categories []ManifestCategory
+	// End is nil if the crawl never finished
+	End *ManifestEnd
+}
+
+// ManifestWriter is an Observer that writes a crawl manifest to w.
+type ManifestWriter struct {
+	runID string
+
+	mutex    *sync.Mutex
+	enc      *json.Encoder
+	attempts map[string]int
+	products int
+	errors   int
+	err      error
+}
+
+func NewManifestWriter(w io.Writer, runID string) *ManifestWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &ManifestWriter{
+		runID:    runID,
+		mutex:    &sync.Mutex{},
+		enc:      enc,
+		attempts: make(map[string]int),
+	}
+}
+
+// Err returns the first error encountered writing the manifest.
+func (m *ManifestWriter) Err() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+func (m *ManifestWriter) Observe(e Event) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch e.Kind {
+	case EventStart:
+		m.write(ManifestRun{Type: ManifestRecordRun, RunID: m.runID, Start: e.Time, Config: *e.Config})
+
+	case EventRequest:
+		m.attempts[e.URL] = e.Attempt
+
+	case EventResponse:
+		m.write(ManifestURL{
+			Type:     ManifestRecordURL,
+			URL:      e.URL,
+			Parent:   e.Parent,
+			PageType: e.PageType,
+			Attempts: m.attempts[e.URL],
+			Status:   e.StatusCode,
+			Bytes:    e.Bytes,
+			Outcome:  OutcomeOK,
+			Time:     e.Time,
+		})
+
+	case EventError:
+		if e.Retrying {
+			return
+		}
+		m.errors++
+		outcome := OutcomeFailed
+		switch {
+		case errors.Is(e.Err, ErrRedirectToErrorPage):
+			outcome = OutcomeErrorPage
+		case e.StatusCode >= 400 && e.StatusCode < 500:
+			outcome = OutcomeClientError
+		}
+		errString := ""
+		if e.Err != nil {
+			errString = e.Err.Error()
+		}
+		m.write(ManifestURL{
+			Type:     ManifestRecordURL,
+			URL:      e.URL,
+			Parent:   e.Parent,
+			PageType: e.PageType,
+			Attempts: m.attempts[e.URL],
+			Status:   e.StatusCode,
+			Bytes:    e.Bytes,
+			Outcome:  outcome,
+			Error:    errString,
+			Time:     e.Time,
+		})
+
+	case EventCategory:
+		c := e.Category
+		m.write(ManifestCategory{
+			Type:         ManifestRecordCategory,
+			URL:          c.URL,
+			CatID:        c.CatID,
+			Name:         c.Name,
+			Page:         c.Page,
+			Pages:        c.Pages,
+			ProductCount: c.ProductCount,
+			Tiles:        c.Tiles,
+			Time:         e.Time,
+		})
+
+	case EventProduct:
+		m.products++
+
+	case EventFinish:
+		end := ManifestEnd{Type: ManifestRecordEnd, End: e.Time, Products: m.products, Errors: m.errors, Proxies: e.Proxies}
+		if e.Err != nil {
+			end.Error = e.Err.Error()
+		}
+		var outage *OutageError
+		if errors.As(e.Err, &outage) {
+			end.Outage = outage
+		}
+		m.write(end)
+	}
+}
+
+func (m *ManifestWriter) write(v interface{}) {
+	if m.err != nil {
+		return
+	}
+	m.err = m.enc.Encode(v)
+}
+
+// CategoryCompleteness compares what the site says a category has with what was scraped.
+type CategoryCompleteness struct {
+	CatID string
+	Name  string
+	// Expected is the product count the category page shows, -1 if it doesn't show one
+	Expected int
+	// Listed is the number of distinct products on the category's pages
+	Listed int
+	// Scraped is the number of the category's products whose discount fragment was fetched
+	Scraped int
+	// PagesSeen and Pages say whether every page of the listing was fetched
+	PagesSeen int
+	Pages     int
+}
+
+// Complete says whether everything the category lists was scraped.
+func (c CategoryCompleteness) Complete() bool {
+	return c.PagesSeen >= c.Pages && c.Scraped >= c.Listed && (c.Expected < 0 || c.Listed >= c.Expected)
+}
+
+// Completeness returns the completeness of every category in the manifest, by catId.
+func (m Manifest) Completeness() []CategoryCompleteness {
+	byID := map[string]*CategoryCompleteness{}
+	ids := []string{}
+	listed := map[string]map[string]bool{}
+	pages := map[string]map[int]bool{}
+
+	for _, c := range m.Categories {
+		cc, ok := byID[c.CatID]
+		if !ok {
+			cc = &CategoryCompleteness{CatID: c.CatID, Name: c.Name, Expected: -1}
+			byID[c.CatID] = cc
+			ids = append(ids, c.CatID)
+			listed[c.CatID] = map[string]bool{}
+			pages[c.CatID] = map[int]bool{}
+		}
+		if c.ProductCount > cc.Expected {
+			cc.Expected = c.ProductCount
+		}
+		if c.Pages > cc.Pages {
+			cc.Pages = c.Pages
+		}
+		pages[c.CatID][c.Page] = true
+		for _, t := range c.Tiles {
+			listed[c.CatID][t.ProdID] = true
+		}
+	}
+
+	scraped := map[string]map[string]bool{}
+	for _, u := range m.URLs {
+		if u.PageType != PageDiscount || u.Outcome != OutcomeOK {
+			continue
+		}
+		parsed, err := url.Parse(u.URL)
+		if err != nil {
+			continue
+		}
+		q := parsed.Query()
+		if scraped[q.Get("catId")] == nil {
+			scraped[q.Get("catId")] = map[string]bool{}
+		}
+		scraped[q.Get("catId")][q.Get("prodId")] = true
+	}
+
+	sort.Strings(ids)
+	cs := []CategoryCompleteness{}
+	for _, id := range ids {
+		cc := byID[id]
+		cc.Listed = len(listed[id])
+		cc.Scraped = len(scraped[id])
+		cc.PagesSeen = len(pages[id])
+		cs = append(cs, *cc)
+	}
+	return cs
+}
@@ -0,0 +1,335 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+	"github.com/geniass/ebucks-dealz/pkg/warc"
+)
+
+// Option configures a Scraper created with New.
+type Option func(c *config) error
+
+type config struct {
+	baseURL        string
+	startURL       string
+	maxRetries     int
+	allowedDomains []string
+	transport      http.RoundTripper
+	userAgent      string
+	headers        http.Header
+	threads        int
+	limits         bool
+	delay          time.Duration
+	randomDelay    time.Duration
+	cacheDir       string
+	observers      []Observer
+	callback       ProductPageCallbackFunc
+	archive        *warc.Writer
+	logger         *slog.Logger
+
+	includeCategories []string
+	excludeCategories []string
+	includeNames      []string
+	excludeNames      []string
+	maxDepth          int
+	maxPages          int
+	timeBudget        time.Duration
+
+	credentials *Credentials
+	sessionFile string
+	proxies     *proxy.Pool
+
+	outageSample    int
+	outageThreshold float64
+}
+
+func defaultConfig() config {
+	return config{
+		baseURL:    DefaultBaseURL,
+		userAgent:  userAgent,
+		threads:    1,
+		maxRetries: DefaultMaxRetries,
+		logger:     slog.Default(),
+
+		outageSample:    DefaultOutageSample,
+		outageThreshold: DefaultOutageThreshold,
+		transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   300 * time.Second,
+				KeepAlive: 300 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       900 * time.Second,
+			TLSHandshakeTimeout:   300 * time.Second,
+			ExpectContinueTimeout: 100 * time.Second,
+			ResponseHeaderTimeout: 300 * time.Second,
+		},
+	}
+}
+
+// WithBaseURL sets the shop to crawl (default DefaultBaseURL), an http or https URL. The crawl starts at its
+// shopHome.do page and, unless WithAllowedDomains is given, is restricted to its host.
+func WithBaseURL(baseURL string) Option {
+	return func(c *config) error {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("base URL %q is not an http or https URL", baseURL)
+		}
+		c.baseURL = baseURL
+		return nil
+	}
+}
+
+// WithStartURL starts the crawl at u, e.g. a category page, instead of the home page, which is then not crawled;
+// u has to be within the allowed domains. Sessions are still set up, and members logged in, through the home page.
+func WithStartURL(u string) Option {
+	return func(c *config) error {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		if !parsed.IsAbs() {
+			return fmt.Errorf("start URL %q is not absolute", u)
+		}
+		c.startURL = u
+		return nil
+	}
+}
+
+// WithMaxRetries gives up on a page that failed with a server or network error after retrying it n times, with
+// exponential backoff (default 5). Pages redirected to the error page or failing with 4xx are never retried.
+func WithMaxRetries(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max retries %d", n)
+		}
+		c.maxRetries = n
+		return nil
+	}
+}
+
+// WithAllowedDomains restricts the crawl to the given domains. No domains means any domain is allowed.
+func WithAllowedDomains(domains ...string) Option {
+	return func(c *config) error {
+		c.allowedDomains = domains
+		if c.allowedDomains == nil {
+			c.allowedDomains = []string{}
+		}
+		return nil
+	}
+}
+
+// WithTransport replaces the default http.Transport, e.g. to change timeouts or to stub out the network in tests.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *config) error {
+		c.transport = rt
+		return nil
+	}
+}
+
+// WithUserAgent overrides the default User-Agent header.
+func WithUserAgent(ua string) Option {
+	return func(c *config) error {
+		c.userAgent = ua
+		return nil
+	}
+}
+
+// WithHeaders adds headers to every request, on top of the ones the site expects.
+func WithHeaders(h http.Header) Option {
+	return func(c *config) error {
+		c.headers = h.Clone()
+		return nil
+	}
+}
+
+// WithThreads sets the number of concurrent workers (default 1).
+func WithThreads(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			n = 1
+		}
+		c.threads = n
+		return nil
+	}
+}
+
+// WithLimits waits delay plus up to randomDelay between requests of each worker, to go easy on the site.
+func WithLimits(delay time.Duration, randomDelay time.Duration) Option {
+	return func(c *config) error {
+		c.limits = true
+		c.delay = delay
+		c.randomDelay = randomDelay
+		return nil
+	}
+}
+
+// WithCacheDir caches responses in dir; empty disables caching (the default).
+func WithCacheDir(dir string) Option {
+	return func(c *config) error {
+		c.cacheDir = dir
+		return nil
+	}
+}
+
+// WithObserver notifies o of every crawl event. It can be given more than once.
+func WithObserver(o Observer) Option {
+	return func(c *config) error {
+		c.observers = append(c.observers, o)
+		return nil
+	}
+}
+
+// WithCallback calls f for every scraped product. It is called concurrently from the workers.
+func WithCallback(f ProductPageCallbackFunc) Option {
+	return func(c *config) error {
+		c.callback = f
+		return nil
+	}
+}
+
+// WithArchive records every request and response that goes over the network into w.
+// Responses served from the cache dir are not archived.
+func WithArchive(w *warc.Writer) Option {
+	return func(c *config) error {
+		c.archive = w
+		return nil
+	}
+}
+
+// WithLogger sets the logger for crawl progress and problems (default slog.Default()).
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) error {
+		c.logger = l
+		return nil
+	}
+}
+
+// WithCategories only crawls the categories with the given IDs (catId), and their products.
+func WithCategories(ids ...string) Option {
+	return func(c *config) error {
+		c.includeCategories = append(c.includeCategories, ids...)
+		return nil
+	}
+}
+
+// WithoutCategories skips the categories with the given IDs (catId), and their products.
+func WithoutCategories(ids ...string) Option {
+	return func(c *config) error {
+		c.excludeCategories = append(c.excludeCategories, ids...)
+		return nil
+	}
+}
+
+// WithCategoryNames only crawls categories whose link text matches one of the regular expressions.
+func WithCategoryNames(patterns ...string) Option {
+	return func(c *config) error {
+		if _, err := compilePatterns(patterns); err != nil {
+			return err
+		}
+		c.includeNames = append(c.includeNames, patterns...)
+		return nil
+	}
+}
+
+// WithoutCategoryNames skips categories whose link text matches one of the regular expressions.
+func WithoutCategoryNames(patterns ...string) Option {
+	return func(c *config) error {
+		if _, err := compilePatterns(patterns); err != nil {
+			return err
+		}
+		c.excludeNames = append(c.excludeNames, patterns...)
+		return nil
+	}
+}
+
+// WithMaxDepth only follows links up to n links away from the home page, e.g. 1 for just the categories
+// and 2 for the categories and their products. 0 (the default) means no limit.
+func WithMaxDepth(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max depth %d", n)
+		}
+		c.maxDepth = n
+		return nil
+	}
+}
+
+// WithMaxPages stops following links after n pages. Discount fragments don't count. 0 (the default) means no limit.
+func WithMaxPages(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max pages %d", n)
+		}
+		c.maxPages = n
+		return nil
+	}
+}
+
+// WithTimeBudget winds the crawl down after d: no new pages are requested, the products already
+// in flight are finished, and the crawl ends normally with what it has. 0 (the default) means no limit.
+func WithTimeBudget(d time.Duration) Option {
+	return func(c *config) error {
+		c.timeBudget = d
+		return nil
+	}
+}
+
+// WithCredentials logs every worker's session in as a member, so prices are those of the member's level.
+// The crawl fails straight away, with an error wrapping ErrLoginFailed, if the credentials don't work.
+// This is experimental: the login flow and the markers of who is logged in are those of the fake shop in
+// pkg/ebuckstest, and haven't been checked against the live site.
+func WithCredentials(creds Credentials) Option {
+	return func(c *config) error {
+		if creds.Username == "" || creds.Password == "" {
+			return errors.New("username and password are required")
+		}
+		c.credentials = &creds
+		return nil
+	}
+}
+
+// WithSessionFile saves the cookies of logged-in sessions to path and reuses them in later runs rather than
+// logging in again. It only applies with WithCredentials. The file holds live session cookies, so it is
+// only readable by its owner.
+func WithSessionFile(path string) Option {
+	return func(c *config) error {
+		c.sessionFile = path
+		return nil
+	}
+}
+
+// WithProxies sends every request through one of the pool's proxies, checking their health every minute
+// while the crawl runs. Their stats are recorded at the end of the run. It needs the transport to be an
+// *http.Transport, like the default.
+func WithProxies(p *proxy.Pool) Option {
+	return func(c *config) error {
+		c.proxies = p
+		return nil
+	}
+}
+
+// WithOutageDetection stops the crawl with an *OutageError if at least threshold (e.g. 0.5) of the first sample
+// requests are redirected to the error page or fail with 5xx or a network error, which is what happens when the
+// site is down. The crawl is also stopped if the home page can't be fetched. The default is 20 requests and 0.5;
+// a sample of 0 only checks the home page.
+func WithOutageDetection(sample int, threshold float64) Option {
+	return func(c *config) error {
+		if sample < 0 || threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("invalid outage detection: sample %d, threshold %g", sample, threshold)
+		}
+		c.outageSample = sample
+		c.outageThreshold = threshold
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrSiteUnavailable is wrapped by the error of a crawl that was stopped because the site seems to be down.
+var ErrSiteUnavailable = errors.New("site unavailable")
+
+// Why the site was judged to be down, as given in OutageError.Reason.
+const (
+	// OutageHomePage is when the home page couldn't be fetched, even after retrying
+	OutageHomePage = "home-page-failed"
+	// OutageErrorRate is when too many of the first requests were redirected to the error page or failed with 5xx
+	OutageErrorRate = "error-rate"
+)
+
+// The defaults of WithOutageDetection.
+const (
+	DefaultOutageSample    = 20
+	DefaultOutageThreshold = 0.5
+)
+
+// OutageError is the error of a crawl that was stopped because the site seems to be down, e.g. for maintenance.
+// It wraps ErrSiteUnavailable, and is recorded in the manifest's end record.
+type OutageError struct {
+	Reason string `json:"reason"`
+	// Requests and Failures are the requests made before the crawl was stopped, and how many of them failed
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+	// LastError is the error of the last failed request
+	LastError string `json:"lastError,omitempty"`
+}
+
+func (e *OutageError) Error() string {
+	if e.Reason == OutageHomePage {
+		return fmt.Sprintf("site unavailable: home page failed: %s", e.LastError)
+	}
+	return fmt.Sprintf("site unavailable: %d of the first %d requests failed, the last with: %s", e.Failures, e.Requests, e.LastError)
+}
+
+func (e *OutageError) Unwrap() error {
+	return ErrSiteUnavailable
+}
+
+// outageDetector is an Observer that stops the crawl when the home page fails, or when at least threshold of the
+// first sample requests fail the way they do when the site is down
+type outageDetector struct {
+	sample    int
+	threshold float64
+
+	mutex    *sync.Mutex
+	requests int
+	failures int
+	lastErr  string
+	outage   *OutageError
+	// stop is called once when an outage is detected
+	stop func()
+}
+
+func (d *outageDetector) Observe(e Event) {
+	if e.Kind != EventResponse && e.Kind != EventError {
+		return
+	}
+	if errors.Is(e.Err, context.Canceled) {
+		// the crawl is being stopped, which says nothing about the site
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.outage != nil {
+		return
+	}
+
+	d.requests++
+	if e.Kind == EventError && outageLike(e) {
+		d.failures++
+		if e.Err != nil {
+			d.lastErr = e.Err.Error()
+		}
+	}
+
+	switch {
+	case e.Kind == EventError && e.PageType == PageHome && !e.Retrying:
+		lastErr := ""
+		if e.Err != nil {
+			lastErr = e.Err.Error()
+		}
+		d.detect(OutageHomePage, lastErr)
+	case d.sample > 0 && d.requests == d.sample && float64(d.failures) >= d.threshold*float64(d.sample):
+		d.detect(OutageErrorRate, d.lastErr)
+	}
+}
+
+// setStop sets what stops the crawl
+func (d *outageDetector) setStop(stop func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stop = stop
+}
+
+// detect records the outage and stops the crawl; the mutex must be held
+func (d *outageDetector) detect(reason string, lastErr string) {
+	d.outage = &OutageError{Reason: reason, Requests: d.requests, Failures: d.failures, LastError: lastErr}
+	if d.stop != nil {
+		d.stop()
+	}
+}
+
+// err returns the outage, if one was detected
+func (d *outageDetector) err() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.outage == nil {
+		return nil
+	}
+	return d.outage
+}
+
+// outageLike says whether a failed request failed the way requests do when the site is down, rather than
+// because of something wrong with that page
+func outageLike(e Event) bool {
+	return errors.Is(e.Err, ErrRedirectToErrorPage) || e.StatusCode >= 500 || e.StatusCode == 0
+}
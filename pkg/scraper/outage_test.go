@@ -0,0 +1,130 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func TestScraperDetectsBrokenHomePage(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("1", 5))
+	defer ts.Close()
+	// maintenance: everything redirects to the error page
+	ts.Break(func(r *http.Request) bool { return true })
+
+	var manifest bytes.Buffer
+	_, err := scrapeWith(ts, WithObserver(NewManifestWriter(&manifest, "test")))
+	var outage *OutageError
+	if !errors.As(err, &outage) || !errors.Is(err, ErrSiteUnavailable) {
+		t.Fatalf("crawl should fail with an outage: got %v", err)
+	}
+	if outage.Reason != OutageHomePage || outage.Requests != 1 || outage.Failures != 1 {
+		t.Errorf("wrong outage: %+v", outage)
+	}
+
+	lines := strings.Split(strings.TrimSpace(manifest.String()), "\n")
+	var end ManifestEnd
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &end); err != nil {
+		t.Fatal(err)
+	}
+	if end.Outage == nil || end.Outage.Reason != OutageHomePage {
+		t.Errorf("manifest should record the outage: %s", lines[len(lines)-1])
+	}
+}
+
+func TestStreamReportsOutage(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("1", 5))
+	defer ts.Close()
+	ts.Break(func(r *http.Request) bool { return true })
+
+	s, err := New(WithBaseURL(ts.URL), WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, errs := s.Stream(context.Background())
+	var outage *OutageError
+	for ps != nil || errs != nil {
+		select {
+		case _, ok := <-ps:
+			if !ok {
+				ps = nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			errors.As(err, &outage)
+		}
+	}
+	if outage == nil {
+		t.Fatal("the outage should be the last error")
+	}
+}
+
+func TestScraperDetectsErrorRate(t *testing.T) {
+	products := []ebuckstest.Product{}
+	for _, catID := range []string{"1", "2", "3", "4", "5", "6"} {
+		products = append(products, ebuckstest.MakeProducts(catID, 2)...)
+	}
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.Break(func(r *http.Request) bool { return r.URL.Path != ebuckstest.HomePath })
+
+	scraped, err := scrapeWith(ts, WithOutageDetection(4, 0.5))
+	var outage *OutageError
+	if !errors.As(err, &outage) {
+		t.Fatalf("crawl should fail with an outage: got %v", err)
+	}
+	if outage.Reason != OutageErrorRate || outage.Requests != 4 || outage.Failures != 3 || outage.LastError == "" {
+		t.Errorf("wrong outage: %+v", outage)
+	}
+	if len(scraped) != 0 {
+		t.Errorf("nothing should be scraped: got %d products", len(scraped))
+	}
+	if ts.RequestCount() > 6 {
+		t.Errorf("crawl should stop soon after the outage: got %d requests", ts.RequestCount())
+	}
+}
+
+func TestScraperToleratesSomeBrokenPages(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 10)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.BreakProduct("1")
+	ts.BreakProduct("2")
+
+	scraped, err := scrapeWith(ts, WithOutageDetection(5, 0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scraped) != len(products)-2 {
+		t.Errorf("wrong number of products: got %d expected %d", len(scraped), len(products)-2)
+	}
+}
+
+func TestScraperMaxRetries(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("1", 2))
+	defer ts.Close()
+	ts.FailNext(1, http.StatusServiceUnavailable)
+
+	start := time.Now()
+	_, err := scrapeWith(ts, WithMaxRetries(0))
+	if !errors.Is(err, ErrSiteUnavailable) {
+		t.Fatalf("crawl should fail when the home page isn't retried: got %v", err)
+	}
+	if ts.RequestCount() != 1 {
+		t.Errorf("home page should not be retried: got %d requests", ts.RequestCount())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("crawl should not back off without retries: took %s", elapsed)
+	}
+}
@@ -0,0 +1,145 @@
+package scraper
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseRands(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected float64
+		err      bool
+	}{
+		{"R4 499.00", 4499, false},
+		{"R 2 000.50", 2000.5, false},
+		{"R12 999.50", 12999.5, false},
+		{"Pay R75", 75, false},
+		{"R1 234 567.89", 1234567.89, false},
+		{"From R5 to R1 000", 5, false},
+		{"", 0, true},
+		{"eB100", 0, true},
+		{"R", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRands(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseRands(%q): unexpected error value: %v", tt.in, err)
+			continue
+		}
+		if !tt.err && got != tt.expected {
+			t.Errorf("parseRands(%q): got %f expected %f", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestParseEbucksValue(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected int
+	}{
+		{"eB44 990", 44990},
+		{"eB750", 750},
+		{"eB 1 000", 1000},
+		{"750", 750},
+		{"", -1},
+		{"eB", -1},
+		{"R75.00", -1},
+	}
+
+	for _, tt := range tests {
+		if got := parseEbucksValue(tt.in); got != tt.expected {
+			t.Errorf("parseEbucksValue(%q): got %d expected %d", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestParsePercentage(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected int
+	}{
+		{"40%", 40},
+		{"5%", 5},
+		{"40", 40},
+		{"", 0},
+		{"%", 0},
+		{"forty%", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parsePercentage(tt.in); got != tt.expected {
+			t.Errorf("parsePercentage(%q): got %d expected %d", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func FuzzParseRands(f *testing.F) {
+	for _, s := range []string{"R4 499.00", "R 2 000.50", "From R5 to R1 000", "", "R"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := parseRands(s)
+		if err != nil {
+			return
+		}
+		if v < 0 {
+			t.Errorf("parseRands(%q) = %f: should never be negative", s, v)
+		}
+		if !strings.Contains(s, "R") {
+			t.Errorf("parseRands(%q) = %f: parsed a value without an R", s, v)
+		}
+	})
+}
+
+func FuzzParseRandsRoundTrip(f *testing.F) {
+	f.Add(uint32(449900))
+	f.Add(uint32(0))
+	f.Fuzz(func(t *testing.T, cents uint32) {
+		s := strconv.FormatFloat(float64(cents)/100, 'f', 2, 64)
+		in := "R" + groupDigits(s[:len(s)-3]) + s[len(s)-3:]
+		v, err := parseRands(in)
+		if err != nil {
+			t.Fatalf("parseRands(%q): %s", in, err)
+		}
+		if v != float64(cents)/100 {
+			t.Errorf("parseRands(%q): got %f expected %f", in, v, float64(cents)/100)
+		}
+	})
+}
+
+func FuzzParseEbucksValue(f *testing.F) {
+	for _, s := range []string{"eB44 990", "eB750", "", "eB"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if v := parseEbucksValue(s); v < -1 && !strings.Contains(s, "-") {
+			t.Errorf("parseEbucksValue(%q) = %d: negative value without a minus sign", s, v)
+		}
+	})
+}
+
+func FuzzParsePercentage(f *testing.F) {
+	for _, s := range []string{"40%", "", "%"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		v := parsePercentage(s)
+		if v != 0 && strings.Trim(s, "%") == "" {
+			t.Errorf("parsePercentage(%q) = %d: expected 0", s, v)
+		}
+	})
+}
+
+func groupDigits(digits string) string {
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
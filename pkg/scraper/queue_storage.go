@@ -0,0 +1,136 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sync"
+)
+
+var (
+	// ErrAlreadyQueued is returned when adding a URL that has been queued before.
+	ErrAlreadyQueued = errors.New("URL already queued")
+	// ErrQueueEmpty is returned by GetRequest when there is nothing queued.
+	ErrQueueEmpty = errors.New("queue is empty")
+)
+
+// priorities of the page types in the queue, lower comes first
+const (
+	priorityProduct = iota
+	priorityCategory
+	priorityOther
+	numPriorities
+)
+
+// PriorityQueueStorage is a storage backend for the colly queue that hands out product pages before
+// category pages (and those before anything else), so products come out early and the queue doesn't
+// fill up with every category's products at once. Within a priority the most recently added request
+// comes first. A URL is only ever queued once. It is safe for concurrent use.
+type PriorityQueueStorage struct {
+	lock   *sync.Mutex
+	stacks [numPriorities][]queuedRequest
+	size   int
+	seen   map[string]bool
+}
+
+// queuedRequest is a request serialized by colly, along with the parts of it we need
+type queuedRequest struct {
+	data []byte
+	QueuedRequest
+}
+
+// QueuedRequest is a request waiting in the queue.
+type QueuedRequest struct {
+	URL    string `json:"url"`
+	Parent string `json:"parent,omitempty"`
+	Depth  int    `json:"depth"`
+}
+
+func (s *PriorityQueueStorage) Init() error {
+	s.lock = &sync.Mutex{}
+	s.seen = make(map[string]bool)
+	return nil
+}
+
+func (s *PriorityQueueStorage) AddRequest(data []byte) error {
+	// the format colly serializes requests in
+	var r struct {
+		URL   string
+		Depth int
+		Ctx   map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return err
+	}
+	parent, _ := r.Ctx[ctxParentKey].(string)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.seen[r.URL] {
+		return ErrAlreadyQueued
+	}
+	s.seen[r.URL] = true
+
+	p := priorityOf(PageTypeOf(u))
+	s.stacks[p] = append(s.stacks[p], queuedRequest{
+		data:          data,
+		QueuedRequest: QueuedRequest{URL: r.URL, Parent: parent, Depth: r.Depth},
+	})
+	s.size++
+	return nil
+}
+
+func (s *PriorityQueueStorage) GetRequest() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for p := range s.stacks {
+		n := len(s.stacks[p])
+		if n == 0 {
+			continue
+		}
+		r := s.stacks[p][n-1]
+		// don't keep the request alive through the backing array
+		s.stacks[p][n-1] = queuedRequest{}
+		s.stacks[p] = s.stacks[p][:n-1]
+		s.size--
+		return r.data, nil
+	}
+	return nil, ErrQueueEmpty
+}
+
+func (s *PriorityQueueStorage) QueueSize() (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size, nil
+}
+
+// Pending returns the queued requests in the order they will be made.
+func (s *PriorityQueueStorage) Pending() []QueuedRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pending := make([]QueuedRequest, 0, s.size)
+	for p := range s.stacks {
+		for i := len(s.stacks[p]) - 1; i >= 0; i-- {
+			pending = append(pending, s.stacks[p][i].QueuedRequest)
+		}
+	}
+	return pending
+}
+
+func priorityOf(t PageType) int {
+	switch t {
+	case PageProduct, PageDiscount:
+		return priorityProduct
+	case PageCategory:
+		return priorityCategory
+	default:
+		return priorityOther
+	}
+}
@@ -0,0 +1,141 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func serializedRequest(t testing.TB, link string) []byte {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &colly.Request{URL: u, Method: "GET", Ctx: colly.NewContext()}
+	r.Ctx.Put(ctxParentKey, "http://example.com/parent")
+	data, err := r.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPriorityQueueStorageOrder(t *testing.T) {
+	s := &PriorityQueueStorage{}
+	s.Init()
+
+	links := []string{
+		"http://example.com/web/shop/shopHome.do",
+		"http://example.com/web/shop/categorySelected.do?catId=1",
+		"http://example.com/web/shop/productSelected.do?prodId=1&catId=1",
+		"http://example.com/web/shop/categorySelected.do?catId=2",
+		"http://example.com/web/shop/productSelected.do?prodId=2&catId=1",
+	}
+	for _, l := range links {
+		if err := s.AddRequest(serializedRequest(t, l)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending := s.Pending()
+	if pending[0].Parent != "http://example.com/parent" {
+		t.Errorf("wrong parent: got %q", pending[0].Parent)
+	}
+
+	expected := []string{links[4], links[2], links[3], links[1], links[0]}
+	for i, e := range expected {
+		if pending[i].URL != e {
+			t.Errorf("Pending()[%d]: got %q expected %q", i, pending[i].URL, e)
+		}
+
+		data, err := s.GetRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := colly.NewCollector().UnmarshalRequest(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.URL.String() != e {
+			t.Errorf("request %d: got %q expected %q", i, r.URL, e)
+		}
+	}
+
+	if _, err := s.GetRequest(); !errors.Is(err, ErrQueueEmpty) {
+		t.Errorf("empty queue: got %v expected ErrQueueEmpty", err)
+	}
+	if n, _ := s.QueueSize(); n != 0 {
+		t.Errorf("size: got %d expected 0", n)
+	}
+}
+
+func TestPriorityQueueStorageDedup(t *testing.T) {
+	s := &PriorityQueueStorage{}
+	s.Init()
+
+	link := "http://example.com/web/shop/categorySelected.do?catId=1"
+	if err := s.AddRequest(serializedRequest(t, link)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRequest(serializedRequest(t, link)); !errors.Is(err, ErrAlreadyQueued) {
+		t.Errorf("second add: got %v expected ErrAlreadyQueued", err)
+	}
+	s.GetRequest()
+	// still a duplicate once it has left the queue
+	if err := s.AddRequest(serializedRequest(t, link)); !errors.Is(err, ErrAlreadyQueued) {
+		t.Errorf("add after get: got %v expected ErrAlreadyQueued", err)
+	}
+	if n, _ := s.QueueSize(); n != 0 {
+		t.Errorf("size: got %d expected 0", n)
+	}
+}
+
+func TestPriorityQueueStorageConcurrent(t *testing.T) {
+	s := &PriorityQueueStorage{}
+	s.Init()
+
+	const workers = 8
+	const perWorker = 200
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				// every URL is added by two workers
+				link := fmt.Sprintf("http://example.com/web/shop/productSelected.do?prodId=%d&catId=%d", i, w/2)
+				err := s.AddRequest(serializedRequest(t, link))
+				if err != nil && !errors.Is(err, ErrAlreadyQueued) {
+					t.Error(err)
+				}
+				s.QueueSize()
+				s.Pending()
+			}
+		}(w)
+	}
+
+	got := make(chan int)
+	go func() {
+		n := 0
+		for n < workers/2*perWorker {
+			if _, err := s.GetRequest(); err == nil {
+				n++
+			}
+		}
+		got <- n
+	}()
+
+	wg.Wait()
+	if n := <-got; n != workers/2*perWorker {
+		t.Errorf("wrong number of requests: got %d expected %d", n, workers/2*perWorker)
+	}
+	if n, _ := s.QueueSize(); n != 0 {
+		t.Errorf("size: got %d expected 0", n)
+	}
+}
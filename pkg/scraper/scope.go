@@ -0,0 +1,126 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrOutOfScope is returned when a link isn't followed because of the crawl's scope or budget.
+var ErrOutOfScope = errors.New("out of scope")
+
+// scope decides which links are followed, see the WithCategories etc. options
+type scope struct {
+	includeCategories map[string]bool
+	excludeCategories map[string]bool
+	includeNames      []*regexp.Regexp
+	excludeNames      []*regexp.Regexp
+	maxDepth          int
+	maxPages          int
+	timeBudget        time.Duration
+	// skipHome is set when the crawl starts somewhere other than the home page, which then isn't followed
+	skipHome bool
+
+	mutex   *sync.Mutex
+	pages   int
+	expired bool
+}
+
+// allows checks whether the page at u, linked to with the given text, may be queued at depth
+// (the number of links away from the home page).
+func (sc *scope) allows(u *url.URL, text string, depth int) error {
+	if sc.maxDepth > 0 && depth > sc.maxDepth {
+		return fmt.Errorf("%w: depth %d is more than %d", ErrOutOfScope, depth, sc.maxDepth)
+	}
+
+	pageType := PageTypeOf(u)
+	if sc.skipHome && pageType == PageHome {
+		return fmt.Errorf("%w: the crawl starts at another page than the home page", ErrOutOfScope)
+	}
+	if pageType != PageCategory && pageType != PageProduct {
+		return nil
+	}
+
+	catID := u.Query().Get("catId")
+	if len(sc.includeCategories) > 0 && !sc.includeCategories[catID] {
+		return fmt.Errorf("%w: category %q is not included", ErrOutOfScope, catID)
+	}
+	if sc.excludeCategories[catID] {
+		return fmt.Errorf("%w: category %q is excluded", ErrOutOfScope, catID)
+	}
+
+	// names only mean something for links to categories
+	if pageType != PageCategory {
+		return nil
+	}
+	if len(sc.includeNames) > 0 && !matchesAny(sc.includeNames, text) {
+		return fmt.Errorf("%w: category name %q is not included", ErrOutOfScope, text)
+	}
+	if matchesAny(sc.excludeNames, text) {
+		return fmt.Errorf("%w: category name %q is excluded", ErrOutOfScope, text)
+	}
+	return nil
+}
+
+// reserve counts a page against the page budget, returning false if the budget is used up
+func (sc *scope) reserve() bool {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.expired || (sc.maxPages > 0 && sc.pages >= sc.maxPages) {
+		return false
+	}
+	sc.pages++
+	return true
+}
+
+// release gives back a page reserved for a link that wasn't queued after all
+func (sc *scope) release() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.pages--
+}
+
+// expire ends the time budget: nothing new is queued or requested, only discount fragments of products in flight
+func (sc *scope) expire() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.expired = true
+}
+
+func (sc *scope) isExpired() bool {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.expired
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := []*regexp.Regexp{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func stringSet(ss []string) map[string]bool {
+	m := make(map[string]bool)
+	for _, s := range ss {
+		m[s] = true
+	}
+	return m
+}
@@ -0,0 +1,176 @@
+package scraper
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func scopeTestServer() *ebuckstest.Server {
+	cs := []ebuckstest.Category{
+		{CatID: "1", Name: "Books"},
+		{CatID: "2", Name: "Electronics"},
+		{CatID: "3", Name: "Garden Tools"},
+	}
+	ps := []ebuckstest.Product{}
+	for _, c := range cs {
+		ps = append(ps, ebuckstest.MakeProducts(c.CatID, 3)...)
+	}
+	for i := range ps {
+		// product IDs have to be unique across categories
+		ps[i].ProdID = ps[i].CatID + ps[i].ProdID
+	}
+	ts := ebuckstest.NewServer(ps)
+	ts.SetCategories(cs)
+	return ts
+}
+
+func scrapeCategories(t *testing.T, ts *ebuckstest.Server, opts ...Option) []string {
+	t.Helper()
+
+	m := sync.Mutex{}
+	cats := map[string]bool{}
+	opts = append(opts,
+		WithBaseURL(ts.URL),
+		WithThreads(2),
+		WithLogger(logging.Discard()),
+		WithCallback(func(p Product) {
+			m.Lock()
+			defer m.Unlock()
+			cats[p.CatID] = true
+		}),
+	)
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for id := range cats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestScopeCategories(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{"all", nil, []string{"1", "2", "3"}},
+		{"include ids", []Option{WithCategories("1", "3")}, []string{"1", "3"}},
+		{"exclude ids", []Option{WithoutCategories("2")}, []string{"1", "3"}},
+		{"include names", []Option{WithCategoryNames("^Electr", "Tools$")}, []string{"2", "3"}},
+		{"exclude names", []Option{WithoutCategoryNames("(?i)books")}, []string{"2", "3"}},
+		{"include and exclude", []Option{WithCategories("1", "2"), WithoutCategoryNames("Books")}, []string{"2"}},
+		{"categories only", []Option{WithMaxDepth(1)}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := scopeTestServer()
+			defer ts.Close()
+
+			got := scrapeCategories(t, ts, c.opts...)
+			if len(got) != len(c.expected) {
+				t.Fatalf("wrong categories scraped: got %v expected %v", got, c.expected)
+			}
+			for i := range got {
+				if got[i] != c.expected[i] {
+					t.Fatalf("wrong categories scraped: got %v expected %v", got, c.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestScopeMaxPages(t *testing.T) {
+	ts := scopeTestServer()
+	defer ts.Close()
+
+	// home, one category and its 3 products
+	scrapeCategories(t, ts, WithCategories("1"), WithMaxPages(5))
+	if got := ts.Hits(ebuckstest.ProductPath); got != 3 {
+		t.Errorf("wrong number of product pages: got %d expected 3", got)
+	}
+
+	ts2 := scopeTestServer()
+	defer ts2.Close()
+	scrapeCategories(t, ts2, WithCategories("1"), WithMaxPages(3))
+	if got := ts2.Hits(ebuckstest.ProductPath); got != 1 {
+		t.Errorf("wrong number of product pages with a smaller budget: got %d expected 1", got)
+	}
+	// every product page that was fetched was finished
+	if got := ts2.Hits(ebuckstest.DiscountPath); got != 1 {
+		t.Errorf("wrong number of discount fragments: got %d expected 1", got)
+	}
+}
+
+func TestScopeTimeBudget(t *testing.T) {
+	ts := scopeTestServer()
+	defer ts.Close()
+	ts.SetLatency(50*time.Millisecond, 50*time.Millisecond)
+
+	start := time.Now()
+	n := 0
+	s, err := New(
+		WithBaseURL(ts.URL),
+		WithLogger(logging.Discard()),
+		WithTimeBudget(300*time.Millisecond),
+		WithCallback(func(p Product) { n++ }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("running out of time should not be an error: %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("crawl should have wound down after its budget: took %s", elapsed)
+	}
+	if n == 0 || n == 9 {
+		t.Errorf("some but not all products should have been scraped: got %d", n)
+	}
+	// products that were started were finished
+	if ts.Hits(ebuckstest.ProductPath) != ts.Hits(ebuckstest.DiscountPath) {
+		t.Errorf("every product page should have its discount fragment: %d product pages, %d fragments",
+			ts.Hits(ebuckstest.ProductPath), ts.Hits(ebuckstest.DiscountPath))
+	}
+}
+
+func TestScopeStartURL(t *testing.T) {
+	ts := scopeTestServer()
+	defer ts.Close()
+
+	got := scrapeCategories(t, ts, WithStartURL(ts.URL+"/web/shop/categorySelected.do?catId=2"))
+	if len(got) != 1 || got[0] != "2" {
+		t.Errorf("crawl starting at a category should only scrape that category: got %v", got)
+	}
+
+	if _, err := New(WithStartURL("/web/shop/shopHome.do")); err == nil {
+		t.Errorf("a relative start URL should be rejected")
+	}
+}
+
+func TestScopeOptionsRejectInvalidValues(t *testing.T) {
+	for name, option := range map[string]Option{
+		"relative base URL":        WithBaseURL("/web/shop"),
+		"base URL without a host":  WithBaseURL("https:///web/shop"),
+		"base URL that isn't http": WithBaseURL("ftp://www.ebucks.com"),
+		"negative max depth":       WithMaxDepth(-1),
+		"negative max pages":       WithMaxPages(-1),
+	} {
+		if _, err := New(option); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
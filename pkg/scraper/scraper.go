@@ -1,274 +1,446 @@
 package scraper
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
-	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+	"github.com/geniass/ebucks-dealz/pkg/warc"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/queue"
 )
 
-const maxNumRetries int = 5
+// DefaultMaxRetries is how many times a page that failed is retried by default, see WithMaxRetries.
+const DefaultMaxRetries = 5
 
-var categorySelectedUrlCleanerRegex = regexp.MustCompile(`(.*categorySelected\.do).*(catId=\d+).*`)
+// how often the proxies are checked while crawling, see WithProxies
+const proxyHealthCheckInterval = time.Minute
+
+const DefaultBaseURL = "https://www.ebucks.com"
+
+const userAgent = "Mozilla/5.0 (Windows NT x.y; Win64; x64; rv:10.0) Gecko/20100101 Firefox/10.0"
 
 type ProductPageCallbackFunc func(p Product)
 
 var ErrRedirectToErrorPage = errors.New("redirected to error page")
 
 const ctxScrapedDataKey string = "scraped"
+const ctxParentKey string = "parent"
+const ctxStartTimeKey string = "start:"
+const ctxSessionKey string = "session"
 
-var urlProdIdAndCatIdRegex = regexp.MustCompile(`productSelected\.do\?prodId=(\d+)&catId=(\d+)`)
 var randsRegex = regexp.MustCompile(`R([\d\s]+(\.\d+)?)`)
 var whitespaceRegex = regexp.MustCompile(`\s`)
 
-// cacheDir can be empty to disable caching.
+// checkRedirect returns a redirect handler that refuses to follow redirects to the site's error page:
+// the ebucks website redirects to a generic error page on error (including "not found" and "service unavailable")
+func checkRedirect(logger *slog.Logger) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if strings.Contains(req.URL.Path, "globalExceptionPage.jsp") {
+			return fmt.Errorf("not following redirect (implies error) %q : %+v : %w", req.URL.String(), req.Header, ErrRedirectToErrorPage)
+		}
+
+		vias := []string{}
+		for _, v := range via {
+			vias = append(vias, v.URL.String())
+		}
+		logger.Debug("redirecting", logging.KeyURL, req.URL.String(), "via", strings.Join(vias, " -> "), "redirects", len(via))
+
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+// NewScraper creates a scraper for the real site. cacheDir can be empty to disable caching.
+// Use New for more control.
 func NewScraper(cacheDir string, threads int, callback ProductPageCallbackFunc) Scraper {
+	// none of these options can fail
+	s, _ := New(WithCacheDir(cacheDir), WithThreads(threads), WithCallback(callback))
+	return s
+}
+
+// New creates a scraper configured by opts. By default it crawls the real site with a single worker.
+func New(opts ...Option) (Scraper, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return Scraper{}, err
+		}
+	}
+
+	base, err := url.Parse(cfg.baseURL)
+	if err != nil {
+		return Scraper{}, err
+	}
+	if cfg.allowedDomains == nil {
+		cfg.allowedDomains = []string{base.Hostname()}
+	}
 
 	options := []colly.CollectorOption{
-		colly.AllowedDomains("www.ebucks.com"),
+		colly.AllowedDomains(cfg.allowedDomains...),
 		colly.URLFilters(
 			regexp.MustCompile(`.*/web/shop/shopHome\.do`),
 			regexp.MustCompile(`.*/web/shop/categorySelected\.do.*`),
 			regexp.MustCompile(`.*/web/shop/productSelected(Discount)?\.do.*`),
 		),
-		colly.UserAgent("Mozilla/5.0 (Windows NT x.y; Win64; x64; rv:10.0) Gecko/20100101 Firefox/10.0"),
+		colly.UserAgent(cfg.userAgent),
 	}
 
-	if cacheDir != "" {
-		options = append(options, colly.CacheDir(cacheDir))
+	if cfg.cacheDir != "" {
+		options = append(options, colly.CacheDir(cfg.cacheDir))
 	}
 
-	// InMemoryQueueStorage Init can't fail
+	// PriorityQueueStorage Init can't fail
+	storage := &PriorityQueueStorage{}
 	q, _ := queue.New(
-		threads,
-		&StackQueueStorage{},
+		cfg.threads,
+		storage,
 	)
+	// the patterns were checked by the options
+	includeNames, _ := compilePatterns(cfg.includeNames)
+	excludeNames, _ := compilePatterns(cfg.excludeNames)
+
+	sc := &scope{
+		includeCategories: stringSet(cfg.includeCategories),
+		excludeCategories: stringSet(cfg.excludeCategories),
+		includeNames:      includeNames,
+		excludeNames:      excludeNames,
+		maxDepth:          cfg.maxDepth,
+		maxPages:          cfg.maxPages,
+		timeBudget:        cfg.timeBudget,
+		mutex:             &sync.Mutex{},
+	}
+	if cfg.startURL != "" {
+		start, _ := url.Parse(cfg.startURL)
+		sc.skipHome = PageTypeOf(start) != PageHome
+	}
+	debug := newDebugState()
+	outage := &outageDetector{sample: cfg.outageSample, threshold: cfg.outageThreshold, mutex: &sync.Mutex{}}
+	homeURL := base.ResolveReference(&url.URL{Path: "/web/shop/shopHome.do"}).String()
+	startingURL := homeURL
+	if cfg.startURL != "" {
+		startingURL = cfg.startURL
+	}
 	s := Scraper{
-		startingURL: "https://www.ebucks.com/web/shop/shopHome.do",
+		startingURL: startingURL,
+		maxRetries:  cfg.maxRetries,
 		colly:       colly.NewCollector(options...),
 		q:           q,
+		storage:     storage,
 		mutex:       &sync.Mutex{},
 		urlBackoffs: make(map[string]int),
-		links:       make(map[string]int),
-		scraped:     make(map[string]int),
+		sink:        &sink{ctx: context.Background(), callback: cfg.callback},
+		observers:   append([]Observer{debug, outage}, cfg.observers...),
+		debug:       debug,
+		outage:      outage,
+		scope:       sc,
+		listings:    make(map[string]ListingTile),
+		logger:      cfg.logger,
+		runConfig: RunConfig{
+			BaseURL:        cfg.baseURL,
+			StartURL:       cfg.startURL,
+			AllowedDomains: cfg.allowedDomains,
+			Threads:        cfg.threads,
+			UserAgent:      cfg.userAgent,
+			CacheDir:       cfg.cacheDir,
+			Delay:          cfg.delay,
+			RandomDelay:    cfg.randomDelay,
+			Archive:        cfg.archive != nil,
+
+			IncludeCategories: cfg.includeCategories,
+			ExcludeCategories: cfg.excludeCategories,
+			IncludeNames:      cfg.includeNames,
+			ExcludeNames:      cfg.excludeNames,
+			MaxDepth:          cfg.maxDepth,
+			MaxPages:          cfg.maxPages,
+			TimeBudget:        cfg.timeBudget,
+
+			LoggedIn: cfg.credentials != nil,
+			Proxies:  proxyCount(cfg.proxies),
+		},
+		proxies: cfg.proxies,
 	}
 
-	// somehow cookies are causing weird concurrency issues where the wrong response body gets used
+	// a jar shared by all the workers made the site return the wrong response bodies, so each page and its
+	// discount fragment get a session of their own from the pool instead
 	s.colly.DisableCookies()
 
-	s.colly.WithTransport(&http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   300 * time.Second,
-			KeepAlive: 300 * time.Second,
-		}).DialContext,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       900 * time.Second,
-		TLSHandshakeTimeout:   300 * time.Second,
-		ExpectContinueTimeout: 100 * time.Second,
-		ResponseHeaderTimeout: 300 * time.Second,
-	})
-
-	// the ebucks website redirects to a generic error page on error (including "not found" and "service unavailable")
-	s.colly.SetRedirectHandler(func(req *http.Request, via []*http.Request) error {
-		if strings.Contains(req.URL.Path, "globalExceptionPage.jsp") {
-			return fmt.Errorf("not following redirect (implies error) %q : %+v : %w", req.URL.String(), req.Header, ErrRedirectToErrorPage)
-		}
-
-		vias := []string{}
-		for _, v := range via {
-			vias = append(vias, v.URL.String())
+	s.transport = cfg.transport
+	if cfg.proxies != nil {
+		base, ok := cfg.transport.(*http.Transport)
+		if !ok {
+			return Scraper{}, fmt.Errorf("proxies need an *http.Transport, not %T", cfg.transport)
 		}
-		fmt.Fprintf(os.Stderr, "Redirecting %s -> %s (%d redirects)\n", strings.Join(vias, " -> "), req.URL.String(), len(via))
+		t := base.Clone()
+		t.Proxy = cfg.proxies.Proxy
+		s.transport = t
+	}
+	if cfg.archive != nil {
+		s.transport = &warc.Transport{Base: s.transport, Writer: cfg.archive, Logger: cfg.logger}
+	}
+	if cfg.proxies != nil {
+		s.transport = cfg.proxies.Transport(s.transport)
+	}
+	s.sessions = newSessionPool(s.transport, homeURL, cfg.threads, s.logger)
+	s.sessions.credentials = cfg.credentials
+	s.sessions.sessionFile = cfg.sessionFile
+	s.colly.WithTransport(s.sessions)
+
+	if cfg.limits {
+		s.colly.Limit(&colly.LimitRule{
+			DomainGlob:  "*",
+			Parallelism: cfg.threads,
+			Delay:       cfg.delay,
+			RandomDelay: cfg.randomDelay,
+		})
+	}
 
-		if len(via) >= 10 {
-			return errors.New("stopped after 10 redirects")
-		}
-		return nil
-	})
+	s.colly.SetRedirectHandler(checkRedirect(s.logger))
 
 	s.colly.OnError(func(r *colly.Response, err error) {
+		// retries happen before this returns, so the chain is done with its session either way
+		defer s.releaseSession(r.Request)
+
 		// exponential backoff
 		s.mutex.Lock()
 		s.urlBackoffs[r.Request.URL.String()]++
 		numRetries := s.urlBackoffs[r.Request.URL.String()]
 		s.mutex.Unlock()
 
+		event := Event{
+			Kind:       EventError,
+			URL:        r.Request.URL.String(),
+			Parent:     parentOf(r.Request),
+			PageType:   PageTypeOf(r.Request.URL),
+			Attempt:    numRetries,
+			StatusCode: r.StatusCode,
+			Bytes:      len(r.Body),
+			Duration:   requestDuration(r.Request),
+			Err:        err,
+		}
+
+		logger := s.logger.With(logging.KeyURL, event.URL, logging.KeyAttempt, numRetries, logging.KeyStatus, r.StatusCode)
+
 		if errors.Is(err, ErrRedirectToErrorPage) {
 			// no need to retry because when we get redirected to the error page it means that page is completely broken
-			log.Println("Ignoring page due to redirect error: %w", err)
+			logger.Warn("ignoring page, redirected to error page", logging.KeyError, err)
+			s.observe(event)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
 			return
 		}
 
 		if r.StatusCode >= 400 && r.StatusCode < 500 {
-			log.Printf("Ignoring page due to Not Found (Page=%q): %s\n", r.Request.URL, err)
+			logger.Warn("ignoring page, client error", logging.KeyError, err)
+			s.observe(event)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
 			return
 		}
 
-		if numRetries > maxNumRetries {
-			log.Fatalf("Max retries (%d) exceeded for URL %q\n", maxNumRetries, r.Request.URL.String())
+		if s.sink.ctx.Err() != nil {
+			// shutting down, don't bother retrying
+			s.observe(event)
 			return
 		}
 
-		duration := time.Duration(math.Pow(2, float64(numRetries))) * time.Second
-		fmt.Fprintf(os.Stderr, "ERROR: Request %q [%d] failed, retrying after %.0f s: %v\n", r.Request.URL.String(), r.StatusCode, duration.Seconds(), err)
-		time.Sleep(duration)
-		if err := r.Request.Retry(); err != nil {
-			fmt.Fprintln(os.Stderr, "ERROR while retrying:", err)
+		if numRetries > s.maxRetries {
+			logger.Error("giving up on page, max retries exceeded", "maxRetries", s.maxRetries, logging.KeyError, err)
+			s.observe(event)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
+			return
 		}
 
-		r.Ctx.Put("attempts", numRetries)
-	})
+		event.Retrying = true
+		s.observe(event)
 
-	s.urlChan = make(chan string)
-	go func() {
-		f, err := os.Create("urls.txt")
-		if err != nil {
-			log.Fatal(err)
+		duration := time.Duration(math.Pow(2, float64(numRetries))) * time.Second
+		logger.Warn("request failed, retrying", "backoff", duration, logging.KeyError, err)
+		select {
+		case <-time.After(duration):
+		case <-s.sink.ctx.Done():
+			return
 		}
-		defer f.Close()
-		for url := range s.urlChan {
-			f.WriteString(url + "\n")
-			f.Sync()
+		if err := r.Request.Retry(); err != nil {
+			logger.Error("could not retry request", logging.KeyError, err)
 		}
-	}()
+	})
 
 	s.colly.OnHTML("a[href]", func(e *colly.HTMLElement) {
 		link := e.Request.AbsoluteURL(e.Attr("href"))
-		link = cleanCategorySelectedUrl(link)
-		err := s.visit(link)
+		link = CanonicalURL(link)
+		if isCategoryPagination(link) {
+			// pagination is followed page by page from the category page handler
+			return
+		}
+		text := strings.TrimSpace(e.Text)
+		if text == "" {
+			text = e.ChildAttr("img", "alt")
+		}
+		err := s.visit(link, text, e.Request)
 
 		if err == nil {
-			fmt.Println("FOUND LINK", e.Request.AbsoluteURL(link), e.ChildAttr("img", "alt"))
-			s.mutex.Lock()
-			s.links[link] = 1
-			s.mutex.Unlock()
-
-			s.urlChan <- link + " " + e.Request.URL.String()
-		} else if !(errors.Is(err, colly.ErrAlreadyVisited) || errors.Is(err, colly.ErrNoURLFiltersMatch) || errors.Is(err, colly.ErrMissingURL)) {
-			fmt.Fprintln(os.Stderr, "ERROR", err, link)
+			s.logger.Debug("found link", logging.KeyURL, link, logging.KeyParent, e.Request.URL.String(), "text", text)
+		} else if errors.Is(err, ErrOutOfScope) {
+			s.logger.Debug("not following link", logging.KeyURL, link, logging.KeyParent, e.Request.URL.String(), logging.KeyError, err)
+		} else if !(errors.Is(err, colly.ErrAlreadyVisited) || errors.Is(err, ErrAlreadyQueued) || errors.Is(err, colly.ErrNoURLFiltersMatch) || errors.Is(err, colly.ErrMissingURL)) {
+			s.logger.Error("could not queue link", logging.KeyURL, link, logging.KeyParent, e.Request.URL.String(), logging.KeyError, err)
 		}
 	})
 
-	s.colly.OnHTML("form[name=productOptionsBean]", func(e *colly.HTMLElement) {
+	s.colly.OnResponse(func(r *colly.Response) {
+		s.mutex.Lock()
+		attempt := s.urlBackoffs[r.Request.URL.String()] + 1
+		s.mutex.Unlock()
+		s.observe(Event{
+			Kind:       EventResponse,
+			URL:        r.Request.URL.String(),
+			Parent:     parentOf(r.Request),
+			PageType:   PageTypeOf(r.Request.URL),
+			Attempt:    attempt,
+			StatusCode: r.StatusCode,
+			Bytes:      len(r.Body),
+			Duration:   requestDuration(r.Request),
+		})
+	})
 
-		// sanity check: URL IDs must match hidden form inputs otherwise we somehow ended up with the wrong page (?!)
-		urlProdId := e.Request.URL.Query().Get("prodId")
-		urlCatId := e.Request.URL.Query().Get("catId")
-		pid := e.ChildAttr("input[name=prodId]", "value")
-		cid := e.ChildAttr("input[name=catId]", "value")
-		if pid != urlProdId || cid != urlCatId {
-			log.Fatalf("prodId or catId mismatch! pid: (formPID=%q urlPID=%q) cid: (formCID=%q urlCID=%q)\n", pid, urlProdId, cid, urlCatId)
+	s.colly.OnResponse(func(r *colly.Response) {
+		if !strings.Contains(r.Request.URL.Path, "productSelected.do") {
+			return
 		}
 
-		fmt.Printf("Found product: URL=%q NAME=%q\n", e.Request.URL.String(), e.ChildText("h2.product-name"))
-		// if e.Request.URL.String() == "https://www.ebucks.com/web/shop/productSelected.do?prodId=496816900&catId=1158501813" {
-		// 	log.Fatal(e.Response.Save("/tmp/index.html"))
-		// }
-		// https://www.ebucks.com/web/shop/productSelected.do?prodId=496816900&catId=1158501813
-
-		if e.Request.URL.String() == "https://www.ebucks.com/web/shop/productSelected.do?prodId=1173295004&catId=704981826" {
-			fmt.Println("HERE")
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
+		if err != nil {
+			s.logger.Warn("could not parse product page", logging.KeyURL, r.Request.URL.String(), logging.KeyError, err)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
+			return
 		}
 
-		a := e.Response.Ctx.GetAny("attempts")
-		if a != nil && a.(int) != 0 {
-			fmt.Println("RETRYING:", e.Request.URL.String())
+		p, err := ExtractProduct(doc, r.Request.URL)
+		if errors.Is(err, ErrNotProductPage) {
+			return
+		} else if err != nil {
+			s.logger.Warn("could not extract product", logging.KeyURL, r.Request.URL.String(), logging.KeyError, err)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
+			return
 		}
 
-		price := float64(-1)
-		{
-			priceString := e.ChildText("#randPrice")
-			if priceString != "" {
-				f, err := parseRands(priceString)
-				if err != nil {
-					fmt.Printf("Error parsing price (%q): %s\n", priceString, err)
-				} else {
-					price = f
-				}
-			}
-		}
+		logger := s.logger.With(logging.KeyURL, p.URL, logging.KeyProdID, p.ProdID, logging.KeyCatID, p.CatID)
+		logger.Info("found product", "name", p.Name)
 
-		savings := float64(0)
-		{
-			savingsString := e.ChildText(".was-price .randValue")
-			if savingsString != "" {
-				f, err := parseRands(savingsString)
-				if err != nil {
-					fmt.Printf("Error parsing savings (%q): %s\n", savingsString, err)
-				} else {
-					savings = f
-				}
+		s.mutex.Lock()
+		tile, listed := s.listings[p.ProdID+"/"+p.CatID]
+		delete(s.listings, p.ProdID+"/"+p.CatID)
+		s.mutex.Unlock()
+		if listed {
+			if diffs := CompareListing(tile, p); len(diffs) > 0 {
+				logger.Warn("listing tile differs from product page", "differences", diffs)
 			}
 		}
 
-		p := Product{
-			URL:     e.Request.URL.String(),
-			Name:    e.ChildText("h2.product-name"),
-			ProdID:  e.Request.URL.Query().Get("prodId"),
-			CatID:   e.Request.URL.Query().Get("catId"),
-			Price:   price,
-			Savings: savings,
+		if p.Price < 0 {
+			logger.Warn("could not parse price")
 		}
 
-		fmt.Printf("Found product: Name=%q URL=%q\n", p.Name, p.URL)
-
 		// its no longer json, they now sometimes return html fragments
 		// so we have to make a request to the below url and if stuff is returned its on discount
 		// which means we always have to make the request, so just do it here
 		// queue fetching the HTML table page fragment for this product (for prices etc.) if the product is discounted
-		link := e.Request.URL.String()
-		if urlProdIdAndCatIdRegex.MatchString(link) {
-			replaced := urlProdIdAndCatIdRegex.ReplaceAllString(link, "productSelectedDiscount.do?prodId=$1&catId=$2")
-			log.Printf("Fetching discounts: Name=%q URL=%q\n", p.Name, replaced)
-			e.Request.Ctx.Put(ctxScrapedDataKey, p)
-			e.Request.Visit(replaced)
+		if discount, ok := discountURL(r.Request.URL); ok {
+			logger.Debug("fetching discounts", "discountUrl", discount)
+			r.Ctx.Put(ctxScrapedDataKey, p)
+			r.Request.Visit(discount)
 		}
 	})
 
-	s.colly.OnRequest(func(r *colly.Request) {
-		fmt.Println("Visiting", r.URL.String())
+	s.colly.OnResponse(func(r *colly.Response) {
+		if PageTypeOf(r.Request.URL) != PageCategory {
+			return
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
+		if err != nil {
+			s.logger.Warn("could not parse category page", logging.KeyURL, r.Request.URL.String(), logging.KeyError, err)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
+			return
+		}
+
+		c := ExtractCategoryPage(doc, r.Request.URL)
+		s.logger.Info("found category page", logging.KeyURL, c.URL, logging.KeyCatID, c.CatID, "name", c.Name,
+			"page", c.Page, "pages", c.Pages, "tiles", len(c.Tiles), "productCount", c.ProductCount)
+		s.observe(Event{Kind: EventCategory, URL: c.URL, Parent: parentOf(r.Request), PageType: PageCategory, Category: &c})
+
+		s.mutex.Lock()
+		for _, t := range c.Tiles {
+			s.listings[t.ProdID+"/"+t.CatID] = t
+		}
+		s.mutex.Unlock()
 
-		// these headers are very important for some reason
-		r.Headers.Add("Cookie", "js=1637881630272")
-		r.Headers.Add("Referer", r.URL.String())
+		if c.Next != "" {
+			next := c.Next
+			if err := s.visit(next, c.Name, r.Request); err != nil && !errors.Is(err, colly.ErrAlreadyVisited) && !errors.Is(err, ErrAlreadyQueued) {
+				s.logger.Warn("not following next page", logging.KeyURL, next, logging.KeyCatID, c.CatID, logging.KeyError, err)
+			}
+		}
 	})
 
-	s.colly.OnHTML("table#discount-table", func(e *colly.HTMLElement) {
-		// try get the partial product info that was scraped and continue parsing the partial HTML response
-		c, ok := e.Request.Ctx.GetAny(ctxScrapedDataKey).(Product)
-		if !ok {
-			log.Printf("WARNING: OnHTML(table#discount-table): could not get partial product info from ctx: URL=%q\n", e.Request.URL)
+	s.colly.OnRequest(func(r *colly.Request) {
+		s.waitIfPaused()
+		if s.sink.ctx.Err() != nil {
+			r.Abort()
+			s.releaseSession(r)
+			return
+		}
+		// once the time is up only the products already started are finished
+		if s.scope.isExpired() && PageTypeOf(r.URL) != PageDiscount {
+			r.Abort()
+			s.releaseSession(r)
 			return
 		}
 
-		discounts := []ebucksDiscount{}
-		e.ForEach("div > table > tbody", func(i int, h *colly.HTMLElement) {
-			d := ebucksDiscount{
-				Level:         i + 1,
-				Percent:       parsePercentage(h.ChildText("p.percentage")),
-				EbucksPrice:   parseEbucksValue(h.ChildText("td.col2 > span.eBucksValue")),
-				EbucksSavings: parseEbucksValue(h.ChildText("td.col4 > span.eBucksValue")),
-			}
-			discounts = append(discounts, d)
-		})
+		// a page and the discount fragment fetched because of it (which shares its context) use the same session
+		lease, ok := r.Ctx.GetAny(ctxSessionKey).(*sessionLease)
+		if !ok {
+			lease = s.sessions.lease()
+			r.Ctx.Put(ctxSessionKey, lease)
+		}
+		r.Headers.Set(sessionHeader, strconv.Itoa(lease.sess.id))
 
-		discount := discounts[len(discounts)-1]
-		c.Percentage = float64(discount.Percent)
-		c.Price = discount.RandPrice()
-		c.Savings = discount.RandSavings()
+		s.mutex.Lock()
+		attempt := s.urlBackoffs[r.URL.String()] + 1
+		s.mutex.Unlock()
+		s.logger.Debug("visiting", logging.KeyURL, r.URL.String(), logging.KeyAttempt, attempt)
+		r.Ctx.Put(ctxStartTimeKey+r.URL.String(), time.Now())
+		s.observe(Event{
+			Kind:     EventRequest,
+			URL:      r.URL.String(),
+			Parent:   parentOf(r),
+			PageType: PageTypeOf(r.URL),
+			Attempt:  attempt,
+		})
 
-		callback(c)
+		// browse like a person would, from the page the link was found on
+		if parent := parentOf(r); parent != "" {
+			r.Headers.Set("Referer", parent)
+		}
+		for k, vs := range cfg.headers {
+			for _, v := range vs {
+				r.Headers.Add(k, v)
+			}
+		}
 	})
 
 	s.colly.OnResponse(func(r *colly.Response) {
@@ -276,26 +448,37 @@ func NewScraper(cacheDir string, threads int, callback ProductPageCallbackFunc)
 			return
 		}
 
-		// need to test if the response contains an HTML table containing discount info
-		// if there is, we don't do the callback here because it will be called by the HTML handler for the discount table
-		if !strings.Contains(string(r.Body), "table") {
-			fmt.Println(string(r.Body))
-			// no discount
-			// try get the partial product info that was scraped
-			c, ok := r.Ctx.GetAny(ctxScrapedDataKey).(Product)
-			if !ok {
-				log.Printf("WARNING: OnResponse: could not get partial product info from ctx: URL=%q\n", r.Request.URL)
-				return
-			}
-			callback(c)
+		// try get the partial product info that was scraped
+		c, ok := r.Ctx.GetAny(ctxScrapedDataKey).(Product)
+		if !ok {
+			s.logger.Warn("could not get partial product info from ctx", logging.KeyURL, r.Request.URL.String())
 			return
 		}
-		log.Println("DISCOUNT!", r.Request.URL)
+
+		// the response is an HTML fragment which only contains a table of discount tiers if the product is discounted
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.Body))
+		if err != nil {
+			s.logger.Warn("could not parse discount fragment", logging.KeyURL, r.Request.URL.String(), logging.KeyError, err)
+			s.reportError(&ScrapeError{URL: r.Request.URL.String(), StatusCode: r.StatusCode, Err: err})
+			return
+		}
+		discounts := ExtractDiscounts(doc)
+		if len(discounts) > 0 {
+			s.logger.Info("found discount", logging.KeyURL, c.URL, logging.KeyProdID, c.ProdID, logging.KeyCatID, c.CatID, "tiers", len(discounts))
+		}
+
+		s.emit(ApplyDiscounts(c, discounts))
 	})
 
-	return s
+	s.colly.OnScraped(func(r *colly.Response) {
+		// a product's discount fragment is fetched from its OnResponse, so it's done by now too
+		s.releaseSession(r.Request)
+	})
+
+	return s, nil
 }
 
+// EnableLimits is the same as the WithLimits(2*time.Second, 5*time.Second) option.
 func (s Scraper) EnableLimits() {
 	s.colly.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
@@ -305,8 +488,60 @@ func (s Scraper) EnableLimits() {
 	})
 }
 
+// QueueSize returns the number of requests waiting to be made.
+func (s Scraper) QueueSize() int {
+	n, err := s.q.Size()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Start runs the crawl and blocks until it is done, calling the callback given to NewScraper for every product.
 func (s Scraper) Start() error {
-	if err := s.visit(s.startingURL); err != nil {
+	return s.start(context.Background())
+}
+
+func (s Scraper) start(ctx context.Context) error {
+	// cancelling ctx makes OnRequest abort everything, which drains the queue quickly
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.sink.ctx = ctx
+	s.outage.setStop(cancel)
+
+	s.observe(Event{Kind: EventStart, URL: s.startingURL, Config: &s.runConfig})
+	if s.scope.timeBudget > 0 {
+		timer := time.AfterFunc(s.scope.timeBudget, func() {
+			s.logger.Info("time budget used up, winding down", "budget", s.scope.timeBudget)
+			s.scope.expire()
+		})
+		defer timer.Stop()
+	}
+	if s.proxies != nil {
+		monitorCtx, stopMonitor := context.WithCancel(ctx)
+		defer stopMonitor()
+		go s.proxies.Monitor(monitorCtx, s.startingURL, proxyHealthCheckInterval)
+	}
+	err := s.crawl()
+	if outage := s.outage.err(); outage != nil {
+		s.logger.Error("stopped crawl, the site seems to be down", logging.KeyError, outage)
+		err = outage
+	} else if err == nil {
+		err = ctx.Err()
+	}
+	finish := Event{Kind: EventFinish, Err: err}
+	if s.proxies != nil {
+		finish.Proxies = s.proxies.Stats()
+	}
+	s.observe(finish)
+	return err
+}
+
+func (s Scraper) crawl() error {
+	if err := s.sessions.checkLogin(s.sink.ctx); err != nil {
+		return err
+	}
+	if err := s.visit(s.startingURL, "", nil); err != nil {
 		return err
 	}
 
@@ -315,44 +550,86 @@ func (s Scraper) Start() error {
 	}
 
 	s.colly.Wait()
-	close(s.urlChan)
-	time.Sleep(10 * time.Second)
-	f, err := os.Create("links.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	for k := range s.links {
-		f.WriteString(k + "\n")
-	}
-	f.Close()
+	return nil
+}
 
-	f, err = os.Create("scraped.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	for k := range s.scraped {
-		f.WriteString(k + "\n")
+func proxyCount(p *proxy.Pool) int {
+	if p == nil {
+		return 0
 	}
-	f.Close()
-
-	return nil
+	return len(p.Stats())
 }
 
-func (s Scraper) visit(url string) error {
-	if visited, err := s.colly.HasVisited(url); err != nil {
+// visit queues link with the given link text, found on parent (nil for the starting URL)
+func (s Scraper) visit(link string, text string, parent *colly.Request) error {
+	link = CanonicalURL(link)
+	if visited, err := s.colly.HasVisited(link); err != nil {
 		return err
 	} else if visited {
 		return colly.ErrAlreadyVisited
 	}
 
 	for _, f := range s.colly.URLFilters {
-		if f.MatchString(url) {
-			return s.q.AddURL(url)
+		if f.MatchString(link) {
+			u, err := url.Parse(link)
+			if err != nil {
+				return err
+			}
+			r := &colly.Request{URL: u, Method: "GET", Ctx: colly.NewContext(), Depth: 1}
+			if parent != nil {
+				r.Ctx.Put(ctxParentKey, parent.URL.String())
+				r.Depth = parent.Depth + 1
+				// later pages of a category are as far from the home page as the first one
+				if PageTypeOf(u) == PageCategory && PageTypeOf(parent.URL) == PageCategory && u.Query().Get("catId") == parent.URL.Query().Get("catId") {
+					r.Depth = parent.Depth
+				}
+			}
+
+			// the home page has depth 1
+			if err := s.scope.allows(u, text, r.Depth-1); err != nil {
+				return err
+			}
+			if !s.scope.reserve() {
+				return fmt.Errorf("%w: page budget used up", ErrOutOfScope)
+			}
+			if err := s.q.AddRequest(r); err != nil {
+				s.scope.release()
+				return err
+			}
+			s.observe(Event{Kind: EventQueued, URL: link, Parent: parentOf(r), PageType: PageTypeOf(u)})
+			return nil
 		}
 	}
 	return colly.ErrNoURLFiltersMatch
 }
 
+// releaseSession ends the session lease of the chain r started; discount fragments are part of their product's chain
+func (s Scraper) releaseSession(r *colly.Request) {
+	if PageTypeOf(r.URL) == PageDiscount {
+		return
+	}
+	if lease, ok := r.Ctx.GetAny(ctxSessionKey).(*sessionLease); ok {
+		s.sessions.release(lease)
+	}
+}
+
+// parentOf returns the URL of the page r was found on; for discount fragments that is the product page
+func parentOf(r *colly.Request) string {
+	if PageTypeOf(r.URL) == PageDiscount {
+		if p, ok := r.Ctx.GetAny(ctxScrapedDataKey).(Product); ok {
+			return p.URL
+		}
+	}
+	return r.Ctx.Get(ctxParentKey)
+}
+
+func requestDuration(r *colly.Request) time.Duration {
+	if start, ok := r.Ctx.GetAny(ctxStartTimeKey + r.URL.String()).(time.Time); ok {
+		return time.Since(start)
+	}
+	return 0
+}
+
 func parsePercentage(p string) int {
 	s := strings.ReplaceAll(p, "%", "")
 	i, err := strconv.Atoi(s)
@@ -372,16 +649,6 @@ func parseEbucksValue(s string) int {
 	return i
 }
 
-// categorySelected.do URLs sometimes contain random cruft that break the already-visited list and/or cause bad results to be returned
-// e.g. https://www.ebucks.com/web/shop/categorySelected.do;jsessionid=E1FECBC2B41C4EBBE86854E78CD8A882?catId=300&extraInfo=cellphone_number
-func cleanCategorySelectedUrl(url string) string {
-	matches := categorySelectedUrlCleanerRegex.FindStringSubmatch(url)
-	if len(matches) != 3 {
-		return url
-	}
-	return matches[1] + "?" + matches[2]
-}
-
 func parseRands(s string) (float64, error) {
 	matches := randsRegex.FindStringSubmatch(s)
 	if len(matches) < 2 {
@@ -1,218 +1,305 @@
 package scraper
 
 import (
-	"fmt"
-	"log"
 	"net/http"
-	"net/http/httptest"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
 )
 
 func TestScraperFindsAllProducts(t *testing.T) {
-	products := makeProducts("0", 100000) // TODO WAT: with high numbers of products it sometimes "looses" some
-	ts := newTestServer(products)
+	products := ebuckstest.MakeProducts("0", 100000) // TODO WAT: with high numbers of products it sometimes "looses" some
+	ts := ebuckstest.NewServer(products)
 	defer ts.Close()
+	// a single page this big would go over colly's response size limit
+	ts.SetPageSize(1000)
 
-	m := sync.Mutex{}
-	scrapedProducts := []Product{}
-	s := newTestScraper(ts.URL+"/web/shop/shopHome.do", 15, func(p Product) {
-		m.Lock()
-		scrapedProducts = append(scrapedProducts, p)
-		m.Unlock()
-	})
-	if err := s.Start(); err != nil {
-		t.Fatal(err)
-	}
+	scrapedProducts := scrapeAll(t, ts, 15)
 
 	if len(products) != len(scrapedProducts) {
 		t.Errorf("wrong number of scraped products: got %d expected %d", len(scrapedProducts), len(products))
 	}
 }
 
-func newTestScraper(startingURL string, threads int, cb ProductPageCallbackFunc) Scraper {
-	s := NewScraper("", threads, cb)
-	s.colly.AllowedDomains = nil
-	s.startingURL = startingURL
-	return s
-}
+func TestScraperUsesHighestDiscountTier(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	products[1].Discounts = ebuckstest.Levels
+	products[1].Price = 1234.5
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
 
-func newTestServer(ps []Product) *httptest.Server {
+	scrapedProducts := scrapeAll(t, ts, 2)
+	if len(scrapedProducts) != 3 {
+		t.Fatalf("wrong number of scraped products: got %d expected 3", len(scrapedProducts))
+	}
 
-	catIdProdIdMap := make(map[string]map[string]Product)
-	for _, p := range ps {
-		if catIdProdIdMap[p.CatID] == nil {
-			catIdProdIdMap[p.CatID] = make(map[string]Product)
+	tiers := products[1].Tiers()
+	top := tiers[len(tiers)-1]
+	for _, p := range scrapedProducts {
+		if p.ProdID == products[1].ProdID {
+			if p.Percentage != float64(top.Percent) {
+				t.Errorf("wrong percentage: got %f expected %d", p.Percentage, top.Percent)
+			}
+			if p.Price != float64(top.EbucksPrice)/10 {
+				t.Errorf("wrong price: got %f expected %f", p.Price, float64(top.EbucksPrice)/10)
+			}
+			if p.Savings != float64(top.EbucksSavings)/10 {
+				t.Errorf("wrong savings: got %f expected %f", p.Savings, float64(top.EbucksSavings)/10)
+			}
+		} else if p.Percentage != 0 {
+			t.Errorf("product %q should not be discounted: got %f", p.ProdID, p.Percentage)
 		}
-		catIdProdIdMap[p.CatID][p.ProdID] = p
 	}
+}
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/web/shop/shopHome.do", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
+func TestScraperSkipsProductsRedirectedToErrorPage(t *testing.T) {
+	products := ebuckstest.MakeProducts("2", 10)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.BreakProduct("4")
 
-		catTags := []string{}
-		for c := range catIdProdIdMap {
-			catTags = append(catTags, c)
+	scrapedProducts := scrapeAll(t, ts, 4)
+	if len(scrapedProducts) != len(products)-1 {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scrapedProducts), len(products)-1)
+	}
+	for _, p := range scrapedProducts {
+		if p.ProdID == "4" {
+			t.Errorf("broken product should not have been scraped")
 		}
+	}
+	if ts.Hits(ebuckstest.ErrorPagePath) != 0 {
+		t.Errorf("error page should not be followed: got %d hits", ts.Hits(ebuckstest.ErrorPagePath))
+	}
+}
 
-		page := fmt.Sprintf(`<!DOCTYPE html>
-<html lang="en">
-	<body>
-		<a href="/web/shop/shopHome.do" class="active header-top-shop">SHOP</a>
-		%s
-	</body>
-</html>
-		`,
-			categoryATags(catTags),
-		)
-		w.Write([]byte(page))
+func TestScraperRetriesServerErrors(t *testing.T) {
+	products := ebuckstest.MakeProducts("3", 5)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	// the home page is the first request
+	ts.AfterRequests(1, func(s *ebuckstest.Server) {
+		s.FailNext(1, http.StatusServiceUnavailable)
 	})
 
-	mux.HandleFunc("/web/shop/categorySelected.do", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
+	scrapedProducts := scrapeAll(t, ts, 1)
+	if len(scrapedProducts) != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scrapedProducts), len(products))
+	}
+}
 
-		catId := r.URL.Query().Get("catId")
-		switch catId {
-		case "":
-			w.WriteHeader(http.StatusBadRequest)
+func TestScraperSeesProductsAddedMidCrawl(t *testing.T) {
+	products := ebuckstest.MakeProducts("4", 5)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.AfterRequests(1, func(s *ebuckstest.Server) {
+		s.PutProduct(ebuckstest.Product{ProdID: "5", CatID: "4", Name: "New Product", Price: 10})
+		s.RemoveProduct("4", "0")
+	})
 
-		default:
-			products := []Product{}
-			if catIdProdIdMap[catId] == nil {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			for _, p := range catIdProdIdMap[catId] {
-				products = append(products, p)
-			}
+	scrapedProducts := scrapeAll(t, ts, 1)
+	ids := []string{}
+	for _, p := range scrapedProducts {
+		ids = append(ids, p.ProdID)
+	}
+	sort.Strings(ids)
 
-			page := fmt.Sprintf(`<!DOCTYPE html>
-			<html lang="en">
-				<body>
-					<a href="/web/shop/shopHome.do" class="active header-top-shop">SHOP</a>
-					%s
-			</body>
-			</html>
-					`, productATags(products))
-			w.Write([]byte(page))
+	expected := []string{"1", "2", "3", "4", "5"}
+	if len(ids) != len(expected) {
+		t.Fatalf("wrong products scraped: got %v expected %v", ids, expected)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("wrong products scraped: got %v expected %v", ids, expected)
 		}
-	})
+	}
+}
 
-	mux.HandleFunc("/web/shop/productSelected.do", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-
-		q := r.URL.Query()
-		catId := q.Get("catId")
-		prodId := q.Get("prodId")
-		switch {
-		case catId == "" || prodId == "":
-			w.WriteHeader(http.StatusBadRequest)
-
-		default:
-			p := catIdProdIdMap[catId][prodId]
-			page := fmt.Sprintf(`<!DOCTYPE html>
-			<html lang="en">
-				<body>
-					<form name="productOptionsBean" method="post" action="/web/shop/productOptionSelected.do">
-						<div class="product-detail-frame">
-							<div class="info-container-frame">
-								<h2 id="product-name" class="product-name " data-maincat="842815916"
-									data-currentcat="842823972">%s</h2>
-								<div class="product-price holiday">
-									<p class="was-price">Save: <strong><span class="randValue"></span></strong></p>
-									<p>Pay in Rands: <strong><span id="randPrice" class="randValue">R%.2f</span></strong>
-									</p>
-									<p>Pay in eBucks: <strong><span id="eBPrice" class="eBucksValue">eB%d</span></strong>
-									</p>
-								</div>
-							</div>
-						</div> <input type="hidden" name="prodId" value="%s"> <input type="hidden" name="catId"
-							   value="%s"> <input type="hidden" name="skuId" value="1211817758"> <input type="hidden"
-							   id="prodName" value="%s" /> <input
-							   type="hidden" id="catName" value="Huawei " /> <input type="hidden" id="subCatName"
-							   value="[{name=Shop Home, uri=/web/shop/shopHome.do}, {name=Wearables, uri=/web/shop/categorySelected.do?catId=842815916}, {name=Huawei , uri=/web/shop/categorySelected.do?catId=842823972}]" />
-						<input type="hidden" id="fromRandPrice" value="%[2].2f" /> <input type="hidden" id="fromEBucksPrice"
-							   value="%[3]d" />
-					</form>
-				</body>
-			</html>
-					`,
-				p.Name,
-				p.Price,
-				int(p.Price*10),
-				p.ProdID,
-				p.CatID,
-				p.Name,
-			)
-			w.Write([]byte(page))
-		}
-	})
+func scrapeAll(t *testing.T, ts *ebuckstest.Server, threads int) []Product {
+	t.Helper()
 
-	mux.HandleFunc("/web/eBucks/errors/globalExceptionPage.jsp", func(rw http.ResponseWriter, r *http.Request) {
-		log.Fatal("error page visited unexpectedly")
+	m := sync.Mutex{}
+	scrapedProducts := []Product{}
+	s := newTestScraper(ts.StartURL(), threads, func(p Product) {
+		m.Lock()
+		scrapedProducts = append(scrapedProducts, p)
+		m.Unlock()
 	})
-
-	s := httptest.NewUnstartedServer(mux)
-	s.Start()
-	return s
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return scrapedProducts
 }
 
-func makeProducts(catId string, n uint) []Product {
-	ps := []Product{}
-	for i := uint(0); i < n; i++ {
-		ps = append(ps, makeProduct(catId, int(i)))
+func TestOptionsConfigureRequests(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("10", 2))
+	defer ts.Close()
+
+	rt := &recordingTransport{base: http.DefaultTransport}
+	s, err := New(
+		WithBaseURL(ts.URL),
+		WithTransport(rt),
+		WithUserAgent("test-agent"),
+		WithHeaders(http.Header{"X-Test": {"yes"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
 	}
-	return ps
-}
 
-func makeProduct(catId string, i int) Product {
-	prodId := strconv.Itoa(i)
-	return Product{
-		CatID:      catId,
-		ProdID:     prodId,
-		Name:       "Product " + prodId,
-		URL:        productURL(catId, prodId),
-		Price:      float64(i * 1000),
-		Savings:    100,
-		Percentage: 2.0,
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	// home, category, 2 products and 2 discount fragments
+	if len(rt.requests) != 6 {
+		t.Errorf("wrong number of requests through transport: got %d expected 6", len(rt.requests))
+	}
+	for _, r := range rt.requests {
+		if r.Header.Get("User-Agent") != "test-agent" || r.Header.Get("X-Test") != "yes" {
+			t.Errorf("request %q is missing configured headers: %v", r.URL, r.Header)
+		}
 	}
 }
 
-func productATags(ps []Product) string {
-	tags := []string{}
-	for _, p := range ps {
-		tags = append(tags, productATag(p))
+func TestAllowedDomainsRestrictsCrawl(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("11", 2))
+	defer ts.Close()
+
+	s, err := New(WithBaseURL(ts.URL), WithAllowedDomains("www.ebucks.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.RequestCount() != 0 {
+		t.Errorf("no requests should have been made to a disallowed domain: got %d", ts.RequestCount())
 	}
-	return strings.Join(tags, "\n")
 }
 
-func productATag(p Product) string {
-	return fmt.Sprintf(
-		`<a href="%s">%s</a>`,
-		productURL(p.CatID, p.ProdID),
-		p.Name,
-	)
+type recordingTransport struct {
+	base     http.RoundTripper
+	mutex    sync.Mutex
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	t.requests = append(t.requests, r)
+	t.mutex.Unlock()
+	return t.base.RoundTrip(r)
 }
 
-func categoryATag(c string) string {
-	return fmt.Sprintf(
-		`<a href="/web/shop/categorySelected.do?catId=%[1]s">Category %[1]s</a>`,
-		c,
+func newTestScraper(startingURL string, threads int, cb ProductPageCallbackFunc) Scraper {
+	s, err := New(
+		WithBaseURL(strings.TrimSuffix(startingURL, "/web/shop/shopHome.do")),
+		WithThreads(threads),
+		WithCallback(cb),
+		WithLogger(logging.Discard()),
 	)
+	if err != nil {
+		panic(err)
+	}
+	return s
 }
 
-func categoryATags(cs []string) string {
-	tags := []string{}
-	for _, c := range cs {
-		tags = append(tags, categoryATag(c))
+func TestScraperFollowsPagination(t *testing.T) {
+	products := ebuckstest.MakeProducts("12", 25)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.SetPageSize(4)
+
+	m := sync.Mutex{}
+	scraped := map[string]bool{}
+	pages := []int{}
+	s, err := New(
+		WithBaseURL(ts.URL),
+		WithThreads(3),
+		// later pages are no further from the home page than the first
+		WithMaxDepth(2),
+		WithLogger(logging.Discard()),
+		WithCallback(func(p Product) {
+			m.Lock()
+			defer m.Unlock()
+			scraped[p.ProdID] = true
+		}),
+		WithObserver(ObserverFunc(func(e Event) {
+			if e.Kind == EventCategory {
+				m.Lock()
+				defer m.Unlock()
+				pages = append(pages, e.Category.Page)
+				if e.Category.ProductCount != len(products) || e.Category.Pages != 7 {
+					t.Errorf("wrong category page: %+v", e.Category)
+				}
+			}
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scraped) != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scraped), len(products))
+	}
+	// the sort link doesn't count as another page
+	if ts.Hits(ebuckstest.CategoryPath) != 7 {
+		t.Errorf("wrong number of category page requests: got %d expected 7", ts.Hits(ebuckstest.CategoryPath))
+	}
+	for i, p := range pages {
+		if p != i+1 {
+			t.Errorf("pages should be fetched in order: got %v", pages)
+			break
+		}
 	}
-	return strings.Join(tags, "\n")
 }
 
-func productURL(catId string, prodId string) string {
-	return fmt.Sprintf(`/web/shop/productSelected.do?prodId=%s&amp;catId=%s`, prodId, catId)
+func TestScraperUsesProxies(t *testing.T) {
+	products := ebuckstest.MakeProducts("20", 10)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	proxies := []*ebuckstest.Proxy{ebuckstest.NewProxy(), ebuckstest.NewProxy()}
+	for _, p := range proxies {
+		defer p.Close()
+	}
+	// throttled from the start, so it's taken out of rotation
+	proxies[1].Fail(http.StatusTooManyRequests)
+
+	pool, err := proxy.New([]string{proxies[0].URL, proxies[1].URL}, proxy.PerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetLogger(logging.Discard())
+	var finish Event
+	scraped, err := scrapeWith(ts, WithThreads(2), WithProxies(pool), WithObserver(ObserverFunc(func(e Event) {
+		if e.Kind == EventFinish {
+			finish = e
+		}
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scraped) != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scraped), len(products))
+	}
+	if proxies[0].Hits() != ts.RequestCount() {
+		t.Errorf("every request should go through the working proxy: got %d of %d", proxies[0].Hits(), ts.RequestCount())
+	}
+	if len(finish.Proxies) != 2 {
+		t.Fatalf("wrong number of proxy stats: got %d expected 2", len(finish.Proxies))
+	}
+	if finish.Proxies[0].Requests != proxies[0].Hits() || !finish.Proxies[0].Healthy {
+		t.Errorf("wrong stats for working proxy: %+v", finish.Proxies[0])
+	}
+	if finish.Proxies[1].Healthy || finish.Proxies[1].Failures == 0 {
+		t.Errorf("wrong stats for throttled proxy: %+v", finish.Proxies[1])
+	}
 }
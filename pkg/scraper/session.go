@@ -0,0 +1,297 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
+)
+
+// sessionIdleTimeout is how long a session is trusted after its last request. The site's sessions time out
+// after 30 minutes of inactivity, so it's refreshed a bit before then rather than relying on being redirected.
+const sessionIdleTimeout = 25 * time.Minute
+
+// sessionHeader carries the number of the session a colly request was given from OnRequest to the pool, which
+// takes it off again before the request is sent. Colly requests have no context of their own to carry it in.
+const sessionHeader = "X-Ebucks-Dealz-Session"
+
+// sessionPool is a transport that gives every chain of requests a session of its own: a cookie jar that was
+// started by fetching the home page, like a browser would. A chain is a page along with any requests made because
+// of it, like a product page and its discount fragment, which the site expects to come from the same session.
+// Sharing one jar between workers made the site mix up responses. Sessions are reused by later chains, so a crawl
+// with n workers uses n sessions.
+type sessionPool struct {
+	base    http.RoundTripper
+	homeURL string
+	logger  *slog.Logger
+
+	// credentials are used to log every session in, nil to stay logged out
+	credentials *Credentials
+	// sessionFile is where logged-in sessions are saved for the next run, empty to not save them
+	sessionFile string
+	fileMutex   sync.Mutex
+
+	// max is the most sessions there can be, 0 for no limit
+	max      int
+	mutex    sync.Mutex
+	sessions []*session
+}
+
+type session struct {
+	id int
+	// lock is held while a request is made with the session; it's a channel so waiting for it can be cancelled
+	lock chan struct{}
+	// chains is the number of leases on the session that haven't been released
+	chains int
+
+	jar      *cookiejar.Jar
+	started  bool
+	lastUsed time.Time
+	// sessionCookies counts the cookies the site has set
+	sessionCookies int
+}
+
+// sessionLease ties a chain of requests to a session until it is released
+type sessionLease struct {
+	sess     *session
+	released bool
+}
+
+type sessionContextKey struct{}
+
+// withSession makes the requests made with ctx use the session of l
+func withSession(ctx context.Context, l *sessionLease) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, l)
+}
+
+// newSessionPool creates a pool of at most max sessions (0 for no limit) for the shop whose home page is homeURL.
+func newSessionPool(base http.RoundTripper, homeURL string, max int, logger *slog.Logger) *sessionPool {
+	return &sessionPool{base: base, homeURL: homeURL, max: max, logger: logger}
+}
+
+func (p *sessionPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	sess, err := p.sessionFor(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := sess.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	defer sess.unlock()
+
+	isHome := strings.HasSuffix(req.URL.Path, "/shopHome.do")
+	if !sess.live(req.URL) {
+		if isHome && p.credentials == nil {
+			// the request starts the session itself
+			resp, err := p.send(sess, req)
+			if err == nil {
+				sess.started = true
+			}
+			return resp, err
+		}
+		if err := p.start(sess, req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := p.send(sess, req)
+	if err != nil {
+		return nil, err
+	}
+	expired := !isHome && redirectsHome(resp)
+	if !expired && p.credentials != nil {
+		if expired, err = loggedOut(resp); err != nil {
+			return nil, err
+		}
+	}
+	if !expired {
+		return resp, nil
+	}
+
+	// the session has timed out or been logged out, so start another and try again
+	p.logger.Debug("session expired", "session", sess.id, logging.KeyURL, req.URL.String())
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err := p.start(sess, req); err != nil {
+		return nil, err
+	}
+	return p.send(sess, req)
+}
+
+// sessionFor returns the session req was leased, from its header or its context. Requests without one get the
+// least used session.
+func (p *sessionPool) sessionFor(req *http.Request) (*session, error) {
+	if v := req.Header.Get(sessionHeader); v != "" {
+		id, err := strconv.Atoi(v)
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if err != nil || id < 1 || id > len(p.sessions) {
+			return nil, fmt.Errorf("unknown session %q", v)
+		}
+		return p.sessions[id-1], nil
+	}
+	if l, ok := req.Context().Value(sessionContextKey{}).(*sessionLease); ok {
+		return l.sess, nil
+	}
+	l := p.lease()
+	p.release(l)
+	return l.sess, nil
+}
+
+// lease gives a new chain of requests the session with the fewest chains, starting another one if they are
+// all in use and there can be more
+func (p *sessionPool) lease() *sessionLease {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var least *session
+	for _, sess := range p.sessions {
+		if least == nil || sess.chains < least.chains {
+			least = sess
+		}
+	}
+	if least == nil || (least.chains > 0 && (p.max == 0 || len(p.sessions) < p.max)) {
+		least = p.newSession()
+	}
+	least.chains++
+	return &sessionLease{sess: least}
+}
+
+// release ends a lease; releasing it again does nothing
+func (p *sessionPool) release(l *sessionLease) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !l.released {
+		l.released = true
+		l.sess.chains--
+	}
+}
+
+// newSession must be called with the mutex held
+func (p *sessionPool) newSession() *session {
+	// the jar can't fail without a public suffix list
+	jar, _ := cookiejar.New(nil)
+	sess := &session{id: len(p.sessions) + 1, lock: make(chan struct{}, 1), jar: jar}
+	p.sessions = append(p.sessions, sess)
+	if p.credentials != nil {
+		p.restore(sess)
+	}
+	return sess
+}
+
+// checkLogin starts a session straight away to find out whether the credentials work
+func (p *sessionPool) checkLogin(ctx context.Context) error {
+	if p.credentials == nil {
+		return nil
+	}
+	l := p.lease()
+	defer p.release(l)
+	sess := l.sess
+	if err := sess.acquire(ctx); err != nil {
+		return err
+	}
+	defer sess.unlock()
+	if sess.started {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.homeURL, nil)
+	if err != nil {
+		return err
+	}
+	return p.start(sess, req)
+}
+
+// acquire waits until nothing else is using the session
+func (s *session) acquire(ctx context.Context) error {
+	select {
+	case s.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *session) unlock() {
+	<-s.lock
+}
+
+// start throws away sess's cookies and gets new ones from the home page, using the headers of req,
+// then logs in if there are credentials
+func (p *sessionPool) start(sess *session, req *http.Request) error {
+	home, err := http.NewRequestWithContext(req.Context(), http.MethodGet, p.homeURL, nil)
+	if err != nil {
+		return err
+	}
+	home.Header = req.Header.Clone()
+	home.Header.Del("Referer")
+	home.Header.Del("Cookie")
+
+	// the jar can't fail without a public suffix list
+	sess.jar, _ = cookiejar.New(nil)
+	sess.sessionCookies = 0
+	resp, err := p.send(sess, home)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	p.logger.Debug("started session", "session", sess.id, logging.KeyStatus, resp.StatusCode)
+	if p.credentials != nil {
+		if err := p.login(sess, req); err != nil {
+			return err
+		}
+	}
+	sess.started = true
+	return nil
+}
+
+// send makes req with sess's cookies and keeps the ones it sets
+func (p *sessionPool) send(sess *session, req *http.Request) (*http.Response, error) {
+	// each session is a worker as far as the proxies are concerned, so it keeps its proxy
+	r := req.Clone(proxy.WithWorker(req.Context(), sess.id))
+	r.Header.Del(sessionHeader)
+	r.Header.Del("Cookie")
+	for _, c := range sess.jar.Cookies(r.URL) {
+		r.AddCookie(c)
+	}
+
+	resp, err := p.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if cs := resp.Cookies(); len(cs) > 0 {
+		sess.jar.SetCookies(r.URL, cs)
+		sess.sessionCookies += len(cs)
+	}
+	sess.lastUsed = time.Now()
+	return resp, nil
+}
+
+// live says whether the session has been started, hasn't been idle long enough to have timed out,
+// and still has cookies for u if the site gave it any
+func (s *session) live(u *url.URL) bool {
+	if !s.started || time.Since(s.lastUsed) >= sessionIdleTimeout {
+		return false
+	}
+	return s.sessionCookies == 0 || len(s.jar.Cookies(u)) > 0
+}
+
+// redirectsHome says whether resp sends the browser back to the home page, which is what the site does
+// when a session has timed out
+func redirectsHome(resp *http.Response) bool {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+	loc, err := resp.Location()
+	return err == nil && strings.HasSuffix(loc.Path, "/shopHome.do")
+}
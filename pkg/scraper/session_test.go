@@ -0,0 +1,150 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func TestScraperUsesSessionPerWorker(t *testing.T) {
+	products := ebuckstest.MakeProducts("13", 20)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.RequireSession()
+
+	rt := &recordingTransport{base: http.DefaultTransport}
+	m := sync.Mutex{}
+	scraped := 0
+	s, err := New(
+		WithBaseURL(ts.URL),
+		WithTransport(rt),
+		WithThreads(3),
+		WithLogger(logging.Discard()),
+		WithCallback(func(p Product) {
+			m.Lock()
+			defer m.Unlock()
+			scraped++
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if scraped != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", scraped, len(products))
+	}
+	if ts.Sessions() < 1 || ts.Sessions() > 3 {
+		t.Errorf("wrong number of sessions: got %d expected 1 to 3", ts.Sessions())
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	for _, r := range rt.requests {
+		referer := r.Header.Get("Referer")
+		switch PageTypeOf(r.URL) {
+		case PageHome:
+			if referer != "" {
+				t.Errorf("home page request %q should have no referer: got %q", r.URL, referer)
+			}
+			continue
+		case PageCategory:
+			if PageTypeOf(mustParse(t, referer)) != PageHome {
+				t.Errorf("category request %q should be referred by the home page: got %q", r.URL, referer)
+			}
+		case PageProduct:
+			if PageTypeOf(mustParse(t, referer)) != PageCategory {
+				t.Errorf("product request %q should be referred by its category: got %q", r.URL, referer)
+			}
+		case PageDiscount:
+			if expected := strings.Replace(r.URL.String(), "productSelectedDiscount.do", "productSelected.do", 1); referer != expected {
+				t.Errorf("discount request %q should be referred by its product: got %q", r.URL, referer)
+			}
+		}
+		if _, err := r.Cookie(ebuckstest.SessionCookie); err != nil {
+			t.Errorf("request %q has no session cookie", r.URL)
+		}
+	}
+}
+
+func TestScraperFetchesDiscountsOnProductSession(t *testing.T) {
+	products := ebuckstest.MakeProducts("16", 60)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.RequireSession()
+	// so the workers' requests overlap
+	ts.SetLatency(time.Millisecond, 5*time.Millisecond)
+
+	scrapedProducts := scrapeAll(t, ts, 4)
+	if len(scrapedProducts) != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scrapedProducts), len(products))
+	}
+	if n := ts.SessionMismatches(); n != 0 {
+		t.Errorf("%d discount fragments were fetched on a different session than their product page", n)
+	}
+	if ts.Sessions() > 4 {
+		t.Errorf("wrong number of sessions: got %d expected at most 4", ts.Sessions())
+	}
+}
+
+func TestScraperRestartsExpiredSessions(t *testing.T) {
+	products := ebuckstest.MakeProducts("14", 10)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.RequireSession()
+	// after the home and category pages, and a couple of products
+	ts.AfterRequests(4, func(s *ebuckstest.Server) {
+		s.ExpireSessions()
+	})
+
+	scrapedProducts := scrapeAll(t, ts, 1)
+	if len(scrapedProducts) != len(products) {
+		t.Errorf("wrong number of scraped products: got %d expected %d", len(scrapedProducts), len(products))
+	}
+	if ts.Sessions() != 2 {
+		t.Errorf("wrong number of sessions: got %d expected 2", ts.Sessions())
+	}
+}
+
+func TestClientStartsSession(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("15", 2))
+	defer ts.Close()
+	ts.RequireSession()
+
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetLogger(logging.Discard())
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetProduct(context.Background(), "1", "15"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the session is kept between calls
+	if ts.Sessions() != 1 {
+		t.Errorf("wrong number of sessions: got %d expected 1", ts.Sessions())
+	}
+	if ts.Hits(ebuckstest.HomePath) != 1 {
+		t.Errorf("wrong number of home page requests: got %d expected 1", ts.Hits(ebuckstest.HomePath))
+	}
+}
+
+func mustParse(t *testing.T, link string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
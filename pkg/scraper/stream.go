@@ -0,0 +1,100 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+)
+
+// ScrapeError is a non-fatal error for a single URL; the crawl carries on without that page.
+type ScrapeError struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *ScrapeError) Error() string {
+	return fmt.Sprintf("%s [%d]: %s", e.URL, e.StatusCode, e.Err)
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// sink is where scraped products and errors end up: the callback given to NewScraper and/or the channels of Stream.
+type sink struct {
+	ctx      context.Context
+	callback ProductPageCallbackFunc
+	products chan Product
+	errors   chan error
+}
+
+// Stream runs the crawl in the background and delivers products and errors over channels instead of (as well as) the callback.
+//
+// Products are sent one at a time from the scraper's workers, so a slow consumer slows the crawl down rather than
+// letting products pile up in memory. Errors are mostly *ScrapeError for pages that were skipped; if the crawl itself
+// fails, that error is sent last. When the crawl finishes the product channel is closed first, then the error channel,
+// so callers should keep receiving from both until both are closed, e.g.
+//
+//	products, errs := s.Stream(ctx)
+//	for products != nil || errs != nil {
+//		select {
+//		case p, ok := <-products:
+//			if !ok {
+//				products = nil
+//				continue
+//			}
+//			...
+//		case err, ok := <-errs:
+//			if !ok {
+//				errs = nil
+//				continue
+//			}
+//			...
+//		}
+//	}
+//
+// Cancelling ctx stops the crawl: queued requests are dropped, requests in flight are finished but their products
+// are discarded, and then both channels are closed. Callers that cancel may stop receiving straight away; the final
+// error is then dropped instead of being waited on. A Scraper can only be run once, with either Start or Stream.
+func (s Scraper) Stream(ctx context.Context) (<-chan Product, <-chan error) {
+	s.sink.products = make(chan Product)
+	s.sink.errors = make(chan error)
+
+	go func() {
+		err := s.start(ctx)
+		close(s.sink.products)
+		if err != nil {
+			// not reportError: the crawl's context is done by now, e.g. when an outage stopped it, so only the
+			// caller's context says whether anyone is still receiving
+			select {
+			case s.sink.errors <- err:
+			case <-ctx.Done():
+			}
+		}
+		close(s.sink.errors)
+	}()
+
+	return s.sink.products, s.sink.errors
+}
+
+func (s Scraper) emit(p Product) {
+	s.observe(Event{Kind: EventProduct, URL: p.URL, PageType: PageProduct, Product: &p})
+	if s.sink.callback != nil {
+		s.sink.callback(p)
+	}
+	if s.sink.products != nil {
+		select {
+		case s.sink.products <- p:
+		case <-s.sink.ctx.Done():
+		}
+	}
+}
+
+func (s Scraper) reportError(err error) {
+	if s.sink.errors != nil {
+		select {
+		case s.sink.errors <- err:
+		case <-s.sink.ctx.Done():
+		}
+	}
+}
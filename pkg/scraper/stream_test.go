@@ -0,0 +1,126 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+)
+
+func TestStreamDeliversProductsAndErrors(t *testing.T) {
+	products := ebuckstest.MakeProducts("5", 50)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.BreakProduct("7")
+
+	s := newTestScraper(ts.StartURL(), 4, nil)
+	ps, errs := s.Stream(context.Background())
+
+	numProducts := 0
+	scrapeErrors := []*ScrapeError{}
+	for ps != nil || errs != nil {
+		select {
+		case _, ok := <-ps:
+			if !ok {
+				ps = nil
+				continue
+			}
+			// a slow consumer must not lose products
+			time.Sleep(time.Millisecond)
+			numProducts++
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			var scrapeErr *ScrapeError
+			if !errors.As(err, &scrapeErr) {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			scrapeErrors = append(scrapeErrors, scrapeErr)
+		}
+	}
+
+	if numProducts != len(products)-1 {
+		t.Errorf("wrong number of products: got %d expected %d", numProducts, len(products)-1)
+	}
+	if len(scrapeErrors) != 1 || !errors.Is(scrapeErrors[0], ErrRedirectToErrorPage) {
+		t.Errorf("expected a single redirect error: got %v", scrapeErrors)
+	}
+}
+
+func TestStreamStopsWhenCancelled(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("6", 1000))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newTestScraper(ts.StartURL(), 4, nil)
+	ps, errs := s.Stream(ctx)
+
+	<-ps
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range ps {
+		}
+		for range errs {
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("channels were not closed after cancelling")
+	}
+
+	if n := ts.Hits(ebuckstest.ProductPath); n >= 1000 {
+		t.Errorf("crawl should have stopped early: got %d product page hits", n)
+	}
+}
+
+func TestStreamFinishesWhenCancelledAndNotReceived(t *testing.T) {
+	ts := ebuckstest.NewServer(ebuckstest.MakeProducts("17", 1000))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	s, err := New(
+		WithBaseURL(ts.URL),
+		WithThreads(4),
+		WithLogger(logging.Discard()),
+		WithObserver(ObserverFunc(func(e Event) {
+			if e.Kind == EventFinish {
+				close(finished)
+			}
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errs := s.Stream(ctx)
+	cancel()
+
+	select {
+	case <-finished:
+	case <-time.After(30 * time.Second):
+		t.Fatal("crawl did not finish after cancelling")
+	}
+	// give the stream a moment to give up on sending the final error, which nobody is receiving
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err, ok := <-errs:
+		if ok {
+			t.Errorf("the stream was still trying to send its final error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the error channel was not closed")
+	}
+}
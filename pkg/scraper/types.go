@@ -1,23 +1,37 @@
 package scraper
 
 import (
+	"log/slog"
+	"net/http"
 	"sync"
 
+	"github.com/geniass/ebucks-dealz/pkg/proxy"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/queue"
 )
 
 type Scraper struct {
 	startingURL string
+	maxRetries  int
 	colly       *colly.Collector
 	q           *queue.Queue
+	storage     *PriorityQueueStorage
+	transport   http.RoundTripper
+	sessions    *sessionPool
+	proxies     *proxy.Pool
 
 	mutex       *sync.Mutex
 	urlBackoffs map[string]int
-	links       map[string]int
-	scraped     map[string]int
 
-	urlChan chan string
+	sink      *sink
+	observers []Observer
+	debug     *debugState
+	outage    *outageDetector
+	scope     *scope
+	// listings are the tiles of products whose pages haven't been scraped yet, by prodId/catId
+	listings  map[string]ListingTile
+	logger    *slog.Logger
+	runConfig RunConfig
 }
 
 type Product struct {
@@ -28,27 +42,30 @@ type Product struct {
 	Price      float64
 	Savings    float64
 	Percentage float64
+	// MemberLevel is the eBucks level the prices apply to when scraped by a logged-in member, 0 otherwise
+	MemberLevel int `json:",omitempty"`
 }
 
 type ebucksProductDetail struct {
 	ProductDetail struct {
-		ID       int              `json:"id"`
-		Discount []ebucksDiscount `json:"discount"`
+		ID       int        `json:"id"`
+		Discount []Discount `json:"discount"`
 	} `json:"productDetail"`
 }
 
-type ebucksDiscount struct {
+// Discount is a single tier of a product's discount table.
+type Discount struct {
 	Level         int `json:"discountLevel"`
 	Percent       int `json:"discountPercent"`
 	EbucksPrice   int `json:"discountPrice"`  // The price is given in ebucks, not rands
 	EbucksSavings int `json:"discountSaving"` // The price is given in ebucks, not rands
 }
 
-func (d ebucksDiscount) RandPrice() float64 {
+func (d Discount) RandPrice() float64 {
 	return ebucksToRands(d.EbucksPrice)
 }
 
-func (d ebucksDiscount) RandSavings() float64 {
+func (d Discount) RandSavings() float64 {
 	return ebucksToRands(d.EbucksSavings)
 }
 
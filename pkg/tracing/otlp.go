@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"strconv"
+	"time"
+)
+
+// The subset of the OTLP/JSON trace format (ExportTraceServiceRequest) that we write.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	statusOK    = 1
+	statusError = 2
+)
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            status      `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	// int64 values are strings in OTLP/JSON
+	IntValue  *string `json:"intValue,omitempty"`
+	BoolValue *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttr(k string, v string) attribute {
+	return attribute{Key: k, Value: attributeValue{StringValue: &v}}
+}
+
+func intAttr(k string, v int) attribute {
+	s := strconv.Itoa(v)
+	return attribute{Key: k, Value: attributeValue{IntValue: &s}}
+}
+
+func boolAttr(k string, v bool) attribute {
+	return attribute{Key: k, Value: attributeValue{BoolValue: &v}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,226 @@
+// Package tracing records a crawl as a tree of spans and writes them in OTLP/JSON, so that one product's path
+// (category page, product page, discount fragment, retries, emit) can be inspected after the fact.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+const scopeName = "github.com/geniass/ebucks-dealz/pkg/tracing"
+
+// spans are written in batches of this size, as one OTLP/JSON request per line
+const batchSize = 512
+
+// Tracer is a scraper.Observer that writes a span for the crawl, one for every URL (a child of the page it
+// was found on), one for every attempt at fetching a URL and one for every emitted product (a child of its
+// discount fragment). All spans of a crawl share a trace ID.
+type Tracer struct {
+	serviceName string
+
+	mutex   *sync.Mutex
+	enc     *json.Encoder
+	traceID string
+	root    *span
+	// span IDs of every URL seen so far, to find the parents of pages found later
+	spanIDs map[string]string
+	// URLs and attempts that haven't finished yet
+	pages    map[string]*span
+	attempts map[string]*span
+	// the discount fragment of each product page, which emitted products hang off
+	discounts map[string]string
+	batch     []span
+	err       error
+}
+
+// NewTracer creates a tracer that writes spans to w as OTLP/JSON lines (like the OpenTelemetry collector's file exporter).
+func NewTracer(w io.Writer, serviceName string) *Tracer {
+	return &Tracer{
+		serviceName: serviceName,
+		mutex:       &sync.Mutex{},
+		enc:         json.NewEncoder(w),
+		traceID:     newID(16),
+		spanIDs:     make(map[string]string),
+		pages:       make(map[string]*span),
+		attempts:    make(map[string]*span),
+		discounts:   make(map[string]string),
+	}
+}
+
+// Err returns the first error encountered writing spans.
+func (t *Tracer) Err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.err
+}
+
+func (t *Tracer) Observe(e scraper.Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch e.Kind {
+	case scraper.EventStart:
+		t.root = t.newSpan("crawl", "", spanKindInternal, e.Time)
+		t.root.Attributes = append(t.root.Attributes, stringAttr("url", e.URL))
+		if e.Config != nil {
+			t.root.Attributes = append(t.root.Attributes, stringAttr("baseUrl", e.Config.BaseURL), intAttr("threads", e.Config.Threads))
+		}
+
+	case scraper.EventRequest:
+		page, ok := t.pages[e.URL]
+		if !ok {
+			page = t.newSpan(string(e.PageType), t.parentID(e.Parent), spanKindInternal, e.Time)
+			page.Attributes = urlAttributes(e)
+			t.pages[e.URL] = page
+			t.spanIDs[e.URL] = page.SpanID
+			if e.PageType == scraper.PageDiscount {
+				t.discounts[e.Parent] = page.SpanID
+			}
+		}
+		attempt := t.newSpan("GET", page.SpanID, spanKindClient, e.Time)
+		attempt.Attributes = append(urlAttributes(e), intAttr("attempt", e.Attempt))
+		t.attempts[e.URL] = attempt
+
+	case scraper.EventResponse, scraper.EventError:
+		attempt, ok := t.attempts[e.URL]
+		if ok {
+			delete(t.attempts, e.URL)
+			end(attempt, e)
+			if e.Kind == scraper.EventError {
+				attempt.Attributes = append(attempt.Attributes, boolAttr("retrying", e.Retrying))
+			}
+			t.finish(*attempt)
+		}
+		if e.Retrying {
+			return
+		}
+		if page, ok := t.pages[e.URL]; ok {
+			delete(t.pages, e.URL)
+			end(page, e)
+			page.Attributes = append(page.Attributes, intAttr("attempts", e.Attempt))
+			t.finish(*page)
+		}
+
+	case scraper.EventProduct:
+		parent := t.discounts[e.URL]
+		if parent == "" {
+			parent = t.parentID(e.URL)
+		}
+		emit := t.newSpan("emit", parent, spanKindInternal, e.Time)
+		emit.EndTimeUnixNano = emit.StartTimeUnixNano
+		emit.Status = status{Code: statusOK}
+		emit.Attributes = urlAttributes(e)
+		if e.Product != nil {
+			emit.Attributes = append(emit.Attributes, stringAttr("name", e.Product.Name), intAttr("percentage", int(e.Product.Percentage)))
+		}
+		delete(t.discounts, e.URL)
+		t.finish(*emit)
+
+	case scraper.EventFinish:
+		// anything still open was cut short
+		for u, s := range t.attempts {
+			s.EndTimeUnixNano = unixNano(e.Time)
+			s.Status = status{Code: statusError, Message: "unfinished"}
+			t.finish(*s)
+			delete(t.attempts, u)
+		}
+		for u, s := range t.pages {
+			s.EndTimeUnixNano = unixNano(e.Time)
+			s.Status = status{Code: statusError, Message: "unfinished"}
+			t.finish(*s)
+			delete(t.pages, u)
+		}
+		if t.root != nil {
+			t.root.EndTimeUnixNano = unixNano(e.Time)
+			t.root.Status = status{Code: statusOK}
+			if e.Err != nil {
+				t.root.Status = status{Code: statusError, Message: e.Err.Error()}
+			}
+			t.finish(*t.root)
+		}
+		t.flush()
+	}
+}
+
+// parentID returns the span of the page u, or the crawl's span if there isn't one
+func (t *Tracer) parentID(u string) string {
+	if id, ok := t.spanIDs[u]; ok {
+		return id
+	}
+	if t.root != nil {
+		return t.root.SpanID
+	}
+	return ""
+}
+
+func (t *Tracer) newSpan(name string, parentID string, kind int, start time.Time) *span {
+	return &span{
+		TraceID:           t.traceID,
+		SpanID:            newID(8),
+		ParentSpanID:      parentID,
+		Name:              name,
+		Kind:              kind,
+		StartTimeUnixNano: unixNano(start),
+	}
+}
+
+func end(s *span, e scraper.Event) {
+	s.EndTimeUnixNano = unixNano(e.Time)
+	if e.StatusCode != 0 {
+		s.Attributes = append(s.Attributes, intAttr("http.status_code", e.StatusCode))
+	}
+	if e.Err != nil {
+		s.Status = status{Code: statusError, Message: e.Err.Error()}
+	} else {
+		s.Status = status{Code: statusOK}
+	}
+}
+
+func (t *Tracer) finish(s span) {
+	t.batch = append(t.batch, s)
+	if len(t.batch) >= batchSize {
+		t.flush()
+	}
+}
+
+func (t *Tracer) flush() {
+	if len(t.batch) == 0 || t.err != nil {
+		return
+	}
+	t.err = t.enc.Encode(exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []attribute{stringAttr("service.name", t.serviceName)}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: t.batch}},
+	}}})
+	t.batch = nil
+}
+
+// urlAttributes describes the page of e, including its product and category IDs if it has them
+func urlAttributes(e scraper.Event) []attribute {
+	attrs := []attribute{stringAttr("url", e.URL), stringAttr("pageType", string(e.PageType))}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return attrs
+	}
+	q := u.Query()
+	if prodID := q.Get("prodId"); prodID != "" {
+		attrs = append(attrs, stringAttr("prodId", prodID))
+	}
+	if catID := q.Get("catId"); catID != "" {
+		attrs = append(attrs, stringAttr("catId", catID))
+	}
+	return attrs
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	// crypto/rand doesn't fail on the platforms we run on
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestTracerFollowsProductChain(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	products[0].Discounts = ebuckstest.Levels
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	// home, category, then the first product page fails once
+	ts.AfterRequests(2, func(s *ebuckstest.Server) {
+		s.FailNext(1, http.StatusServiceUnavailable)
+	})
+
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, "test")
+	s, err := scraper.New(scraper.WithBaseURL(ts.URL), scraper.WithObserver(tracer), scraper.WithLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]span{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var req exportRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			spans[s.SpanID] = s
+		}
+	}
+
+	emits := 0
+	retried := 0
+	for _, s := range spans {
+		if s.Name == "GET" && s.Status.Code == statusError {
+			retried++
+		}
+		if s.Name != "emit" {
+			continue
+		}
+		emits++
+
+		// walk up to the root
+		chain := []string{}
+		for id := s.SpanID; id != ""; id = spans[id].ParentSpanID {
+			p, ok := spans[id]
+			if !ok {
+				t.Fatalf("missing parent span %q in chain %v", id, chain)
+			}
+			if p.TraceID != s.TraceID {
+				t.Errorf("span %q has a different trace ID", p.Name)
+			}
+			chain = append(chain, p.Name)
+		}
+		expected := []string{"emit", "discount", "product", "category", "home", "crawl"}
+		if len(chain) != len(expected) {
+			t.Fatalf("wrong span chain: got %v expected %v", chain, expected)
+		}
+		for i := range chain {
+			if chain[i] != expected[i] {
+				t.Fatalf("wrong span chain: got %v expected %v", chain, expected)
+			}
+		}
+	}
+
+	if emits != len(products) {
+		t.Errorf("wrong number of emit spans: got %d expected %d", emits, len(products))
+	}
+	if retried != 1 {
+		t.Errorf("wrong number of failed attempts: got %d expected 1", retried)
+	}
+}
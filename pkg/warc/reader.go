@@ -0,0 +1,180 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive is a read-only view of a directory written by Writer.
+type Archive struct {
+	dir     string
+	entries []IndexEntry
+}
+
+// Record is a single decoded WARC record.
+type Record struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// Open loads the index of the archive in dir.
+func Open(dir string) (*Archive, error) {
+	f, err := os.Open(filepath.Join(dir, IndexFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &Archive{dir: dir}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed index line", IndexFilename, line)
+		}
+
+		var e IndexEntry
+		if err := json.Unmarshal([]byte(fields[2]), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", IndexFilename, line, err)
+		}
+		e.Timestamp, err = time.Parse(timestampFormat, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", IndexFilename, line, err)
+		}
+		a.entries = append(a.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(a.entries, func(i, j int) bool {
+		if a.entries[i].URL != a.entries[j].URL {
+			return a.entries[i].URL < a.entries[j].URL
+		}
+		return a.entries[i].Timestamp.Before(a.entries[j].Timestamp)
+	})
+	return a, nil
+}
+
+// Entries returns all index entries, sorted by URL and then timestamp.
+func (a *Archive) Entries() []IndexEntry {
+	return a.entries
+}
+
+// Lookup returns all captures of url, oldest first.
+func (a *Archive) Lookup(url string) []IndexEntry {
+	start := sort.Search(len(a.entries), func(i int) bool { return a.entries[i].URL >= url })
+	end := start
+	for end < len(a.entries) && a.entries[end].URL == url {
+		end++
+	}
+	return a.entries[start:end:end]
+}
+
+// Closest returns the capture of url nearest to t.
+func (a *Archive) Closest(url string, t time.Time) (IndexEntry, bool) {
+	var best IndexEntry
+	found := false
+	for _, e := range a.Lookup(url) {
+		if !found || absDuration(e.Timestamp.Sub(t)) < absDuration(best.Timestamp.Sub(t)) {
+			best = e
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Filter returns all entries for which match returns true.
+func (a *Archive) Filter(match func(e IndexEntry) bool) []IndexEntry {
+	var es []IndexEntry
+	for _, e := range a.entries {
+		if match(e) {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+// ReadRecord reads the record that e points at.
+func (a *Archive) ReadRecord(e IndexEntry) (*Record, error) {
+	f, err := os.Open(filepath.Join(a.dir, e.Filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(io.NewSectionReader(f, e.Offset, e.Length))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	gz.Multistream(false)
+
+	return readRecord(bufio.NewReader(gz))
+}
+
+// Response parses the record block as an HTTP response.
+func (r *Record) Response() (*http.Response, error) {
+	if r.Header.Get("WARC-Type") != "response" {
+		return nil, fmt.Errorf("record is a %q record, not a response", r.Header.Get("WARC-Type"))
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Block)), nil)
+}
+
+// Body returns the HTTP payload of a response record.
+func (r *Record) Body() ([]byte, error) {
+	resp, err := r.Response()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func readRecord(br *bufio.Reader) (*Record, error) {
+	tp := textproto.NewReader(br)
+	version, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("not a WARC record: %q", version)
+	}
+
+	h, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %w", err)
+	}
+
+	block := make([]byte, n)
+	if _, err := io.ReadFull(br, block); err != nil {
+		return nil, err
+	}
+	return &Record{Header: h, Block: block}, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
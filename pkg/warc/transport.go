@@ -0,0 +1,47 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Transport is an http.RoundTripper that archives every exchange made through Base.
+type Transport struct {
+	Base   http.RoundTripper
+	Writer *Writer
+	// Logger is told about archiving failures; nil means slog.Default()
+	Logger *slog.Logger
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	at := time.Now()
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// archiving failures shouldn't break the crawl
+	if err := t.Writer.WriteExchange(req, resp, body, at); err != nil {
+		logger := t.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Warn("failed to archive exchange", "url", req.URL.String(), "error", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,95 @@
+package warc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransportArchivesAndArchiveReadsBack(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html>%s</html>", r.URL.Query().Get("prodId"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	// tiny max size so every exchange ends up in its own file
+	w, err := NewWriter(dir, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &Transport{Writer: w}}
+	for _, id := range []string{"1", "2", "3"} {
+		resp, err := client.Get(ts.URL + "/web/shop/productSelected.do?prodId=" + id + "&catId=9")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "<html>"+id+"</html>" {
+			t.Errorf("body not passed through: got %q", body)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	if len(files) < 3 {
+		t.Errorf("expected files to be rotated: got %d files", len(files))
+	}
+
+	a, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Entries()) != 3 {
+		t.Fatalf("wrong number of index entries: got %d expected 3", len(a.Entries()))
+	}
+
+	u := ts.URL + "/web/shop/productSelected.do?prodId=2&catId=9"
+	e, ok := a.Closest(u, time.Now())
+	if !ok {
+		t.Fatalf("%q not found in index", u)
+	}
+	if e.Status != http.StatusOK {
+		t.Errorf("wrong status: got %d", e.Status)
+	}
+
+	r, err := a.ReadRecord(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header.Get("WARC-Target-URI"); got != u {
+		t.Errorf("wrong target URI: got %q expected %q", got, u)
+	}
+	body, err := r.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "<html>2</html>" {
+		t.Errorf("wrong archived body: got %q", body)
+	}
+}
+
+func TestNewWriterDoesNotClobberExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		w, err := NewWriter(dir, "run", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "run-00001.warc.gz")); err != nil {
+		t.Errorf("second writer should have started a new file: %s", err)
+	}
+}
@@ -0,0 +1,268 @@
+// Package warc writes and reads WARC 1.1 archives of HTTP exchanges.
+//
+// Every record is stored as its own gzip member so that a record can be read
+// directly from its offset in the file. Alongside the .warc.gz files a CDXJ
+// style index (index.cdxj) lists every archived response by URL and timestamp.
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	IndexFilename = "index.cdxj"
+
+	// timestampFormat is the 14 digit timestamp used by CDX indexes.
+	timestampFormat = "20060102150405"
+
+	// DefaultMaxFileSize is the size after which a new WARC file is started.
+	DefaultMaxFileSize int64 = 1 << 30
+)
+
+// Writer appends request/response records to a set of WARC files in a directory,
+// starting a new file whenever the current one grows beyond maxFileSize.
+// It is safe for concurrent use.
+type Writer struct {
+	dir         string
+	prefix      string
+	maxFileSize int64
+
+	mutex    *sync.Mutex
+	seq      int
+	file     *os.File
+	filename string
+	offset   int64
+	index    *os.File
+}
+
+// NewWriter creates dir if needed and opens the first WARC file in it.
+// maxFileSize <= 0 means DefaultMaxFileSize.
+func NewWriter(dir string, prefix string, maxFileSize int64) (*Writer, error) {
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return nil, err
+	}
+
+	index, err := os.OpenFile(filepath.Join(dir, IndexFilename), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		dir:         dir,
+		prefix:      prefix,
+		maxFileSize: maxFileSize,
+		mutex:       &sync.Mutex{},
+		index:       index,
+	}
+	if err := w.rotate(); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteExchange archives a request and its response as a pair of concurrent records.
+// body is the already read response body, resp.Body is not touched.
+func (w *Writer) WriteExchange(req *http.Request, resp *http.Response, body []byte, at time.Time) error {
+	respBlock := &bytes.Buffer{}
+	fmt.Fprintf(respBlock, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	if err := headerWithoutLength(resp.Header).Write(respBlock); err != nil {
+		return err
+	}
+	fmt.Fprintf(respBlock, "Content-Length: %d\r\n\r\n", len(body))
+	respBlock.Write(body)
+
+	reqBlock := &bytes.Buffer{}
+	fmt.Fprintf(reqBlock, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.URL.Host)
+	if err := req.Header.Write(reqBlock); err != nil {
+		return err
+	}
+	reqBlock.WriteString("\r\n")
+
+	respID := newRecordID()
+	reqID := newRecordID()
+	target := req.URL.String()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.offset >= w.maxFileSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	offset := w.offset
+	length, err := w.writeRecord(http.Header{
+		"WARC-Type":         {"response"},
+		"WARC-Record-ID":    {respID},
+		"WARC-Date":         {at.UTC().Format(time.RFC3339)},
+		"WARC-Target-URI":   {target},
+		"Content-Type":      {"application/http;msgtype=response"},
+		"WARC-Block-Digest": {digest(respBlock.Bytes())},
+	}, respBlock.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.writeRecord(http.Header{
+		"WARC-Type":          {"request"},
+		"WARC-Record-ID":     {reqID},
+		"WARC-Date":          {at.UTC().Format(time.RFC3339)},
+		"WARC-Target-URI":    {target},
+		"WARC-Concurrent-To": {respID},
+		"Content-Type":       {"application/http;msgtype=request"},
+		"WARC-Block-Digest":  {digest(reqBlock.Bytes())},
+	}, reqBlock.Bytes()); err != nil {
+		return err
+	}
+
+	return w.writeIndexEntry(IndexEntry{
+		URL:       target,
+		Timestamp: at.UTC().Truncate(time.Second),
+		Status:    resp.StatusCode,
+		Mime:      resp.Header.Get("Content-Type"),
+		Digest:    digest(body),
+		Filename:  w.filename,
+		Offset:    offset,
+		Length:    length,
+	})
+}
+
+// Close flushes and closes the current WARC file and the index.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.file.Close()
+	if ierr := w.index.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+// rotate closes the current file (if any) and starts the next one with a warcinfo record.
+// Must be called with the mutex held.
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		w.filename = fmt.Sprintf("%s-%05d.warc.gz", w.prefix, w.seq)
+		w.seq++
+		f, err := os.OpenFile(filepath.Join(w.dir, w.filename), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		w.file = f
+		w.offset = 0
+		break
+	}
+
+	info := []byte("software: ebucks-dealz\r\nformat: WARC File Format 1.1\r\n")
+	_, err := w.writeRecord(http.Header{
+		"WARC-Type":      {"warcinfo"},
+		"WARC-Record-ID": {newRecordID()},
+		"WARC-Date":      {time.Now().UTC().Format(time.RFC3339)},
+		"WARC-Filename":  {w.filename},
+		"Content-Type":   {"application/warc-fields"},
+	}, info)
+	return err
+}
+
+// writeRecord writes a single gzip member containing one WARC record and returns its compressed length.
+// Must be called with the mutex held.
+func (w *Writer) writeRecord(h http.Header, block []byte) (int64, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+
+	fmt.Fprint(gz, "WARC/1.1\r\n")
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(gz, "%s: %s\r\n", k, h[k][0])
+	}
+	fmt.Fprintf(gz, "Content-Length: %d\r\n\r\n", len(block))
+	gz.Write(block)
+	fmt.Fprint(gz, "\r\n\r\n")
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	n, err := w.file.Write(buf.Bytes())
+	w.offset += int64(n)
+	return int64(n), err
+}
+
+func (w *Writer) writeIndexEntry(e IndexEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.index, "%s %s %s\n", e.URL, e.Timestamp.Format(timestampFormat), b)
+	return err
+}
+
+func headerWithoutLength(h http.Header) http.Header {
+	c := h.Clone()
+	// the body is stored decoded and in full
+	c.Del("Content-Length")
+	c.Del("Transfer-Encoding")
+	return c
+}
+
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func newRecordID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// IndexEntry is one line of the CDXJ index, pointing at a response record.
+type IndexEntry struct {
+	URL       string    `json:"url"`
+	Timestamp time.Time `json:"-"`
+	Status    int       `json:"status"`
+	Mime      string    `json:"mime,omitempty"`
+	Digest    string    `json:"digest"`
+	Filename  string    `json:"filename"`
+	Offset    int64     `json:"offset"`
+	Length    int64     `json:"length"`
+}
+
+func (e IndexEntry) String() string {
+	return e.URL + " " + e.Timestamp.Format(timestampFormat) + " " + e.Filename + ":" + strconv.FormatInt(e.Offset, 10)
+}
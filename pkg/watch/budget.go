@@ -0,0 +1,61 @@
+package watch
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// budget is a transport that makes at most n requests in any window, holding requests back until the
+// oldest request in the window is old enough to make room
+type budget struct {
+	base   http.RoundTripper
+	n      int
+	window time.Duration
+	logger *slog.Logger
+
+	mutex sync.Mutex
+	sent  []time.Time
+	// waiting is whether the budget has been used up, so that's only logged once each time it happens
+	waiting bool
+}
+
+func (b *budget) RoundTrip(req *http.Request) (*http.Response, error) {
+	for {
+		wait := b.reserve(time.Now())
+		if wait == 0 {
+			return b.base.RoundTrip(req)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// reserve takes a slot for a request at now, or returns how long to wait for one to come free
+func (b *budget) reserve(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	i := 0
+	for i < len(b.sent) && now.Sub(b.sent[i]) >= b.window {
+		i++
+	}
+	b.sent = b.sent[i:]
+
+	if len(b.sent) < b.n {
+		b.sent = append(b.sent, now)
+		b.waiting = false
+		return 0
+	}
+	if !b.waiting {
+		b.waiting = true
+		b.logger.Warn("request budget used up, waiting", "budget", b.n, "window", b.window)
+	}
+	return b.window - now.Sub(b.sent[0])
+}
@@ -0,0 +1,106 @@
+package watch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+// Target is a product to watch.
+type Target struct {
+	ProdID string
+	CatID  string
+	Name   string
+}
+
+// LoadWatchlist reads a watchlist file: one product per line, either as a product URL or as "<prodId> <catId>".
+// Blank lines and lines starting with # are skipped.
+func LoadWatchlist(path string) ([]Target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	targets := []Target{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		t, err := parseTarget(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
+
+func parseTarget(text string) (Target, error) {
+	if fields := strings.Fields(text); len(fields) == 2 {
+		return Target{ProdID: fields[0], CatID: fields[1]}, nil
+	}
+	u, err := url.Parse(text)
+	if err != nil {
+		return Target{}, err
+	}
+	q := u.Query()
+	if q.Get("prodId") == "" || q.Get("catId") == "" {
+		return Target{}, fmt.Errorf("%q is neither a product URL nor a prodId and catId", text)
+	}
+	return Target{ProdID: q.Get("prodId"), CatID: q.Get("catId")}, nil
+}
+
+// Discounted returns the products that were discounted, e.g. in the last scrape, to see how long their
+// discounts last.
+func Discounted(ps []scraper.Product) []Target {
+	targets := []Target{}
+	for _, p := range ps {
+		if p.Percentage > 0 {
+			targets = append(targets, Target{ProdID: p.ProdID, CatID: p.CatID, Name: p.Name})
+		}
+	}
+	return targets
+}
+
+// Categories returns every product listed in the categories with the given IDs (catId).
+func Categories(ctx context.Context, client *scraper.Client, catIDs []string) ([]Target, error) {
+	targets := []Target{}
+	for _, catID := range catIDs {
+		tiles, err := client.ListCategory(ctx, catID)
+		if err != nil {
+			return nil, fmt.Errorf("category %s: %w", catID, err)
+		}
+		for _, t := range tiles {
+			targets = append(targets, Target{ProdID: t.ProdID, CatID: t.CatID, Name: t.Name})
+		}
+	}
+	return targets, nil
+}
+
+// dedupe drops repeated products, keeping the first one with a name
+func dedupe(targets []Target) []Target {
+	index := map[string]int{}
+	unique := []Target{}
+	for _, t := range targets {
+		key := t.ProdID + "/" + t.CatID
+		if i, ok := index[key]; ok {
+			if unique[i].Name == "" {
+				unique[i].Name = t.Name
+			}
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, t)
+	}
+	return unique
+}
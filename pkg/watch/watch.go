@@ -0,0 +1,283 @@
+// Package watch keeps polling the discount fragment of a few products, to catch discounts that come and go
+// between full crawls.
+//
+// Each product is polled on its own jittered interval, and every change in what its discount fragment shows is
+// reported as a Transition. All requests, including the ones that start sessions and list the categories to
+// watch, count towards a request budget; when it's used up, polls wait for room rather than going over.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+const (
+	defaultInterval     = 5 * time.Minute
+	defaultBudgetWindow = time.Hour
+	defaultRelist       = time.Hour
+)
+
+// State is what a product's discount fragment showed.
+type State struct {
+	// Available is false when the site redirected to its error page, e.g. because the product was taken off
+	Available bool `json:"available"`
+	// Percentage and Price are those of the member's discount tier, or the best one when not logged in, as in a
+	// scraped Product; 0 if not discounted
+	Percentage float64 `json:"percentage"`
+	Price      float64 `json:"price,omitempty"`
+}
+
+// Discounted says whether the product was on discount.
+func (s State) Discounted() bool {
+	return s.Available && s.Percentage > 0
+}
+
+// Transition is a change in a product's state.
+type Transition struct {
+	Time   time.Time `json:"time"`
+	ProdID string    `json:"prodId"`
+	CatID  string    `json:"catId"`
+	Name   string    `json:"name,omitempty"`
+	// From is nil for the first poll of a product
+	From *State `json:"from,omitempty"`
+	To   State  `json:"to"`
+}
+
+// Config configures a Watcher.
+type Config struct {
+	// Interval is how often each product is polled (default 5 minutes)
+	Interval time.Duration
+	// Jitter is up to how much earlier or later than Interval each poll is made, at random
+	Jitter time.Duration
+	// Categories are category IDs (catId) whose products are watched as well as the targets given to New; they
+	// are listed when Run starts, then every Relist (default 1 hour) to pick up new products. Products that
+	// disappear from a category are still polled, so that it shows up as them becoming unavailable.
+	Categories []string
+	Relist     time.Duration
+	// Budget is the most requests made in any BudgetWindow (default 1 hour); 0 for no limit
+	Budget       int
+	BudgetWindow time.Duration
+	// HTTPClient is the client requests are made with, nil for the scraper.Client default
+	HTTPClient *http.Client
+	// Credentials log the watcher in as a member (experimental, see scraper.WithCredentials), nil to stay logged out
+	Credentials *scraper.Credentials
+	// OnTransition is called with every transition, from one goroutine
+	OnTransition func(t Transition)
+	Logger       *slog.Logger
+}
+
+type watched struct {
+	Target
+	state *State
+	next  time.Time
+}
+
+// Watcher polls a set of products.
+type Watcher struct {
+	cfg     Config
+	client  *scraper.Client
+	targets []Target
+	rand    *rand.Rand
+}
+
+// New creates a watcher for the targets, and the products of cfg.Categories, in the shop at baseURL.
+func New(baseURL string, targets []Target, cfg Config) (*Watcher, error) {
+	if len(targets) == 0 && len(cfg.Categories) == 0 {
+		return nil, errors.New("nothing to watch")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Jitter < 0 || cfg.Jitter >= cfg.Interval {
+		return nil, fmt.Errorf("jitter %s should be less than the interval %s", cfg.Jitter, cfg.Interval)
+	}
+	if cfg.BudgetWindow <= 0 {
+		cfg.BudgetWindow = defaultBudgetWindow
+	}
+	if cfg.Relist <= 0 {
+		cfg.Relist = defaultRelist
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	httpClient := &http.Client{Timeout: 60 * time.Second}
+	if cfg.HTTPClient != nil {
+		copied := *cfg.HTTPClient
+		httpClient = &copied
+	}
+	if cfg.Budget > 0 {
+		base := httpClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		httpClient.Transport = &budget{base: base, n: cfg.Budget, window: cfg.BudgetWindow, logger: cfg.Logger}
+	}
+
+	client, err := scraper.NewClient(baseURL, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	client.SetLogger(cfg.Logger)
+	if cfg.Credentials != nil {
+		client.SetCredentials(*cfg.Credentials)
+	}
+
+	return &Watcher{cfg: cfg, client: client, targets: targets, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+}
+
+// Run lists the categories to watch and polls the products until ctx is cancelled, and returns ctx's error.
+// It also fails if the categories can't be listed at first or the member's level can't be found out; later
+// listings that fail are logged and tried again after Relist.
+func (w *Watcher) Run(ctx context.Context) error {
+	targets := w.targets
+	if len(w.cfg.Categories) > 0 {
+		ts, err := Categories(ctx, w.client, w.cfg.Categories)
+		if err != nil {
+			return fmt.Errorf("could not list categories: %w", err)
+		}
+		targets = append(targets[:len(targets):len(targets)], ts...)
+	}
+	targets = dedupe(targets)
+	if len(targets) == 0 {
+		return errors.New("nothing to watch")
+	}
+	// the fragment has every tier, so the member's level says which one applies
+	level, err := w.client.MemberLevel(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get member level: %w", err)
+	}
+
+	// each poll takes at least one request, as does listing each category
+	polls := float64(len(targets)) * float64(w.cfg.BudgetWindow) / float64(w.cfg.Interval)
+	polls += float64(len(w.cfg.Categories)) * float64(w.cfg.BudgetWindow) / float64(w.cfg.Relist)
+	if w.cfg.Budget > 0 && polls > float64(w.cfg.Budget) {
+		w.cfg.Logger.Warn("request budget is too small to poll every product on time, polls will be late",
+			"products", len(targets), "interval", w.cfg.Interval, "budget", w.cfg.Budget, "window", w.cfg.BudgetWindow)
+	}
+
+	watching := []*watched{}
+	now := time.Now()
+	for i, t := range targets {
+		// spread the first polls over the interval rather than starting with a burst
+		watching = append(watching, &watched{Target: t, next: now.Add(w.cfg.Interval * time.Duration(i) / time.Duration(len(targets)))})
+	}
+
+	w.cfg.Logger.Info("watching products", "count", len(watching), "interval", w.cfg.Interval, "jitter", w.cfg.Jitter, "memberLevel", level)
+	relistAt := now.Add(w.cfg.Relist)
+	for {
+		t := due(watching)
+		next := t.next
+		relisting := len(w.cfg.Categories) > 0 && relistAt.Before(next)
+		if relisting {
+			next = relistAt
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if relisting {
+			watching = w.relist(ctx, watching)
+			relistAt = time.Now().Add(w.cfg.Relist)
+			continue
+		}
+		w.poll(ctx, t, level)
+		t.next = time.Now().Add(w.interval())
+	}
+}
+
+// relist lists the categories again and adds the products that are new to watching. The new products' first
+// polls are spread over the interval.
+func (w *Watcher) relist(ctx context.Context, watching []*watched) []*watched {
+	ts, err := Categories(ctx, w.client, w.cfg.Categories)
+	if err != nil {
+		if ctx.Err() == nil {
+			w.cfg.Logger.Warn("could not list categories again, watching the same products", logging.KeyError, err)
+		}
+		return watching
+	}
+
+	known := map[string]bool{}
+	for _, t := range watching {
+		known[t.ProdID+"/"+t.CatID] = true
+	}
+	added := []Target{}
+	for _, t := range dedupe(ts) {
+		if !known[t.ProdID+"/"+t.CatID] {
+			added = append(added, t)
+		}
+	}
+	now := time.Now()
+	for i, t := range added {
+		watching = append(watching, &watched{Target: t, next: now.Add(w.cfg.Interval * time.Duration(i) / time.Duration(len(added)))})
+	}
+	if len(added) > 0 {
+		w.cfg.Logger.Info("found new products", "count", len(added), "watching", len(watching))
+	}
+	return watching
+}
+
+// due returns the target to poll next
+func due(targets []*watched) *watched {
+	next := targets[0]
+	for _, t := range targets[1:] {
+		if t.next.Before(next.next) {
+			next = t
+		}
+	}
+	return next
+}
+
+func (w *Watcher) interval() time.Duration {
+	if w.cfg.Jitter == 0 {
+		return w.cfg.Interval
+	}
+	return w.cfg.Interval - w.cfg.Jitter + time.Duration(w.rand.Int63n(int64(2*w.cfg.Jitter)))
+}
+
+// poll fetches t's discount fragment, taking the tier of the given member level like the scraper does
+func (w *Watcher) poll(ctx context.Context, t *watched, memberLevel int) {
+	discounts, err := w.client.GetDiscounts(ctx, t.ProdID, t.CatID)
+	state := State{Available: true}
+	switch {
+	case errors.Is(err, scraper.ErrRedirectToErrorPage):
+		state.Available = false
+	case err != nil:
+		// can't tell what state it's in, so try again next time
+		if ctx.Err() == nil {
+			w.cfg.Logger.Warn("could not poll product", logging.KeyProdID, t.ProdID, logging.KeyCatID, t.CatID, logging.KeyError, err)
+		}
+		return
+	default:
+		p := scraper.ApplyDiscounts(scraper.Product{MemberLevel: memberLevel}, discounts)
+		state.Percentage = p.Percentage
+		state.Price = p.Price
+	}
+
+	if t.state != nil && *t.state == state {
+		return
+	}
+	transition := Transition{Time: time.Now(), ProdID: t.ProdID, CatID: t.CatID, Name: t.Name, From: t.state, To: state}
+	level := slog.LevelInfo
+	if t.state == nil {
+		level = slog.LevelDebug
+	}
+	w.cfg.Logger.Log(ctx, level, "product changed", logging.KeyProdID, t.ProdID, logging.KeyCatID, t.CatID, "name", t.Name,
+		"available", state.Available, "percentage", state.Percentage)
+	t.state = &state
+	if w.cfg.OnTransition != nil {
+		w.cfg.OnTransition(transition)
+	}
+}
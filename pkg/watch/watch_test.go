@@ -0,0 +1,239 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geniass/ebucks-dealz/pkg/ebuckstest"
+	"github.com/geniass/ebucks-dealz/pkg/logging"
+	"github.com/geniass/ebucks-dealz/pkg/scraper"
+)
+
+func TestWatcherRecordsTransitions(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	products[0].Discounts = []int{10, 40}
+	products[1].Discounts = nil
+	products[2].Discounts = nil
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	rec := &recorder{}
+	targets := []Target{}
+	for _, p := range products {
+		targets = append(targets, Target{ProdID: p.ProdID, CatID: p.CatID})
+	}
+	w, err := New(ts.URL, targets, Config{
+		Interval:     50 * time.Millisecond,
+		Jitter:       10 * time.Millisecond,
+		OnTransition: rec.add,
+		Logger:       logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	waitFor(t, func() bool { return len(rec.get()) == 3 })
+	ts.RemoveProduct(products[0].CatID, products[0].ProdID)
+	products[1].Discounts = []int{20}
+	ts.PutProduct(products[1])
+	waitFor(t, func() bool { return len(rec.get()) == 5 })
+	// a few more rounds, which shouldn't change anything
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run should stop when cancelled: got %v", err)
+	}
+
+	byProduct := map[string][]Transition{}
+	for _, tr := range rec.get() {
+		byProduct[tr.ProdID] = append(byProduct[tr.ProdID], tr)
+	}
+	if trs := byProduct[products[0].ProdID]; len(trs) != 2 || trs[0].From != nil || trs[0].To.Percentage != 40 || !trs[1].From.Discounted() || trs[1].To.Available {
+		t.Errorf("discount should have gone with the product: %+v", trs)
+	}
+	if trs := byProduct[products[1].ProdID]; len(trs) != 2 || trs[1].From.Discounted() || trs[1].To.Percentage != 20 || trs[1].Time.Before(trs[0].Time) {
+		t.Errorf("discount should have appeared: %+v", trs)
+	}
+	if trs := byProduct[products[2].ProdID]; len(trs) != 1 || trs[0].To.Discounted() || !trs[0].To.Available {
+		t.Errorf("unchanged product should only have its first state: %+v", trs)
+	}
+}
+
+func TestWatcherStaysWithinBudget(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	targets := []Target{}
+	for _, p := range products {
+		targets = append(targets, Target{ProdID: p.ProdID, CatID: p.CatID})
+	}
+	w, err := New(ts.URL, targets, Config{Interval: 10 * time.Millisecond, Budget: 5, BudgetWindow: time.Hour, Logger: logging.Discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	if ts.RequestCount() != 5 {
+		t.Errorf("should make exactly the budgeted requests: got %d expected 5", ts.RequestCount())
+	}
+}
+
+func TestWatcherUsesMemberTier(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 1)
+	products[0].Discounts = []int{10, 40}
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+	ts.AddMember("member", "secret", 1)
+
+	rec := &recorder{}
+	w, err := New(ts.URL, []Target{{ProdID: products[0].ProdID, CatID: products[0].CatID}}, Config{
+		Interval:     time.Hour,
+		Credentials:  &scraper.Credentials{Username: "member", Password: "secret"},
+		OnTransition: rec.add,
+		Logger:       logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+	waitFor(t, func() bool { return len(rec.get()) == 1 })
+	cancel()
+	<-done
+
+	if p := rec.get()[0].To.Percentage; p != 10 {
+		t.Errorf("should report the level 1 tier: got %v%% expected 10%%", p)
+	}
+}
+
+func TestWatcherListsCategoriesWithinBudget(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	ts := ebuckstest.NewServer(products)
+	defer ts.Close()
+
+	w, err := New(ts.URL, nil, Config{Categories: []string{"1"}, Interval: 10 * time.Millisecond, Budget: 5, BudgetWindow: time.Hour, Logger: logging.Discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	w.Run(ctx)
+
+	if ts.Hits(ebuckstest.CategoryPath) == 0 {
+		t.Error("the category should have been listed")
+	}
+	if ts.RequestCount() != 5 {
+		t.Errorf("listing categories should count towards the budget: got %d requests expected 5", ts.RequestCount())
+	}
+}
+
+func TestWatcherRelistsCategories(t *testing.T) {
+	products := ebuckstest.MakeProducts("1", 3)
+	ts := ebuckstest.NewServer(products[:1])
+	defer ts.Close()
+
+	rec := &recorder{}
+	w, err := New(ts.URL, nil, Config{
+		Categories:   []string{"1"},
+		Relist:       50 * time.Millisecond,
+		Interval:     100 * time.Millisecond,
+		OnTransition: rec.add,
+		Logger:       logging.Discard(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	waitFor(t, func() bool { return len(rec.get()) == 1 })
+	ts.PutProduct(products[1])
+	ts.PutProduct(products[2])
+	waitFor(t, func() bool { return len(rec.get()) == 3 })
+	cancel()
+	<-done
+
+	seen := map[string]bool{}
+	for _, tr := range rec.get() {
+		seen[tr.ProdID] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("products added to the category should be picked up: got %v", rec.get())
+	}
+}
+
+func TestLoadWatchlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watchlist.txt")
+	content := `# flash deals
+https://www.ebucks.com/web/shop/productSelected.do?prodId=1&catId=2
+
+3 4
+3 4
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := LoadWatchlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 || targets[0] != (Target{ProdID: "1", CatID: "2"}) || targets[1] != (Target{ProdID: "3", CatID: "4"}) {
+		t.Errorf("wrong targets: %+v", targets)
+	}
+	if len(dedupe(targets)) != 2 {
+		t.Errorf("repeated products should be watched once")
+	}
+
+	if err := os.WriteFile(path, []byte("1 2\nnot a product\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWatchlist(path); err == nil {
+		t.Errorf("bad line should be an error")
+	}
+}
+
+type recorder struct {
+	mutex       sync.Mutex
+	transitions []Transition
+}
+
+func (r *recorder) add(t Transition) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.transitions = append(r.transitions, t)
+}
+
+func (r *recorder) get() []Transition {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Transition{}, r.transitions...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
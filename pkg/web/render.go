@@ -2,8 +2,10 @@ package web
 
 import (
 	"embed"
+	"fmt"
 	"html/template"
 	"io"
+	"log/slog"
 	"time"
 
 	"github.com/geniass/ebucks-dealz/pkg/scraper"
@@ -12,6 +14,14 @@ import (
 //go:embed templates
 var templatesFs embed.FS
 
+// Defaults of the settings of a Site.
+const (
+	DefaultDiscountTitle = "Discounted (40%)"
+	DefaultOtherTitle    = "Other Products"
+	// DefaultTimezone is the timezone times are shown in, that of the shop
+	DefaultTimezone = "Africa/Johannesburg"
+)
+
 type BaseContext struct {
 	PathPrefix string
 }
@@ -21,17 +31,24 @@ type DealzContext struct {
 	Title       string
 	LastUpdated time.Time
 	Products    []scraper.Product
+	// Location is the timezone LastUpdated is shown in (default DefaultTimezone)
+	Location *time.Location
 }
 
 func (c DealzContext) FormattedLastUpdated() string {
-	loc, err := time.LoadLocation("Africa/Johannesburg")
-	if err != nil {
-		loc = time.UTC
+	loc := c.Location
+	if loc == nil {
+		var err error
+		if loc, err = time.LoadLocation(DefaultTimezone); err != nil {
+			loc = time.UTC
+		}
 	}
 	return c.LastUpdated.In(loc).Format("2006-01-02T15:04:05 MST")
 }
 
-func RenderDealz(w io.Writer, c DealzContext) error {
+// RenderDealz renders a page of deals.
+func RenderDealz(w io.Writer, c DealzContext, logger *slog.Logger) error {
+	logger.Debug("rendering dealz page", "title", c.Title, "products", len(c.Products))
 	t, err := template.ParseFS(templatesFs, "templates/dealz.html.tpl")
 	if err != nil {
 		return err
@@ -64,3 +81,56 @@ func RenderHome(w io.Writer, c BaseContext) error {
 	}
 	return nil
 }
+
+// Pages are the file names of the site's pages. The templates link to them without the .html extension.
+var Pages = []string{"index.html", "discount.html", "other.html"}
+
+// Site is what the site's pages are rendered from.
+type Site struct {
+	BaseContext
+	LastUpdated time.Time
+	Products    []scraper.Product
+	// DiscountTitle and OtherTitle are the titles of the pages of discounted and other products (default
+	// DefaultDiscountTitle and DefaultOtherTitle)
+	DiscountTitle string
+	OtherTitle    string
+	// Location is the timezone times are shown in (default DefaultTimezone)
+	Location *time.Location
+	// Logger is what rendering logs to; nil means slog.Default()
+	Logger *slog.Logger
+}
+
+// RenderPage renders one of Pages, with the products it lists.
+func (s Site) RenderPage(w io.Writer, page string) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	switch page {
+	case "index.html":
+		return RenderHome(w, s.BaseContext)
+	case "discount.html":
+		return RenderDealz(w, s.dealz(orDefault(s.DiscountTitle, DefaultDiscountTitle), func(p scraper.Product) bool { return p.Percentage > 0 }), logger)
+	case "other.html":
+		return RenderDealz(w, s.dealz(orDefault(s.OtherTitle, DefaultOtherTitle), func(p scraper.Product) bool { return p.Percentage == 0 }), logger)
+	default:
+		return fmt.Errorf("no page %q", page)
+	}
+}
+
+func (s Site) dealz(title string, include func(p scraper.Product) bool) DealzContext {
+	c := DealzContext{BaseContext: s.BaseContext, Title: title, LastUpdated: s.LastUpdated, Products: []scraper.Product{}, Location: s.Location}
+	for _, p := range s.Products {
+		if include(p) {
+			c.Products = append(c.Products, p)
+		}
+	}
+	return c
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}